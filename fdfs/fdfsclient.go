@@ -3,8 +3,7 @@ package fdfs
 import (
 	//"errors"
 	"fmt"
-	cat "github.com/ctripcorp/cat.go"
-	"github.com/ctripcorp/nephele/util"
+	"github.com/ctripcorp/nephele/telemetry"
 	"math/rand"
 	"strconv"
 	"sync"
@@ -12,7 +11,7 @@ import (
 
 type FdfsClient interface {
 	// download to buffer
-	DownloadToBuffer(fileId string, catInstance cat.Cat) ([]byte, error)
+	DownloadToBuffer(fileId string, t telemetry.Telemetry) ([]byte, error)
 
 	// upload by buffer
 	UploadByBuffer(groupName string, filebuffer []byte, fileExtName string) (string, error)
@@ -22,15 +21,9 @@ type FdfsClient interface {
 
 	// delete file
 	DeleteFile(remoteFileId string) error
-}
-
-//cat instance transferred by user
-//var userCat cat.Cat
-var globalCat cat.Cat
 
-func init() {
-	util.InitCat()
-	globalCat = cat.Instance()
+	// active test of the tracker
+	ActiveTest() error
 }
 
 type fdfsClient struct {
@@ -60,11 +53,11 @@ func NewFdfsClient(trackerHosts []string, trackerPort string) (FdfsClient, error
 	return &fdfsClient{tracker: tc, storages: make(map[string]*storageClient)}, nil
 }
 
-func (this *fdfsClient) DownloadToBuffer(fileId string, catInstance cat.Cat) ([]byte, error) {
-	//if catInstance == nil {
+func (this *fdfsClient) DownloadToBuffer(fileId string, t telemetry.Telemetry) ([]byte, error) {
+	//if t == nil {
 	//	return nil, errors.New("cat instance transferred to fdfs is nil")
 	//}
-	buff, err := this.downloadToBufferByOffset(fileId, 0, 0, catInstance)
+	buff, err := this.downloadToBufferByOffset(fileId, 0, 0, t)
 	if err != nil {
 		return nil, err
 	}
@@ -132,7 +125,11 @@ func (this *fdfsClient) DeleteFile(remoteFileId string) error {
 	return storeClient.storageDeleteFile(storeInfo, remoteFilename)
 }
 
-func (this *fdfsClient) downloadToBufferByOffset(fileId string, offset int64, downloadSize int64, catInstance cat.Cat) ([]byte, error) {
+func (this *fdfsClient) ActiveTest() error {
+	return this.tracker.activeTest()
+}
+
+func (this *fdfsClient) downloadToBufferByOffset(fileId string, offset int64, downloadSize int64, t telemetry.Telemetry) ([]byte, error) {
 	//split file id to two parts: group name and file name
 	tmp, err := splitRemoteFileId(fileId)
 	if err != nil || len(tmp) != 2 {
@@ -146,8 +143,8 @@ func (this *fdfsClient) downloadToBufferByOffset(fileId string, offset int64, do
 	if err != nil {
 		return nil, err
 	}
-	if catInstance != nil {
-		event := catInstance.NewEvent("ImgFromStorage", fmt.Sprintf("%s:%s", storeInfo.groupName, storeInfo.ipAddr))
+	if t != nil {
+		event := t.NewEvent("ImgFromStorage", fmt.Sprintf("%s:%s", storeInfo.groupName, storeInfo.ipAddr))
 		event.SetStatus("0")
 		event.Complete()
 	}
@@ -1,7 +1,7 @@
 package fdfs
 
 import (
-	cat "github.com/ctripcorp/nephele/Godeps/_workspace/src/github.com/ctripcorp/cat.go"
+	"github.com/ctripcorp/nephele/telemetry"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -15,8 +15,7 @@ func TestDownloadToBuffer(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	catInstance := cat.Instance()
-	b, err := fdfsClient.DownloadToBuffer("group1/M00/18/91/CgIZH1RyormAP7xTAAA1U2y_hqk858.jpg", catInstance)
+	b, err := fdfsClient.DownloadToBuffer("group1/M00/18/91/CgIZH1RyormAP7xTAAA1U2y_hqk858.jpg", telemetry.Instance())
 	if err != nil {
 		t.Error(err)
 	}
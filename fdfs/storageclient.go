@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/ctripcorp/nephele/Godeps/_workspace/src/github.com/ctripcorp/ghost/pool"
+	"github.com/ctripcorp/nephele/telemetry"
 	"net"
 	"os"
 	"time"
@@ -214,7 +215,7 @@ func (this *storageClient) storageUploadFile(storeInfo *storageInfo, fileBuff []
 //factory method used to dial
 func (this *storageClient) makeConn() (net.Conn, error) {
 	addr := fmt.Sprintf("%s:%d", this.host, this.port)
-	event := globalCat.NewEvent("DialStorage", addr)
+	event := telemetry.Instance().NewEvent("DialStorage", addr)
 	defer func() {
 		event.Complete()
 	}()
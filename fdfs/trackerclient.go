@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/ctripcorp/nephele/Godeps/_workspace/src/github.com/ctripcorp/ghost/pool"
+	"github.com/ctripcorp/nephele/telemetry"
 	"net"
 	"time"
 )
@@ -127,10 +128,33 @@ func (this *trackerClient) trackerQueryStorage(groupName string, fileName string
 	return &storageInfo{ipAddr, int(port), groupName, int(storePathIndex)}, nil
 }
 
+//active test on a pooled connection, a broken connection is dropped by the pool
+func (this *trackerClient) activeTest() error {
+	conn, err := this.Get()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	buffer := new(bytes.Buffer)
+	//package length
+	binary.Write(buffer, binary.BigEndian, int64(0))
+	//cmd
+	buffer.WriteByte(byte(FDFS_PROTO_CMD_ACTIVE_TEST))
+	//status
+	buffer.WriteByte(byte(0))
+	if err := tcpSend(conn, buffer.Bytes(), TRACKER_NETWORK_TIMEOUT); err != nil {
+		errMsg := fmt.Sprintf("send to tracker server %v fail, error info: %v", conn.RemoteAddr().String(), err.Error())
+		return errors.New(errMsg)
+	}
+	_, err = recvResponse(conn, TRACKER_NETWORK_TIMEOUT)
+	return err
+}
+
 //factory method used for dial
 func (this *trackerClient) makeConn() (net.Conn, error) {
 	addr := fmt.Sprintf("%s:%d", this.host, this.port)
-	event := globalCat.NewEvent("DialTracker", addr)
+	event := telemetry.Instance().NewEvent("DialTracker", addr)
 	defer func() {
 		event.Complete()
 	}()
@@ -0,0 +1,112 @@
+package imgsvr
+
+import (
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"github.com/ctripcorp/nephele/imgsvr/data"
+	"github.com/ctripcorp/nephele/util"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+//accessLog is nil if the access log is disabled
+var accessLog *accessLogger
+
+type accessLogger struct {
+	writer io.Writer
+	sample float64
+}
+
+//InitLog sets the level and the rotated output of the service log, and
+//opens the access log
+func InitLog(config data.LogPolicy) error {
+	level, err := log.ParseLevel(config.Level)
+	if err != nil {
+		return err
+	}
+	maxSize := int64(config.MaxSize) * 1024 * 1024
+	log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+	log.SetOutput(util.NewRotateWriter(config.File, maxSize, config.MaxBackups))
+	log.SetLevel(level)
+	if config.AccessLog == "" {
+		accessLog = nil
+		return nil
+	}
+	accessLog = &accessLogger{util.NewRotateWriter(config.AccessLog, maxSize, config.MaxBackups), config.AccessLogSample}
+	return nil
+}
+
+//sampled decides whether a request is written to the access log
+func (this *accessLogger) sampled() bool {
+	return this != nil && this.sample > 0 && (this.sample >= 1 || rand.Float64() < this.sample)
+}
+
+type accessEntry struct {
+	Time      string          `json:"time"`
+	Uri       string          `json:"uri"`
+	Channel   string          `json:"channel"`
+	Storage   string          `json:"storage,omitempty"`
+	Status    string          `json:"status"`
+	Error     string          `json:"error,omitempty"`
+	ClientIP  string          `json:"clientip"`
+	Referer   string          `json:"referer,omitempty"`
+	BytesIn   int             `json:"bytesIn"`
+	BytesOut  int             `json:"bytesOut"`
+	WidthIn   int64           `json:"widthIn,omitempty"`
+	HeightIn  int64           `json:"heightIn,omitempty"`
+	WidthOut  int64           `json:"widthOut,omitempty"`
+	HeightOut int64           `json:"heightOut,omitempty"`
+	TotalMs   float64         `json:"totalMs"`
+	Stages    []accessStageMs `json:"stages"`
+}
+
+type accessStageMs struct {
+	Name string  `json:"name"`
+	Ms   float64 `json:"ms"`
+}
+
+//writeAccess writes the access log line of a sampled request. The
+//processing stages are only read if processed.
+func (t *requestTiming) writeAccess(request *http.Request, channel, storage, status, errType string, total time.Duration, processed bool) {
+	if t.access == nil {
+		return
+	}
+	e := &accessEntry{
+		Time:     time.Now().Format(time.RFC3339Nano),
+		Uri:      request.URL.String(),
+		Channel:  channel,
+		Storage:  storage,
+		Status:   status,
+		Error:    errType,
+		ClientIP: util.GetClientIP(request),
+		Referer:  request.Referer(),
+		BytesIn:  t.inBytes,
+		TotalMs:  milliseconds(total),
+		Stages:   []accessStageMs{},
+	}
+	if t.fetched {
+		e.Stages = append(e.Stages, accessStageMs{"storage", milliseconds(t.storage)})
+	}
+	if processed {
+		e.WidthIn, e.HeightIn = t.inWidth, t.inHeight
+		e.Stages = append(e.Stages, accessStageMs{"queue", milliseconds(t.queue)}, accessStageMs{"decode", milliseconds(t.decode)})
+		for _, p := range t.processors {
+			e.Stages = append(e.Stages, accessStageMs{p.name, milliseconds(p.duration)})
+		}
+		if t.encoded {
+			e.BytesOut, e.WidthOut, e.HeightOut = t.outBytes, t.outWidth, t.outHeight
+			e.Stages = append(e.Stages, accessStageMs{"encode", milliseconds(t.encode)})
+		}
+	}
+	bts, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if _, err = t.access.writer.Write(append(bts, '\n')); err != nil {
+		log.WithFields(log.Fields{
+			"type": "AccessLog.WriteError",
+		}).Error(err.Error())
+	}
+}
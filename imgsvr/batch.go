@@ -0,0 +1,174 @@
+package imgsvr
+
+import (
+	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/imgsvr/proc"
+	"github.com/ctripcorp/nephele/imgsvr/storage"
+	"github.com/ctripcorp/nephele/telemetry"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//ProcessJob is an image url processed offline like Handler serves it. Src
+//is a local file used instead of the storage of the url if it isn't empty,
+//the result is written to Out if it isn't empty.
+type ProcessJob struct {
+	Uri string
+	Src string
+	Out string
+}
+
+//ProcessResult reports a job, bytes and pixels of the source and the result
+//and the timings of each stage
+type ProcessResult struct {
+	Uri       string          `json:"uri"`
+	Src       string          `json:"src,omitempty"`
+	Out       string          `json:"out,omitempty"`
+	Storage   string          `json:"storage,omitempty"`
+	BytesIn   int             `json:"bytesIn"`
+	BytesOut  int             `json:"bytesOut"`
+	WidthIn   int64           `json:"widthIn,omitempty"`
+	HeightIn  int64           `json:"heightIn,omitempty"`
+	WidthOut  int64           `json:"widthOut,omitempty"`
+	HeightOut int64           `json:"heightOut,omitempty"`
+	TotalMs   float64         `json:"totalMs"`
+	Stages    []accessStageMs `json:"stages"`
+	ErrorType string          `json:"errorType,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+//ProcessReport is the report of a batch
+type ProcessReport struct {
+	Jobs     int              `json:"jobs"`
+	Failed   int              `json:"failed"`
+	BytesIn  int64            `json:"bytesIn"`
+	BytesOut int64            `json:"bytesOut"`
+	TotalMs  float64          `json:"totalMs"`
+	Results  []*ProcessResult `json:"results"`
+}
+
+//ProcessBatch runs the jobs on parallel goroutines, the results are in the
+//order of the jobs
+func ProcessBatch(jobs []ProcessJob, parallel int, Telemetry telemetry.Telemetry) *ProcessReport {
+	if parallel < 1 {
+		parallel = 1
+	}
+	start := time.Now()
+	r := &ProcessReport{Jobs: len(jobs), Results: make([]*ProcessResult, len(jobs))}
+	c := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range c {
+				r.Results[j] = ProcessImage(jobs[j], Telemetry)
+			}
+		}()
+	}
+	for j := range jobs {
+		c <- j
+	}
+	close(c)
+	wg.Wait()
+	for _, result := range r.Results {
+		if result.ErrorType != "" {
+			r.Failed++
+		}
+		r.BytesIn += int64(result.BytesIn)
+		r.BytesOut += int64(result.BytesOut)
+	}
+	r.TotalMs = milliseconds(time.Since(start))
+	return r
+}
+
+//ProcessImage builds the chain of the url, fetches the image from its
+//storage or the local file and processes it by GraphicsMagick. The error
+//types are those of Handler.
+func ProcessImage(job ProcessJob, Telemetry telemetry.Telemetry) *ProcessResult {
+	start := time.Now()
+	r := &ProcessResult{Uri: job.Uri, Src: job.Src, Out: job.Out, Stages: []accessStageMs{}}
+	defer func() {
+		r.TotalMs = milliseconds(time.Since(start))
+	}()
+	params, isDigimarkUrl, ok := matchUri(job.Uri)
+	if !ok {
+		r.fail("URI.ParseError", "")
+		return r
+	}
+	builder := &ProcChainBuilder{Telemetry: Telemetry}
+	var chain *proc.ProcessorChain
+	var buildErr *buildError
+	if isDigimarkUrl {
+		chain, buildErr = builder.DigimarkProcChain(params)
+	} else {
+		chain, buildErr = builder.Build(params)
+	}
+	if buildErr != nil {
+		r.fail(buildErr.Type(), buildErr.Error())
+		return r
+	}
+
+	timing := &requestTiming{debug: true}
+	var bts []byte
+	var err error
+	fetchStart := time.Now()
+	if job.Src != "" {
+		r.Storage = "Local"
+		bts, err = ioutil.ReadFile(job.Src)
+	} else {
+		var store storage.Storage
+		if store, r.Storage, err = FindStorage(params, Telemetry); err != nil {
+			r.fail("Storage.ParseError", err.Error())
+			return r
+		}
+		bts, err = store.GetImage()
+	}
+	timing.setStorage(time.Since(fetchStart), len(bts))
+	r.Stages = append(r.Stages, accessStageMs{"storage", milliseconds(timing.storage)})
+	r.BytesIn = len(bts)
+	if err != nil {
+		r.fail(JoinString(r.Storage, ".", storageErrorType(err)), err.Error())
+		return r
+	}
+	if len(bts) == 0 {
+		r.fail(JoinString(r.Storage, ".ImgLenZero"), "recv image length is 0")
+		return r
+	}
+
+	img := &img4g.Image{Blob: bts, Format: params["ext"], Telemetry: Telemetry}
+	if err = chainProcImg(Telemetry, chain, img, timing); err != nil {
+		r.fail("ProcessError", err.Error())
+		return r
+	}
+	r.WidthIn, r.HeightIn = timing.inWidth, timing.inHeight
+	r.Stages = append(r.Stages, accessStageMs{"decode", milliseconds(timing.decode)})
+	for _, p := range timing.processors {
+		r.Stages = append(r.Stages, accessStageMs{p.name, milliseconds(p.duration)})
+	}
+	if !timing.encoded {
+		//chainProcImg recovered a panic
+		r.fail("ProcessImage.Panic", "")
+		return r
+	}
+	r.Stages = append(r.Stages, accessStageMs{"encode", milliseconds(timing.encode)})
+	r.BytesOut, r.WidthOut, r.HeightOut = timing.outBytes, timing.outWidth, timing.outHeight
+
+	if job.Out != "" {
+		err = os.MkdirAll(filepath.Dir(job.Out), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(job.Out, img.Blob, 0644)
+		}
+		if err != nil {
+			r.fail("Output.WriteError", err.Error())
+		}
+	}
+	return r
+}
+
+func (this *ProcessResult) fail(errType, err string) {
+	this.ErrorType, this.Error = errType, err
+}
@@ -0,0 +1,83 @@
+package imgsvr
+
+import (
+	"github.com/ctripcorp/nephele/imgsvr/data"
+	"github.com/ctripcorp/nephele/telemetry"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+var testConf = `quality=90
+sizes=,100x100,
+resizetypes=,c,
+sequenceofoperation=resize,f,q
+`
+
+//loadTestPolicy loads conf as the policy in use
+func loadTestPolicy(t *testing.T, conf string) {
+	file, err := ioutil.TempFile("", "nephele")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(conf)
+	file.Close()
+	if err = data.Load(file.Name()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessImageErrors(t *testing.T) {
+	loadTestPolicy(t, testConf)
+	dir, err := ioutil.TempDir("", "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	empty := filepath.Join(dir, "empty.jpg")
+	ioutil.WriteFile(empty, []byte{}, 0644)
+
+	cases := []struct {
+		job     ProcessJob
+		errType string
+	}{
+		{ProcessJob{Uri: "/images/tg/a.jpg"}, "URI.ParseError"},
+		{ProcessJob{Uri: "/images/tg/a_C_200_200.jpg"}, "UrlResizeCmdError"},
+		{ProcessJob{Uri: "/images/tg/a_C_100_100.jpg", Src: filepath.Join(dir, "missing.jpg")}, "Local.UnExpectedError"},
+		{ProcessJob{Uri: "/images/tg/a_C_100_100.jpg", Src: empty}, "Local.ImgLenZero"},
+	}
+	for _, c := range cases {
+		r := ProcessImage(c.job, telemetry.Noop)
+		if r.ErrorType != c.errType || r.Uri != c.job.Uri {
+			t.Errorf("%s: expect %s, got %s %s", c.job.Uri, c.errType, r.ErrorType, r.Error)
+		}
+	}
+}
+
+func TestProcessBatchOrder(t *testing.T) {
+	loadTestPolicy(t, testConf)
+	jobs := []ProcessJob{}
+	for i := 0; i < 20; i++ {
+		uri := "/images/tg/" + strconv.Itoa(i) + ".jpg"
+		if i%2 == 0 {
+			//not in sizes
+			uri = "/images/tg/" + strconv.Itoa(i) + "_C_200_200.jpg"
+		}
+		jobs = append(jobs, ProcessJob{Uri: uri})
+	}
+	r := ProcessBatch(jobs, 4, telemetry.Noop)
+	if r.Jobs != 20 || r.Failed != 20 || len(r.Results) != 20 {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	for i, result := range r.Results {
+		if result.Uri != jobs[i].Uri {
+			t.Errorf("result %d is of %s, expect %s", i, result.Uri, jobs[i].Uri)
+		}
+	}
+	if r.Results[0].ErrorType != "UrlResizeCmdError" || r.Results[1].ErrorType != "URI.ParseError" {
+		t.Error("each result should have the error of its job")
+	}
+}
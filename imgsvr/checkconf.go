@@ -0,0 +1,41 @@
+package imgsvr
+
+import (
+	"fmt"
+	"github.com/ctripcorp/nephele/imgsvr/data"
+	"io"
+)
+
+//CheckConf validates the config file and writes a per channel report to w,
+//it returns false if the file has any error
+func CheckConf(path string, w io.Writer) bool {
+	sections, errs, err := data.CheckFile(path, CheckStep)
+	if err != nil {
+		fmt.Fprintf(w, "%s: %s\n", path, err.Error())
+		return false
+	}
+	bySection := make(map[string][]*data.KeyError)
+	for _, e := range errs {
+		bySection[e.Channel] = append(bySection[e.Channel], e)
+	}
+	for _, section := range sections {
+		name := section
+		if name == "" {
+			name = "default"
+		}
+		if len(bySection[section]) == 0 {
+			fmt.Fprintf(w, "[%s] ok\n", name)
+			continue
+		}
+		fmt.Fprintf(w, "[%s] %d error(s)\n", name, len(bySection[section]))
+		for _, e := range bySection[section] {
+			fmt.Fprintf(w, "  %s=%s: %s\n", e.Key, e.Value, e.Err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(w, "%s: %d error(s)\n", path, len(errs))
+		return false
+	}
+	fmt.Fprintf(w, "%s: ok\n", path)
+	return true
+}
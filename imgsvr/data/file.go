@@ -2,156 +2,122 @@ package data
 
 import (
 	"github.com/Unknwon/goconfig"
-	"github.com/ctripcorp/nephele/util"
-	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
 )
 
-var lock chan int = make(chan int, 1)
+var source Source
 
-var instance *goconfig.ConfigFile
+//current *Policy, swapped as a whole on reload
+var current atomic.Value
 
-func init() {
-	if instance != nil {
-		return //nil
-	}
-	lock <- 1
-	//running environment
-	env := util.GetRunningEnv()
+var loadErrs PolicyErrors
+
+//error of loading the config, the policy in use is empty if it is set
+var (
+	fileErr   error
+	fileMutex sync.RWMutex
+)
 
-	var confFile string
-	switch env {
-	case "uat":
-		confFile = "../conf/uat_conf.ini"
-	case "prod":
-		confFile = "../conf/prod_conf.ini"
+//reloads and change checks of the source are serialized
+var reloadMutex sync.Mutex
+
+//Load selects the source of spec, see Source, and builds the policy from it.
+//If the config can't be loaded the policy in use is empty and FileError
+//returns the error until a Reload succeeds.
+func Load(spec string) error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	var p *Policy
+	var errs PolicyErrors
+	s, err := NewSource(spec)
+	if err == nil {
+		source = s
+		p, errs, err = load()
 	}
-	if instance == nil {
-		instance, _ = goconfig.LoadConfigFile(confFile)
-		return //err
+	fileMutex.Lock()
+	fileErr = err
+	fileMutex.Unlock()
+	if err != nil {
+		p, errs = emptyPolicy()
 	}
-	<-lock
-	return //nil
+	loadErrs = errs
+	current.Store(p)
+	return err
 }
 
-//key: nfs1,nfs2
-
-func GetFdfsDomain() (string, error) {
-	return getValue("", "fdfsdomain")
+func emptyPolicy() (*Policy, PolicyErrors) {
+	conf, _ := goconfig.LoadFromData([]byte{})
+	return BuildPolicy(conf)
 }
 
-func GetFdfsPort() int {
-	return mustInt("", "fdfsport", 22122)
+//load builds the policy of the source
+func load() (*Policy, PolicyErrors, error) {
+	conf, version, err := source.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	p, errs := BuildPolicy(conf)
+	p.Version = version
+	return p, errs, nil
 }
 
-func GetDirPath(channel, storagetype string) (string, error) {
-	//storagetype: nfs1,nfs2
-	return getValue(channel, storagetype)
+//Current returns the policy in use, callers should keep the returned
+//pointer for the whole request instead of calling Current repeatedly. The
+//policy is empty until Load is called.
+func Current() *Policy {
+	if p, ok := current.Load().(*Policy); ok {
+		return p
+	}
+	p, _ := emptyPolicy()
+	return p
 }
 
-func GetResizeTypes(channel string) (string, error) {
-	return getValue(channel, "resizetypes")
-}
-func GetSizes(channel string) (string, error) {
-	return getValue(channel, "sizes")
+//LoadErrors returns the invalid keys found when the service started
+func LoadErrors() PolicyErrors {
+	return loadErrs
 }
 
-func GetRotates(channel string) (string, error) {
-	return getValue(channel, "rotates")
+//FileError returns the error of loading the config, nil once a policy has
+//been built from the source
+func FileError() error {
+	fileMutex.RLock()
+	defer fileMutex.RUnlock()
+	return fileErr
 }
 
-func GetQuality(channel string) (string, error) {
-	return getValue(channel, "quality")
-}
-func GetQualities(channel string) (string, error) {
-	return getValue(channel, "qualities")
-}
-func GetLogodir(channel string) (string, error) {
-	return getValue(channel, "logodir")
-}
-func IsEnableNameLogo(channel string) (bool, error) {
-	isenable, err := getValue(channel, "isenablenamelogo")
-	if err != nil {
-		return false, err
-	}
-	if isenable == "1" {
-		return true, nil
-	} else {
-		return false, nil
-	}
-}
-func GetDefaultLogo(channel string) (string, error) {
-	return getValue(channel, "defaultlogo")
-}
-func GetLogoNames(channel string) (string, error) {
-	return getValue(channel, "logonames")
-}
-func GetImagelesswidthForLogo(channel string) (int64, error) {
-	width, err := getValue(channel, "imagelesswidthforlogo")
-	if err != nil {
-		return 0, err
-	}
-	if width == "" {
-		return 0, nil
-	}
-	return strconv.ParseInt(width, 10, 64)
-}
-func GetImagelessheightForLogo(channel string) (int64, error) {
-	height, err := getValue(channel, "imagelessheightforlogo")
-	if err != nil {
-		return 0, err
-	}
-	if height == "" {
-		return 0, nil
-	}
-	return strconv.ParseInt(height, 10, 64)
+//CurrentSource returns the source of the config, nil if Load failed to
+//select one
+func CurrentSource() Source {
+	return source
 }
 
-func GetDissolves(channel string) (string, error) {
-	return getValue(channel, "dissolves")
-}
-func GetDissolve(channel string) int {
-	return mustInt(channel, "dissolve", 100)
+//Changed reports whether the config of the source may have changed since
+//it was last loaded
+func Changed() bool {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	return source != nil && source.Changed()
 }
 
-func GetNamelogoDissolve(channel string) int {
-	i, err := instance.Int(channel, "namelogodissolve")
-	if err != nil {
-		return 0
-	} else {
-		return i
+//Reload loads the config of the source again. The new policy is swapped in
+//only if every key is valid, otherwise the previous one is kept.
+func Reload() error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	if source == nil {
+		return fileErr
 	}
-}
-func GetSequenceofoperation(channel string) ([]string, error) {
-	v, err := getValue(channel, "sequenceofoperation")
+	p, errs, err := load()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if v == "" {
-		v = "s,resize,q,m,rotate"
+	if len(errs) > 0 {
+		return errs
 	}
-	return strings.Split(v, ","), nil
-}
-
-func Reload() error {
-	return instance.Reload()
-}
-
-func getValue(channel string, key string) (string, error) {
-	v, _ := instance.GetValue(channel, key)
-	if v == "" && channel != "" {
-		v, _ = instance.GetValue("", key)
-		return v, nil
-	}
-	if v == "nil" {
-		v = ""
-	}
-	return v, nil
-}
-
-func mustInt(channel string, key string, defaultvalue int) int {
-	//if err := loadConfiguration(); err != nil {
-	//	return defaultvalue
-	//}
-	return instance.MustInt(channel, key, defaultvalue)
+	current.Store(p)
+	fileMutex.Lock()
+	fileErr = nil
+	fileMutex.Unlock()
+	return nil
 }
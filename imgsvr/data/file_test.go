@@ -0,0 +1,54 @@
+package data
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved, savedPolicy := source, Current()
+	defer func() {
+		source = saved
+		current.Store(savedPolicy)
+	}()
+	path := filepath.Join(dir, "conf.ini")
+	source = &fileSource{path: path}
+	write := func(s string) {
+		if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("quality=90\n")
+	if !Changed() {
+		t.Error("a new file should be changed")
+	}
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	version := Current().Version
+	if version == "" || Changed() {
+		t.Error("the reloaded file should have a version and be unchanged")
+	}
+	write("quality=abc\n")
+	if err := Reload(); err == nil {
+		t.Error("an invalid file should fail")
+	}
+	if Current().Version != version || Changed() {
+		t.Error("the previous policy should be kept, the invalid file shouldn't be read again")
+	}
+	write("quality=80\n")
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if Current().Version == version || Current().Channel("").Quality != 80 {
+		t.Error("the new policy should be swapped in")
+	}
+}
@@ -0,0 +1,523 @@
+package data
+
+import (
+	"errors"
+	"github.com/Unknwon/goconfig"
+	"github.com/ctripcorp/nephele/telemetry"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var DefaultSequence = "s,resize,q,m,rotate"
+
+//Policy is the parsed configuration of all channels. A Policy is never
+//modified after it is built, reload builds a new one and swaps it in.
+type Policy struct {
+	//hash of the config file, empty if the policy isn't built from it
+	Version    string
+	FdfsDomain string
+	FdfsPort   int
+	//secret of debug tokens, debug is disabled if it is empty
+	DebugSecret string
+	//telemetry backend: cat, log, otlp or noop
+	Telemetry string
+	//url of the OTLP/HTTP traces endpoint of the otlp backend
+	OtlpEndpoint string
+	//seconds a stopping worker waits for the requests in flight
+	DrainTimeout int
+	//seconds between reloads of the config file even if it didn't change,
+	//0 only reloads on changes and SIGHUP
+	ConfigReloadInterval int
+	//tiles and pixels (width x height) of a sprite sheet at most
+	SpriteMaxTiles  int
+	SpriteMaxPixels int
+	//placeholders kept in memory by a worker, 0 disables the cache
+	PlaceholderCache int
+	Worker           WorkerPolicy
+	Log              LogPolicy
+	channels         map[string]*ChannelPolicy
+	defaults         *ChannelPolicy
+}
+
+//LogPolicy configures the service log and the access log, flags of nephele
+//override it
+type LogPolicy struct {
+	//debug, info, warn or error
+	Level string
+	//service log
+	File string
+	//access log of json lines, disabled if empty
+	AccessLog string
+	//fraction of requests written to the access log, 0~1
+	AccessLogSample float64
+	//a log file is rotated when it exceeds MaxSize MB, 0 means never
+	MaxSize int
+	//rotated files kept of a log
+	MaxBackups int
+}
+
+//WorkerPolicy configures the recycling and the GraphicsMagick limits of
+//workers, 0 disables a threshold or keeps the GraphicsMagick default
+type WorkerPolicy struct {
+	//a worker is recycled once its RSS exceeds RecycleRSS MB or it has
+	//served RecycleRequests requests
+	RecycleRSS      int
+	RecycleRequests int
+	//GraphicsMagick limits of each worker, memory, map and disk in MB
+	MagickMemory  int
+	MagickMap     int
+	MagickDisk    int
+	MagickThreads int
+}
+
+//ChannelPolicy is the configuration of one channel, keys missing in the
+//channel section inherit the value of the default section
+type ChannelPolicy struct {
+	Channel                string
+	Nfs1                   string
+	Nfs2                   string
+	LogoDir                string
+	ResizeTypes            map[string]bool
+	Sizes                  *SizePolicy
+	ResizeRules            *ResizeRules
+	Rotates                map[int]bool
+	Qualities              map[int]bool
+	Quality                int
+	Dissolves              map[int]bool
+	Dissolve               int
+	IsEnableNameLogo       bool
+	NameLogoDissolve       int
+	DefaultLogo            string
+	DefaultLogoLocation    int
+	LogoNames              map[string]bool
+	ImagelessWidthForLogo  int64
+	ImagelessHeightForLogo int64
+	Sequence               []Step
+	//image served in place of a missing source, processed like it and
+	//cached for FallbackTTL seconds, none if empty
+	FallbackImage string
+	FallbackTTL   int
+}
+
+//Step is one operation of sequenceofoperation, fixed params of the
+//operation are given in parentheses: resize,sharpen(sigma=0.5),q
+type Step struct {
+	Name  string
+	Args  map[string]string
+	Token string
+}
+
+//Channel returns the policy of channel, or the default one if the channel
+//isn't configured
+func (p *Policy) Channel(channel string) *ChannelPolicy {
+	if cp, ok := p.channels[channel]; ok {
+		return cp
+	}
+	return p.defaults
+}
+
+//HasChannel reports whether channel has its own section
+func (p *Policy) HasChannel(channel string) bool {
+	_, ok := p.channels[channel]
+	return ok
+}
+
+//Channels returns the configured channel names in order
+func (p *Policy) Channels() []string {
+	names := make([]string, 0, len(p.channels))
+	for name := range p.channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//DirPath returns the nfs root of storagetype(nfs1, nfs2)
+func (cp *ChannelPolicy) DirPath(storagetype string) string {
+	switch storagetype {
+	case "nfs1":
+		return cp.Nfs1
+	case "nfs2":
+		return cp.Nfs2
+	}
+	return ""
+}
+
+type KeyError struct {
+	Channel string
+	Key     string
+	Value   string
+	Err     error
+}
+
+func (e *KeyError) Error() string {
+	channel := e.Channel
+	if channel == "" {
+		channel = "default"
+	}
+	return "channel: " + channel + ", key: " + e.Key + ", value: " + e.Value + ", reason: " + e.Err.Error()
+}
+
+type PolicyErrors []*KeyError
+
+func (errs PolicyErrors) Error() string {
+	arr := make([]string, 0, len(errs))
+	for _, e := range errs {
+		arr = append(arr, e.Error())
+	}
+	return strings.Join(arr, "; ")
+}
+
+//BuildPolicy parses every section of conf. Invalid values are reported per
+//key and left as zero value in the policy.
+func BuildPolicy(conf *goconfig.ConfigFile) (*Policy, PolicyErrors) {
+	b := &policyBuilder{conf: conf}
+	p := &Policy{channels: make(map[string]*ChannelPolicy)}
+	p.FdfsDomain, _ = b.value("", "fdfsdomain")
+	p.FdfsPort = b.intValue("", "fdfsport", 22122)
+	p.DebugSecret, _ = b.value("", "debugsecret")
+	p.Telemetry = "cat"
+	if v, _ := b.value("", "telemetry"); v != "" {
+		if telemetry.IsBackend(v) {
+			p.Telemetry = v
+		} else {
+			b.fail("", "telemetry", v, errors.New("unknown backend"))
+		}
+	}
+	p.OtlpEndpoint, _ = b.value("", "otlpendpoint")
+	if p.Telemetry == "otlp" && p.OtlpEndpoint == "" {
+		b.fail("", "otlpendpoint", "", errors.New("required by telemetry otlp"))
+	}
+	p.DrainTimeout = b.uintValue("", "draintimeout", 30)
+	p.ConfigReloadInterval = b.uintValue("", "configreloadinterval", 0)
+	p.PlaceholderCache = b.uintValue("", "placeholdercache", 10000)
+	p.SpriteMaxTiles = b.uintValue("", "spritemaxtiles", 50)
+	p.SpriteMaxPixels = b.uintValue("", "spritemaxpixels", 4096*4096)
+	p.Worker = WorkerPolicy{
+		RecycleRSS:      b.uintValue("", "recyclerss", 0),
+		RecycleRequests: b.uintValue("", "recyclerequests", 0),
+		MagickMemory:    b.uintValue("", "magickmemory", 0),
+		MagickMap:       b.uintValue("", "magickmap", 0),
+		MagickDisk:      b.uintValue("", "magickdisk", 0),
+		MagickThreads:   b.uintValue("", "magickthreads", 0),
+	}
+	p.Log = b.buildLog()
+	p.defaults = b.build("")
+	for _, section := range conf.GetSectionList() {
+		if section == goconfig.DEFAULT_SECTION {
+			continue
+		}
+		p.channels[section] = b.build(section)
+	}
+	return p, b.errs
+}
+
+type policyBuilder struct {
+	conf    *goconfig.ConfigFile
+	channel string
+	errs    PolicyErrors
+}
+
+//value returns the value of key and whether it is set in the channel
+//section itself. An empty value inherits the default section, "nil"
+//means empty.
+func (b *policyBuilder) value(channel, key string) (string, bool) {
+	v, _ := b.conf.GetValue(channel, key)
+	if v == "" && channel != "" {
+		v, _ = b.conf.GetValue("", key)
+		return v, false
+	}
+	if v == "nil" {
+		v = ""
+	}
+	return v, true
+}
+
+func (b *policyBuilder) fail(channel, key, value string, err error) {
+	b.errs = append(b.errs, &KeyError{channel, key, value, err})
+}
+
+//parse runs fn on the value of key, errors of inherited values are
+//reported once on the default section
+func (b *policyBuilder) parse(key string, fn func(v string) error) {
+	v, own := b.value(b.channel, key)
+	if err := fn(v); err != nil && (own || b.channel == "") {
+		b.fail(b.channel, key, v, err)
+	}
+}
+
+func (b *policyBuilder) intValue(channel, key string, defaultvalue int) int {
+	v, _ := b.value(channel, key)
+	if v == "" {
+		return defaultvalue
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		b.fail(channel, key, v, err)
+		return defaultvalue
+	}
+	return i
+}
+
+//uintValue is intValue of a key which can't be negative
+func (b *policyBuilder) uintValue(channel, key string, defaultvalue int) int {
+	i := b.intValue(channel, key, defaultvalue)
+	if i < 0 {
+		v, _ := b.value(channel, key)
+		b.fail(channel, key, v, errors.New("should be >= 0"))
+		return defaultvalue
+	}
+	return i
+}
+
+func (b *policyBuilder) buildLog() LogPolicy {
+	l := LogPolicy{Level: "info", File: "nephele.log", AccessLog: "access.log", AccessLogSample: 1}
+	if v, _ := b.value("", "loglevel"); v != "" {
+		if err := CheckLogLevel(v); err != nil {
+			b.fail("", "loglevel", v, err)
+		} else {
+			l.Level = v
+		}
+	}
+	if v, _ := b.value("", "logfile"); v != "" {
+		l.File = v
+	}
+	//"nil" disables the access log
+	if v, _ := b.conf.GetValue("", "accesslog"); v != "" {
+		l.AccessLog, _ = b.value("", "accesslog")
+	}
+	if v, _ := b.value("", "accesslogsample"); v != "" {
+		if f, err := ParseSample(v); err != nil {
+			b.fail("", "accesslogsample", v, err)
+		} else {
+			l.AccessLogSample = f
+		}
+	}
+	l.MaxSize = b.intValue("", "logmaxsize", 100)
+	l.MaxBackups = b.intValue("", "logmaxbackups", 5)
+	return l
+}
+
+//CheckLogLevel accepts debug, info, warn and error
+func CheckLogLevel(level string) error {
+	switch level {
+	case "debug", "info", "warn", "error":
+		return nil
+	}
+	return errors.New("level should be debug, info, warn or error")
+}
+
+//ParseSample parses a fraction in 0~1
+func ParseSample(v string) (float64, error) {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, err
+	}
+	if f < 0 || f > 1 {
+		return 0, errors.New("sample should be in 0~1")
+	}
+	return f, nil
+}
+
+func (b *policyBuilder) build(channel string) *ChannelPolicy {
+	b.channel = channel
+	cp := &ChannelPolicy{Channel: channel}
+	cp.Nfs1, _ = b.value(channel, "nfs1")
+	cp.Nfs2, _ = b.value(channel, "nfs2")
+	cp.LogoDir, _ = b.value(channel, "logodir")
+	cp.FallbackImage, _ = b.value(channel, "fallbackimage")
+	b.parse("resizetypes", func(v string) (err error) {
+		cp.ResizeTypes = make(map[string]bool)
+		for _, t := range splitList(v) {
+			if !IsResizeType(t) {
+				err = errors.New("unknown resize type " + t)
+				continue
+			}
+			cp.ResizeTypes[t] = true
+		}
+		return
+	})
+	b.parse("sizes", func(v string) (err error) {
+		cp.Sizes, err = ParseSizePolicy(v)
+		return
+	})
+	b.parse("resizerules", func(v string) (err error) {
+		cp.ResizeRules, err = ParseResizeRules(v)
+		return
+	})
+	b.parse("rotates", func(v string) (err error) {
+		cp.Rotates, err = parseIntSet(v)
+		return
+	})
+	b.parse("qualities", func(v string) (err error) {
+		cp.Qualities, err = parseIntSet(v)
+		return
+	})
+	b.parse("quality", func(v string) (err error) {
+		cp.Quality, err = strconv.Atoi(v)
+		return
+	})
+	b.parse("dissolves", func(v string) (err error) {
+		cp.Dissolves, err = parseIntSet(v)
+		return
+	})
+	cp.Dissolve = 100
+	b.parse("dissolve", func(v string) (err error) {
+		if v != "" {
+			cp.Dissolve, err = strconv.Atoi(v)
+		}
+		return
+	})
+	b.parse("isenablenamelogo", func(v string) error {
+		cp.IsEnableNameLogo = v == "1"
+		return nil
+	})
+	b.parse("namelogodissolve", func(v string) (err error) {
+		if v != "" {
+			cp.NameLogoDissolve, err = strconv.Atoi(v)
+		}
+		return
+	})
+	b.parse("defaultlogo", func(v string) error {
+		if v == "" {
+			return nil
+		}
+		arr := strings.Split(v, ",")
+		if len(arr) != 2 {
+			return errors.New("expect name,location")
+		}
+		l, err := strconv.Atoi(arr[1])
+		if err != nil {
+			return err
+		}
+		//0 is the bottom right as in WaterMarkProcessor
+		if l < 0 || l > 9 {
+			return errors.New("location should be in 1~9")
+		}
+		cp.DefaultLogo, cp.DefaultLogoLocation = arr[0], l
+		return nil
+	})
+	b.parse("logonames", func(v string) error {
+		cp.LogoNames = make(map[string]bool)
+		for _, name := range splitList(v) {
+			cp.LogoNames[name] = true
+		}
+		return nil
+	})
+	b.parse("imagelesswidthforlogo", func(v string) (err error) {
+		if v != "" {
+			cp.ImagelessWidthForLogo, err = strconv.ParseInt(v, 10, 64)
+		}
+		return
+	})
+	b.parse("imagelessheightforlogo", func(v string) (err error) {
+		if v != "" {
+			cp.ImagelessHeightForLogo, err = strconv.ParseInt(v, 10, 64)
+		}
+		return
+	})
+	b.parse("sequenceofoperation", func(v string) (err error) {
+		if v == "" {
+			v = DefaultSequence
+		}
+		cp.Sequence, err = ParseSequence(v)
+		return
+	})
+	cp.FallbackTTL = 60
+	b.parse("fallbackttl", func(v string) (err error) {
+		if v != "" {
+			cp.FallbackTTL, err = strconv.Atoi(v)
+		}
+		return
+	})
+	return cp
+}
+
+func ParseSequence(s string) ([]Step, error) {
+	steps := []Step{}
+	for _, token := range splitOutside(s, ',') {
+		if token = strings.TrimSpace(token); token == "" {
+			continue
+		}
+		step, err := parseStep(token)
+		if err != nil {
+			return nil, errors.New("operation " + token + ": " + err.Error())
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func parseStep(token string) (Step, error) {
+	step := Step{Name: token, Args: make(map[string]string), Token: token}
+	i := strings.Index(token, "(")
+	if i < 0 {
+		if strings.Contains(token, ")") {
+			return step, errors.New("unexpected )")
+		}
+		return step, nil
+	}
+	if !strings.HasSuffix(token, ")") {
+		return step, errors.New("expect NAME(KEY=VALUE,...)")
+	}
+	step.Name = strings.TrimSpace(token[:i])
+	if step.Name == "" {
+		return step, errors.New("operation name is empty")
+	}
+	for _, arg := range splitList(token[i+1 : len(token)-1]) {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return step, errors.New("expect KEY=VALUE, got " + arg)
+		}
+		key := strings.TrimSpace(kv[0])
+		if _, ok := step.Args[key]; ok {
+			return step, errors.New("duplicate param " + key)
+		}
+		step.Args[key] = strings.TrimSpace(kv[1])
+	}
+	return step, nil
+}
+
+//splitOutside splits s by sep which isn't enclosed in parentheses
+func splitOutside(s string, sep byte) []string {
+	arr := []string{}
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				arr = append(arr, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(arr, s[start:])
+}
+
+//splitList splits ini lists like ",a,b," into [a b]
+func splitList(v string) []string {
+	arr := []string{}
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			arr = append(arr, s)
+		}
+	}
+	return arr
+}
+
+func parseIntSet(v string) (map[int]bool, error) {
+	m := make(map[int]bool)
+	for _, s := range splitList(v) {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return m, err
+		}
+		m[i] = true
+	}
+	return m, nil
+}
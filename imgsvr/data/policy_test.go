@@ -0,0 +1,145 @@
+package data
+
+import (
+	"github.com/Unknwon/goconfig"
+	"testing"
+)
+
+var testConf = `fdfsdomain=tracker
+quality=86
+qualities=,50,86,
+sizes=,100x100,
+resizetypes=,r,c,
+logodir=/logo/
+
+[tg]
+sizes=,200x200,
+logodir=nil
+defaultlogo=tg,7
+
+[bad]
+quality=high
+resizetypes=,r,x,
+sizes=,100,
+`
+
+func TestBuildPolicy(t *testing.T) {
+	conf, err := goconfig.LoadFromData([]byte(testConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, errs := BuildPolicy(conf)
+	if p.FdfsDomain != "tracker" || p.FdfsPort != 22122 {
+		t.Error("fdfs config is invalid")
+	}
+	tg := p.Channel("tg")
+	if _, ok := tg.Sizes.Match(200, 200); !ok {
+		t.Error("tg should accept 200x200")
+	}
+	if _, ok := tg.Sizes.Match(100, 100); ok {
+		t.Error("tg sizes shouldn't inherit default")
+	}
+	if tg.Quality != 86 || !tg.Qualities[50] || !tg.ResizeTypes["c"] {
+		t.Error("tg should inherit default quality and resize types")
+	}
+	if tg.LogoDir != "" {
+		t.Error("nil should mean empty")
+	}
+	if tg.DefaultLogo != "tg" || tg.DefaultLogoLocation != 7 {
+		t.Error("defaultlogo is invalid")
+	}
+	if tg.FallbackImage != "" || tg.FallbackTTL != 60 {
+		t.Error("fallbackttl should be 60 by default")
+	}
+	if p.Channel("unknown") != p.Channel("") {
+		t.Error("unknown channel should use default policy")
+	}
+	keys := map[string]bool{}
+	for _, e := range errs {
+		if e.Channel != "bad" {
+			t.Error("unexpected error " + e.Error())
+		}
+		keys[e.Key] = true
+	}
+	if len(errs) != 3 || !keys["quality"] || !keys["resizetypes"] || !keys["sizes"] {
+		t.Error("expect errors of quality, resizetypes and sizes, got " + errs.Error())
+	}
+	if p.Telemetry != "cat" {
+		t.Error("telemetry should be cat by default")
+	}
+	if p.DrainTimeout != 30 {
+		t.Error("draintimeout should be 30 by default")
+	}
+	if p.PlaceholderCache != 10000 {
+		t.Error("placeholdercache should be 10000 by default")
+	}
+	if p.SpriteMaxTiles != 50 || p.SpriteMaxPixels != 4096*4096 {
+		t.Error("spritemaxtiles should be 50 and spritemaxpixels 4096x4096 by default")
+	}
+}
+
+func TestDrainTimeoutPolicy(t *testing.T) {
+	conf, _ := goconfig.LoadFromData([]byte("quality=90\ndraintimeout=-1\n"))
+	p, errs := BuildPolicy(conf)
+	if p.DrainTimeout != 30 || len(errs) != 1 || errs[0].Key != "draintimeout" {
+		t.Error("negative draintimeout should be reported")
+	}
+}
+
+func TestDefaultLogoPolicy(t *testing.T) {
+	for _, v := range []string{"tg,x", "tg,10", "tg,-1", "tg"} {
+		conf, _ := goconfig.LoadFromData([]byte("quality=90\ndefaultlogo=" + v + "\n"))
+		p, errs := BuildPolicy(conf)
+		if p.Channel("").DefaultLogo != "" || len(errs) != 1 || errs[0].Key != "defaultlogo" {
+			t.Error("invalid defaultlogo " + v + " should be reported")
+		}
+	}
+}
+
+func TestWorkerPolicy(t *testing.T) {
+	conf, _ := goconfig.LoadFromData([]byte("quality=90\nrecyclerss=2048\nmagickthreads=2\nmagickmemory=-1\n"))
+	p, errs := BuildPolicy(conf)
+	if p.Worker.RecycleRSS != 2048 || p.Worker.MagickThreads != 2 || p.Worker.RecycleRequests != 0 {
+		t.Errorf("unexpected worker policy %+v", p.Worker)
+	}
+	if p.Worker.MagickMemory != 0 || len(errs) != 1 || errs[0].Key != "magickmemory" {
+		t.Error("negative magickmemory should be reported")
+	}
+}
+
+func TestTelemetryPolicy(t *testing.T) {
+	conf, _ := goconfig.LoadFromData([]byte("quality=90\ntelemetry=otlp\n"))
+	p, errs := BuildPolicy(conf)
+	if p.Telemetry != "otlp" || len(errs) != 1 || errs[0].Key != "otlpendpoint" {
+		t.Error("otlp without endpoint should be reported")
+	}
+	conf, _ = goconfig.LoadFromData([]byte("quality=90\ntelemetry=statsd\n"))
+	p, errs = BuildPolicy(conf)
+	if p.Telemetry != "cat" || len(errs) != 1 || errs[0].Key != "telemetry" {
+		t.Error("unknown backend should be reported")
+	}
+}
+
+func TestConfPolicy(t *testing.T) {
+	if len(LoadErrors()) > 0 {
+		t.Error(LoadErrors().Error())
+	}
+}
+
+func TestParseSequence(t *testing.T) {
+	steps, err := ParseSequence("resize, sharpen(radius=1,sigma=0.5) ,q,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 3 || steps[1].Name != "sharpen" || steps[1].Args["sigma"] != "0.5" || steps[1].Args["radius"] != "1" {
+		t.Errorf("unexpected steps %v", steps)
+	}
+	if len(steps[0].Args) != 0 || steps[2].Name != "q" {
+		t.Errorf("unexpected steps %v", steps)
+	}
+	for _, s := range []string{"sharpen(sigma)", "sharpen(sigma=1", "(sigma=1)", "q)", "sharpen(sigma=1,sigma=2)"} {
+		if _, err := ParseSequence(s); err == nil {
+			t.Error("expect error for " + s)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package data
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+//size whitelist of a channel, parsed from the `sizes` key
+//
+//tokens are separated by ',':
+//  300x200        exact size
+//  600x0          width 600, height auto (0 always means auto)
+//  100-2000/10x0  width 100~2000 in steps of 10, height auto
+//  *x300          any width, height 300
+//  16:9           any size with aspect ratio 16:9
+//  16:9@100-2000  aspect ratio 16:9, width 100~2000
+type SizePolicy struct {
+	exact  map[sizeKey]string
+	ranges []sizeRangeRule
+	ratios []sizeRatioRule
+}
+
+type sizeKey struct {
+	width  int64
+	height int64
+}
+
+//Max < 0 means unbounded, Step 0 means every value
+type SizeRange struct {
+	Min  int64
+	Max  int64
+	Step int64
+}
+
+func (r SizeRange) Contains(v int64) bool {
+	if v < r.Min || (r.Max >= 0 && v > r.Max) {
+		return false
+	}
+	if r.Step > 1 && (v-r.Min)%r.Step != 0 {
+		return false
+	}
+	return true
+}
+
+type sizeRangeRule struct {
+	width  SizeRange
+	height SizeRange
+	token  string
+}
+
+type sizeRatioRule struct {
+	x     int64
+	y     int64
+	width SizeRange
+	token string
+}
+
+func ParseSizePolicy(s string) (*SizePolicy, error) {
+	p := &SizePolicy{exact: make(map[sizeKey]string)}
+	for _, token := range strings.Split(s, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		if err := p.add(strings.ToLower(token)); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *SizePolicy) add(token string) error {
+	if strings.Contains(token, ":") {
+		rule, err := parseRatioRule(token)
+		if err != nil {
+			return err
+		}
+		p.ratios = append(p.ratios, rule)
+		return nil
+	}
+	wspec, hspec, err := splitSize(token)
+	if err != nil {
+		return err
+	}
+	width, err := parseSizeRange(wspec)
+	if err != nil {
+		return errors.New("size " + token + ": " + err.Error())
+	}
+	height, err := parseSizeRange(hspec)
+	if err != nil {
+		return errors.New("size " + token + ": " + err.Error())
+	}
+	if width.isExact() && height.isExact() {
+		p.exact[sizeKey{width.Min, height.Min}] = token
+		return nil
+	}
+	p.ranges = append(p.ranges, sizeRangeRule{width, height, token})
+	return nil
+}
+
+//Match returns the token which accepts the size
+func (p *SizePolicy) Match(width, height int64) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	if token, ok := p.exact[sizeKey{width, height}]; ok {
+		return token, true
+	}
+	for _, r := range p.ranges {
+		if r.width.Contains(width) && r.height.Contains(height) {
+			return r.token, true
+		}
+	}
+	if width == 0 || height == 0 {
+		return "", false
+	}
+	for _, r := range p.ratios {
+		if width*r.y == height*r.x && r.width.Contains(width) {
+			return r.token, true
+		}
+	}
+	return "", false
+}
+
+func (p *SizePolicy) Len() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.exact) + len(p.ranges) + len(p.ratios)
+}
+
+func parseRatioRule(token string) (sizeRatioRule, error) {
+	rule := sizeRatioRule{width: SizeRange{1, -1, 0}, token: token}
+	ratio := token
+	if i := strings.Index(token, "@"); i >= 0 {
+		ratio = token[:i]
+		r, err := parseSizeRange(token[i+1:])
+		if err != nil {
+			return rule, errors.New("size " + token + ": " + err.Error())
+		}
+		rule.width = r
+	}
+	arr := strings.Split(ratio, ":")
+	if len(arr) != 2 {
+		return rule, errors.New("size " + token + ": invalid aspect ratio")
+	}
+	x, err1 := strconv.ParseInt(arr[0], 10, 64)
+	y, err2 := strconv.ParseInt(arr[1], 10, 64)
+	if err1 != nil || err2 != nil || x < 1 || y < 1 {
+		return rule, errors.New("size " + token + ": invalid aspect ratio")
+	}
+	rule.x, rule.y = x, y
+	return rule, nil
+}
+
+func splitSize(token string) (string, string, error) {
+	arr := strings.Split(token, "x")
+	if len(arr) != 2 || arr[0] == "" || arr[1] == "" {
+		return "", "", errors.New("size " + token + ": expect WIDTHxHEIGHT")
+	}
+	return arr[0], arr[1], nil
+}
+
+//spec: N, *, MIN-MAX, MIN-, -MAX, each range may end with /STEP
+func parseSizeRange(spec string) (SizeRange, error) {
+	r := SizeRange{0, -1, 0}
+	if spec == "*" {
+		return r, nil
+	}
+	if i := strings.Index(spec, "/"); i >= 0 {
+		step, err := strconv.ParseInt(spec[i+1:], 10, 64)
+		if err != nil || step < 1 {
+			return r, errors.New("invalid step " + spec[i+1:])
+		}
+		r.Step = step
+		spec = spec[:i]
+	}
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		v, err := strconv.ParseInt(spec, 10, 64)
+		if err != nil || v < 0 {
+			return r, errors.New("invalid number " + spec)
+		}
+		if r.Step > 0 {
+			return r, errors.New("step needs a range " + spec)
+		}
+		r.Min, r.Max = v, v
+		return r, nil
+	}
+	var err error
+	if min := spec[:i]; min != "" {
+		if r.Min, err = strconv.ParseInt(min, 10, 64); err != nil || r.Min < 0 {
+			return r, errors.New("invalid number " + min)
+		}
+	}
+	if max := spec[i+1:]; max != "" {
+		if r.Max, err = strconv.ParseInt(max, 10, 64); err != nil || r.Max < r.Min {
+			return r, errors.New("invalid range " + spec)
+		}
+	}
+	return r, nil
+}
+
+func (r SizeRange) isExact() bool {
+	return r.Max >= 0 && r.Min == r.Max
+}
+
+//resize rules of a channel, parsed from the `resizerules` key
+//
+//rules are separated by ',' and the first matched rule wins:
+//  TYPES:WxH=ACTION
+//TYPES is '*' or resize types joined by '|', W and H are size range specs.
+//ACTION is 'reject', or a resize type optionally followed by ':WxH' to
+//replace the size ('*' keeps the requested value).
+//  tg: w:*x1-=reject,*:10000x-9999=w,w:*x0=z:*x100000,*:*x*=r
+type ResizeRules struct {
+	rules []resizeRule
+}
+
+type resizeRule struct {
+	types  map[string]bool
+	width  SizeRange
+	height SizeRange
+	reject bool
+	to     string
+	toW    int64 //-1 keeps the requested width
+	toH    int64
+	token  string
+}
+
+func ParseResizeRules(s string) (*ResizeRules, error) {
+	rs := &ResizeRules{}
+	for _, token := range strings.Split(s, ",") {
+		token = strings.ToLower(strings.TrimSpace(token))
+		if token == "" {
+			continue
+		}
+		rule, err := parseResizeRule(token)
+		if err != nil {
+			return nil, errors.New("resizerule " + token + ": " + err.Error())
+		}
+		rs.rules = append(rs.rules, rule)
+	}
+	return rs, nil
+}
+
+func parseResizeRule(token string) (resizeRule, error) {
+	rule := resizeRule{token: token, toW: -1, toH: -1}
+	eq := strings.Index(token, "=")
+	colon := strings.Index(token, ":")
+	if eq < 0 || colon < 0 || colon > eq {
+		return rule, errors.New("expect TYPES:WxH=ACTION")
+	}
+	if types := token[:colon]; types != "*" {
+		rule.types = make(map[string]bool)
+		for _, t := range strings.Split(types, "|") {
+			if !IsResizeType(t) {
+				return rule, errors.New("unknown resize type " + t)
+			}
+			rule.types[t] = true
+		}
+	}
+	wspec, hspec, err := splitSize(token[colon+1 : eq])
+	if err != nil {
+		return rule, err
+	}
+	if rule.width, err = parseSizeRange(wspec); err != nil {
+		return rule, err
+	}
+	if rule.height, err = parseSizeRange(hspec); err != nil {
+		return rule, err
+	}
+	action := token[eq+1:]
+	if action == "reject" {
+		rule.reject = true
+		return rule, nil
+	}
+	to := action
+	if i := strings.Index(action, ":"); i >= 0 {
+		to = action[:i]
+		w, h, err := splitSize(action[i+1:])
+		if err != nil {
+			return rule, err
+		}
+		if rule.toW, err = parseTargetSize(w); err != nil {
+			return rule, err
+		}
+		if rule.toH, err = parseTargetSize(h); err != nil {
+			return rule, err
+		}
+	}
+	if !IsResizeType(to) {
+		return rule, errors.New("unknown resize type " + to)
+	}
+	rule.to = to
+	return rule, nil
+}
+
+func parseTargetSize(s string) (int64, error) {
+	if s == "*" {
+		return -1, nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return 0, errors.New("invalid number " + s)
+	}
+	return v, nil
+}
+
+//Apply returns the resize type and size after the first matched rule, and
+//the token of that rule. Without a match the request is returned as is.
+func (rs *ResizeRules) Apply(resizetype string, width, height int64) (string, int64, int64, string, error) {
+	if rs == nil {
+		return resizetype, width, height, "", nil
+	}
+	for _, r := range rs.rules {
+		if r.types != nil && !r.types[resizetype] {
+			continue
+		}
+		if !r.width.Contains(width) || !r.height.Contains(height) {
+			continue
+		}
+		if r.reject {
+			return "", 0, 0, r.token, errors.New("rejected by resize rule " + r.token)
+		}
+		if r.toW >= 0 {
+			width = r.toW
+		}
+		if r.toH >= 0 {
+			height = r.toH
+		}
+		return r.to, width, height, r.token, nil
+	}
+	return resizetype, width, height, "", nil
+}
+
+func (rs *ResizeRules) Len() int {
+	if rs == nil {
+		return 0
+	}
+	return len(rs.rules)
+}
+
+func IsResizeType(t string) bool {
+	switch t {
+	case "r", "c", "w", "z":
+		return true
+	}
+	return false
+}
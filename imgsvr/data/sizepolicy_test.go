@@ -0,0 +1,103 @@
+package data
+
+import (
+	"testing"
+)
+
+func TestSizePolicyMatch(t *testing.T) {
+	p, err := ParseSizePolicy(",100x100,600x0,100-2000/10x0,*x300,16:9@160-1600,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		width  int64
+		height int64
+		token  string
+		ok     bool
+	}{
+		{100, 100, "100x100", true},
+		{600, 0, "600x0", true},
+		{110, 0, "100-2000/10x0", true},
+		{115, 0, "", false},
+		{2010, 0, "", false},
+		{7, 300, "*x300", true},
+		{320, 180, "16:9@160-1600", true},
+		{1920, 1080, "", false},
+		{200, 200, "", false},
+	}
+	for _, c := range cases {
+		token, ok := p.Match(c.width, c.height)
+		if ok != c.ok || token != c.token {
+			t.Errorf("match %dx%d: got %q %v, want %q %v", c.width, c.height, token, ok, c.token, c.ok)
+		}
+	}
+}
+
+func TestParseSizePolicyError(t *testing.T) {
+	for _, s := range []string{"100", "ax100", "100-50x0", "100/10x0", "16:0", "16:9@a"} {
+		if _, err := ParseSizePolicy(s); err == nil {
+			t.Error("expect error for " + s)
+		}
+	}
+}
+
+func TestResizeRulesTG(t *testing.T) {
+	rs, err := ParseResizeRules("w:*x1-=reject,r|c|z:*x0=reject,*:10000x-9999=w,*:-9999x10000=w,w:*x0=z:*x100000,*:*x*=r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		cmd    string
+		width  int64
+		height int64
+		to     string
+		toW    int64
+		toH    int64
+		reject bool
+	}{
+		{"w", 600, 400, "", 0, 0, true},
+		{"c", 600, 0, "", 0, 0, true},
+		{"w", 10000, 0, "w", 10000, 0, false},
+		{"c", 600, 10000, "w", 600, 10000, false},
+		{"w", 600, 0, "z", 600, 100000, false},
+		{"c", 600, 400, "r", 600, 400, false},
+	}
+	for _, c := range cases {
+		to, w, h, _, err := rs.Apply(c.cmd, c.width, c.height)
+		if c.reject {
+			if err == nil {
+				t.Errorf("%s %dx%d: expect reject", c.cmd, c.width, c.height)
+			}
+			continue
+		}
+		if err != nil || to != c.to || w != c.toW || h != c.toH {
+			t.Errorf("%s %dx%d: got %s %dx%d %v", c.cmd, c.width, c.height, to, w, h, err)
+		}
+	}
+}
+
+func TestResizeRulesNoMatch(t *testing.T) {
+	rs, err := ParseResizeRules("r|c:*x*=r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, w, h, token, err := rs.Apply("z", 100, 0)
+	if err != nil || to != "z" || w != 100 || h != 0 || token != "" {
+		t.Error("unmatched request should be returned as is")
+	}
+}
+
+func TestConfSizeRules(t *testing.T) {
+	if err := Load("../conf/uat_conf.ini"); err != nil {
+		t.Fatal(err)
+	}
+	for _, channel := range []string{"", "tg", "hotel", "vacations"} {
+		cp := Current().Channel(channel)
+		if cp.Sizes.Len() == 0 {
+			t.Error(channel + ": sizes is empty")
+		}
+	}
+	if Current().Channel("tg").ResizeRules.Len() == 0 {
+		t.Error("tg: resizerules is empty")
+	}
+}
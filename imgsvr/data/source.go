@@ -0,0 +1,257 @@
+package data
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/Unknwon/goconfig"
+	"github.com/ctripcorp/nephele/util"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//channel code of the default section in the config table of imgws
+const defaultChannelCode = "00"
+
+//Source is where the config comes from, the -config flag or NEPHELE_CONFIG
+//selects it:
+//
+//	a path          an ini file, conf/ENV_conf.ini of the installation by default
+//	mysql:DSN       the config table of imgws (imgws/sql/imagedb.sql)
+//	http://HOST     the /config/get/ api of imgws
+//
+//Channels of the config table are identified by their code, they are mapped
+//to sections by the names of the channel table, code 00 is the default.
+//Reload and Changed of the package serialize the calls of the source.
+type Source interface {
+	//Load reads the whole config, version identifies the content
+	Load() (conf *goconfig.ConfigFile, version string, err error)
+	//Changed reports whether the config may differ from the last Load
+	Changed() bool
+	String() string
+}
+
+//NewSource creates the source of spec, see Source
+func NewSource(spec string) (Source, error) {
+	switch {
+	case strings.HasPrefix(spec, "mysql:"):
+		db, err := sql.Open("mysql", strings.TrimPrefix(spec, "mysql:"))
+		if err != nil {
+			return nil, err
+		}
+		return &sqlSource{db: db}, nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return &httpSource{url: strings.TrimSuffix(spec, "/"), client: http.Client{Timeout: 10 * time.Second}}, nil
+	case spec == "":
+		return nil, errors.New("empty config source")
+	}
+	return &fileSource{path: spec}, nil
+}
+
+type fileSource struct {
+	path string
+	//state of the file when it was last read
+	read fileState
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func (this *fileSource) Load() (*goconfig.ConfigFile, string, error) {
+	if info, err := os.Stat(this.path); err == nil {
+		this.read = fileState{info.ModTime(), info.Size()}
+	}
+	bts, err := ioutil.ReadFile(this.path)
+	if err != nil {
+		return nil, "", err
+	}
+	conf, err := goconfig.LoadFromData(bts)
+	if err != nil {
+		return nil, "", err
+	}
+	return conf, version(bts), nil
+}
+
+//Changed compares the modification time and the size of the file
+func (this *fileSource) Changed() bool {
+	info, err := os.Stat(this.path)
+	if err != nil {
+		return false
+	}
+	return this.read != fileState{info.ModTime(), info.Size()}
+}
+
+func (this *fileSource) String() string {
+	return this.path
+}
+
+//sqlSource reads the config and channel tables of imgws
+type sqlSource struct {
+	db *sql.DB
+	//count and latest recordTime of the config table at the last Load
+	stamp string
+}
+
+func (this *sqlSource) Load() (*goconfig.ConfigFile, string, error) {
+	stamp, err := this.queryStamp()
+	if err != nil {
+		return nil, "", err
+	}
+	names := make(map[string]string)
+	rows, err := this.db.Query("SELECT name,code FROM channel")
+	if err != nil {
+		return nil, "", err
+	}
+	for rows.Next() {
+		var name, code string
+		if err = rows.Scan(&name, &code); err != nil {
+			rows.Close()
+			return nil, "", err
+		}
+		names[code] = name
+	}
+	rows.Close()
+	rows, err = this.db.Query("SELECT channelCode,`key`,value FROM config")
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	configs := make(map[string]map[string]string)
+	for rows.Next() {
+		var code, key, value string
+		if err = rows.Scan(&code, &key, &value); err != nil {
+			return nil, "", err
+		}
+		if configs[code] == nil {
+			configs[code] = make(map[string]string)
+		}
+		configs[code][key] = value
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+	conf, v := channelConfig(configs, names)
+	this.stamp = stamp
+	return conf, v, nil
+}
+
+//Changed compares the row count and the latest recordTime, which an update
+//of imgws renews
+func (this *sqlSource) Changed() bool {
+	stamp, err := this.queryStamp()
+	return err == nil && stamp != this.stamp
+}
+
+func (this *sqlSource) queryStamp() (string, error) {
+	var count int64
+	var latest sql.NullString
+	if err := this.db.QueryRow("SELECT COUNT(*),MAX(recordTime) FROM config").Scan(&count, &latest); err != nil {
+		return "", err
+	}
+	return util.JoinString(latest.String, "/", strconv.FormatInt(count, 10)), nil
+}
+
+func (this *sqlSource) String() string {
+	return "mysql config table"
+}
+
+//httpSource pulls the config from imgws, which caches its tables for a
+//minute, so it is only checked for changes as often
+type httpSource struct {
+	url     string
+	client  http.Client
+	version string
+	checked time.Time
+}
+
+//how often httpSource.Changed pulls the config
+var httpCheckInterval = time.Minute
+
+func (this *httpSource) Load() (*goconfig.ConfigFile, string, error) {
+	configs := make(map[string]map[string]string)
+	if err := this.get("/config/get/", &configs); err != nil {
+		return nil, "", err
+	}
+	//name: code
+	channels := make(map[string]string)
+	if err := this.get("/channel/get/", &channels); err != nil {
+		return nil, "", err
+	}
+	names := make(map[string]string)
+	for name, code := range channels {
+		names[code] = name
+	}
+	conf, v := channelConfig(configs, names)
+	this.version, this.checked = v, time.Now()
+	return conf, v, nil
+}
+
+//Changed pulls the config at most once per httpCheckInterval
+func (this *httpSource) Changed() bool {
+	if time.Since(this.checked) < httpCheckInterval {
+		return false
+	}
+	previous := this.version
+	this.checked = time.Now()
+	_, v, err := this.Load()
+	return err == nil && v != previous
+}
+
+func (this *httpSource) get(path string, v interface{}) error {
+	rsp, err := this.client.Get(this.url + path)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	bts, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return errors.New(util.JoinString(path, ": http status ", rsp.Status))
+	}
+	//imgws responds the error as text
+	if err = json.Unmarshal(bts, v); err != nil {
+		return errors.New(util.JoinString(path, ": ", string(bts)))
+	}
+	return nil
+}
+
+func (this *httpSource) String() string {
+	return this.url
+}
+
+//channelConfig builds the config of channel code: key: value, names maps
+//codes to section names. The version is a hash of the sorted entries.
+func channelConfig(configs map[string]map[string]string, names map[string]string) (*goconfig.ConfigFile, string) {
+	conf, _ := goconfig.LoadFromData([]byte{})
+	entries := []string{}
+	for code, keys := range configs {
+		section := names[code]
+		if code == defaultChannelCode {
+			section = ""
+		} else if section == "" {
+			section = code
+		}
+		for key, value := range keys {
+			conf.SetValue(section, key, value)
+			entries = append(entries, util.JoinString(section, "\x00", key, "\x00", value))
+		}
+	}
+	sort.Strings(entries)
+	return conf, version([]byte(strings.Join(entries, "\n")))
+}
+
+func version(bts []byte) string {
+	sum := sha1.Sum(bts)
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChannelConfig(t *testing.T) {
+	configs := map[string]map[string]string{
+		"00": {"quality": "90", "sizes": ",100x100,"},
+		"10": {"quality": "80"},
+		"99": {"quality": "70"},
+	}
+	conf, version := channelConfig(configs, map[string]string{"10": "tg"})
+	p, errs := BuildPolicy(conf)
+	if len(errs) > 0 {
+		t.Fatal(errs.Error())
+	}
+	if p.Channel("").Quality != 90 || p.Channel("tg").Quality != 80 || p.Channel("99").Quality != 70 {
+		t.Error("channels should be mapped to sections by name, or by code without a name")
+	}
+	if _, ok := p.Channel("tg").Sizes.Match(100, 100); !ok {
+		t.Error("tg should inherit sizes of the default channel")
+	}
+	if _, v := channelConfig(configs, map[string]string{"10": "tg"}); v != version {
+		t.Error("the version should only depend on the content")
+	}
+	configs["10"]["quality"] = "85"
+	if _, v := channelConfig(configs, map[string]string{"10": "tg"}); v == version {
+		t.Error("the version should change with the content")
+	}
+}
+
+func TestHttpSource(t *testing.T) {
+	quality := "80"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config/get/":
+			w.Write([]byte(`{"00":{"quality":"90"},"10":{"quality":"` + quality + `"}}`))
+		case "/channel/get/":
+			w.Write([]byte(`{"tg":"10"}`))
+		}
+	}))
+	defer server.Close()
+	s, err := NewSource(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, _, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := conf.GetValue("tg", "quality"); v != "80" {
+		t.Error("quality of tg should be 80, got " + v)
+	}
+	saved := httpCheckInterval
+	defer func() { httpCheckInterval = saved }()
+	httpCheckInterval = 0
+	if s.Changed() {
+		t.Error("the config didn't change")
+	}
+	quality = "70"
+	if !s.Changed() {
+		t.Error("the config changed")
+	}
+}
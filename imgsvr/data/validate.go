@@ -0,0 +1,190 @@
+package data
+
+import (
+	"errors"
+	"github.com/Unknwon/goconfig"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var KnownKeys = map[string]bool{
+	"fdfsdomain":             true,
+	"fdfsport":               true,
+	"debugsecret":            true,
+	"telemetry":              true,
+	"otlpendpoint":           true,
+	"draintimeout":           true,
+	"configreloadinterval":   true,
+	"placeholdercache":       true,
+	"spritemaxtiles":         true,
+	"spritemaxpixels":        true,
+	"recyclerss":             true,
+	"recyclerequests":        true,
+	"magickmemory":           true,
+	"magickmap":              true,
+	"magickdisk":             true,
+	"magickthreads":          true,
+	"loglevel":               true,
+	"logfile":                true,
+	"accesslog":              true,
+	"accesslogsample":        true,
+	"logmaxsize":             true,
+	"logmaxbackups":          true,
+	"nfs1":                   true,
+	"nfs2":                   true,
+	"logodir":                true,
+	"sizes":                  true,
+	"resizetypes":            true,
+	"resizerules":            true,
+	"rotates":                true,
+	"qualities":              true,
+	"quality":                true,
+	"isenablenamelogo":       true,
+	"namelogodissolve":       true,
+	"defaultlogo":            true,
+	"logonames":              true,
+	"imagelesswidthforlogo":  true,
+	"imagelessheightforlogo": true,
+	"dissolves":              true,
+	"dissolve":               true,
+	"sequenceofoperation":    true,
+	"fallbackimage":          true,
+	"fallbackttl":            true,
+}
+
+//CheckFile loads and validates the config file, it returns the sections
+//("" is the default section) and every invalid key
+func CheckFile(path string, checkStep func(Step) error) ([]string, PolicyErrors, error) {
+	conf, err := goconfig.LoadConfigFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, errs := BuildPolicy(conf)
+	errs = append(errs, Validate(conf, p, checkStep)...)
+	sections := []string{""}
+	for _, section := range conf.GetSectionList() {
+		if section != goconfig.DEFAULT_SECTION {
+			sections = append(sections, section)
+		}
+	}
+	return sections, errs, nil
+}
+
+//Validate checks what BuildPolicy can't: unknown keys, value ranges, the
+//operations of sequenceofoperation (by checkStep) and logo files. Each key
+//is checked in the section which sets it.
+func Validate(conf *goconfig.ConfigFile, p *Policy, checkStep func(Step) error) PolicyErrors {
+	v := &validator{checked: make(map[string]error)}
+	for _, section := range conf.GetSectionList() {
+		channel := section
+		if section == goconfig.DEFAULT_SECTION {
+			channel = ""
+		}
+		cp := p.Channel(channel)
+		checkLogo := false
+		for _, key := range conf.GetKeyList(section) {
+			value, _ := conf.GetValue(section, key)
+			fail := func(err error) {
+				v.errs = append(v.errs, &KeyError{channel, key, value, err})
+			}
+			if !KnownKeys[key] {
+				fail(errors.New("unknown key"))
+				continue
+			}
+			switch key {
+			case "quality":
+				if cp.Quality < 1 || cp.Quality > 100 {
+					fail(errors.New("quality should be in 1~100"))
+				}
+			case "qualities":
+				if err := checkRange(cp.Qualities, 1, 100); err != nil {
+					fail(err)
+				}
+			case "dissolve", "namelogodissolve":
+				d := cp.Dissolve
+				if key == "namelogodissolve" {
+					d = cp.NameLogoDissolve
+				}
+				if d < 0 || d > 100 {
+					fail(errors.New("dissolve should be in 0~100"))
+				}
+			case "dissolves":
+				if err := checkRange(cp.Dissolves, 0, 100); err != nil {
+					fail(err)
+				}
+			case "sequenceofoperation":
+				for _, step := range cp.Sequence {
+					if err := checkStep(step); err != nil {
+						fail(errors.New("operation " + step.Token + ": " + err.Error()))
+					}
+				}
+			case "logodir", "logonames", "defaultlogo":
+				checkLogo = true
+			case "fallbackimage":
+				if cp.FallbackImage != "" {
+					if err := v.checkFile(cp.FallbackImage); err != nil {
+						fail(err)
+					}
+				}
+			case "fallbackttl":
+				if cp.FallbackTTL < 0 {
+					fail(errors.New("fallbackttl should be >= 0"))
+				}
+			}
+		}
+		if checkLogo {
+			v.checkLogos(cp)
+		}
+	}
+	return v.errs
+}
+
+type validator struct {
+	errs    PolicyErrors
+	checked map[string]error
+}
+
+func (v *validator) checkLogos(cp *ChannelPolicy) {
+	if cp.DefaultLogo != "" && !cp.LogoNames[cp.DefaultLogo] {
+		v.errs = append(v.errs, &KeyError{cp.Channel, "defaultlogo", cp.DefaultLogo, errors.New("not in logonames")})
+	}
+	for name := range cp.LogoNames {
+		path := cp.LogoDir + name + ".png"
+		if err := v.checkFile(path); err != nil {
+			v.errs = append(v.errs, &KeyError{cp.Channel, "logonames", name, err})
+		}
+	}
+}
+
+func (v *validator) checkFile(path string) error {
+	if err, ok := v.checked[path]; ok {
+		return err
+	}
+	var err error
+	if strings.HasPrefix(path, "http://") {
+		client := http.Client{Timeout: 3 * time.Second}
+		var rsp *http.Response
+		if rsp, err = client.Head(path); err == nil {
+			rsp.Body.Close()
+			if rsp.StatusCode != http.StatusOK {
+				err = errors.New(path + ": http status " + strconv.Itoa(rsp.StatusCode))
+			}
+		}
+	} else {
+		_, err = os.Stat(path)
+	}
+	v.checked[path] = err
+	return err
+}
+
+func checkRange(set map[int]bool, min, max int) error {
+	for i := range set {
+		if i < min || i > max {
+			return errors.New(strconv.Itoa(i) + " should be in " + strconv.Itoa(min) + "~" + strconv.Itoa(max))
+		}
+	}
+	return nil
+}
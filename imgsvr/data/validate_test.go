@@ -0,0 +1,63 @@
+package data
+
+import (
+	"errors"
+	"github.com/Unknwon/goconfig"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "tg.png"), []byte{}, 0644)
+
+	conf, err := goconfig.LoadFromData([]byte(`quality=86
+sequenceofoperation=resize,q
+
+[tg]
+logodir=` + dir + `/
+logonames=,tg,
+defaultlogo=tg,7
+fallbackimage=` + dir + `/tg.png
+
+[bad]
+qualty=80
+quality=200
+dissolves=,50,101,
+sequenceofoperation=esize,q
+logodir=` + dir + `/
+logonames=,tg,ctrip,
+defaultlogo=hotel,7
+fallbackimage=` + dir + `/missing.png
+fallbackttl=-1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := BuildPolicy(conf)
+	checkStep := func(step Step) error {
+		if step.Name != "resize" && step.Name != "q" {
+			return errors.New("unknown operation")
+		}
+		return nil
+	}
+	errs := Validate(conf, p, checkStep)
+	keys := make(map[string]bool)
+	for _, e := range errs {
+		if e.Channel != "bad" {
+			t.Error("unexpected error " + e.Error())
+		}
+		keys[e.Key] = true
+	}
+	for _, key := range []string{"qualty", "quality", "dissolves", "sequenceofoperation", "logonames", "defaultlogo", "fallbackimage", "fallbackttl"} {
+		if !keys[key] {
+			t.Error("expect error on " + key)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package imgsvr
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ctripcorp/nephele/imgsvr/proc"
+	"github.com/ctripcorp/nephele/telemetry"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//chainExplain describes how an image url is resolved without fetching or
+//processing the image
+type chainExplain struct {
+	Uri         string             `json:"uri"`
+	Pattern     string             `json:"pattern"`
+	Channel     string             `json:"channel"`
+	StorageType string             `json:"storageType"`
+	Path        string             `json:"path"`
+	Tokens      []*tokenDecision   `json:"tokens"`
+	Processors  []*processorDetail `json:"processors"`
+	NotFetched  []string           `json:"notFetched"`
+	ErrorType   string             `json:"errorType,omitempty"`
+	Error       string             `json:"error,omitempty"`
+}
+
+//tokenDecision is the whitelist rule which accepted or rejected a url token
+type tokenDecision struct {
+	Param    string `json:"param"`
+	Value    string `json:"value"`
+	Rule     string `json:"rule"`
+	Accepted bool   `json:"accepted"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type processorDetail struct {
+	Operation string                 `json:"operation"`
+	Type      string                 `json:"type"`
+	Params    map[string]interface{} `json:"params"`
+}
+
+//the methods are no-op on nil, so builders record unconditionally
+
+func (e *chainExplain) accept(param, value, rule string) {
+	if e != nil {
+		e.Tokens = append(e.Tokens, &tokenDecision{param, value, rule, true, ""})
+	}
+}
+
+func (e *chainExplain) reject(param, value, rule, reason string) {
+	if e != nil {
+		e.Tokens = append(e.Tokens, &tokenDecision{param, value, rule, false, reason})
+	}
+}
+
+func (e *chainExplain) skip(path string) {
+	if e != nil {
+		e.NotFetched = append(e.NotFetched, path)
+	}
+}
+
+func (e *chainExplain) addProcessor(operation string, p proc.ImageProcessor) {
+	if e != nil {
+		e.Processors = append(e.Processors, &processorDetail{operation, fmt.Sprintf("%T", p), processorParams(p)})
+	}
+}
+
+//processorParams returns the exported scalar fields of a processor
+func processorParams(p proc.ImageProcessor) map[string]interface{} {
+	params := make(map[string]interface{})
+	v := reflect.Indirect(reflect.ValueOf(p))
+	if v.Kind() != reflect.Struct {
+		return params
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		switch v.Field(i).Kind() {
+		case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64, reflect.String:
+			params[f.Name] = v.Field(i).Interface()
+		}
+	}
+	return params
+}
+
+//ExplainHandler serves /explain/images/..., it resolves the image url like
+//Handler does and returns the result as json
+type ExplainHandler struct{}
+
+func (handler *ExplainHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	Telemetry := telemetry.Instance()
+	uri := strings.TrimPrefix(request.URL.String(), "/explain")
+	e := explainUri(uri, Telemetry)
+	bts, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Content-Length", strconv.Itoa(len(bts)))
+	writer.Write(bts)
+}
+
+//explainUri resolves an image url without fetching or processing the image
+func explainUri(uri string, Telemetry telemetry.Telemetry) *chainExplain {
+	e := &chainExplain{Uri: uri, Tokens: []*tokenDecision{}, Processors: []*processorDetail{}, NotFetched: []string{}}
+	params, isDigimarkUrl, ok := matchUri(uri)
+	if !ok {
+		e.ErrorType = "URI.ParseError"
+		return e
+	}
+	e.Pattern = "legalUrl"
+	if isDigimarkUrl {
+		e.Pattern = "digimarkUrl"
+	}
+	var path string
+	e.StorageType, e.Channel, path = ParseUri(params[":1"])
+	if _, _, err := FindStorage(params, Telemetry); err != nil {
+		e.ErrorType, e.Error = "Storage.ParseError", err.Error()
+		return e
+	}
+	e.Path = path + "." + params["ext"]
+
+	builder := &ProcChainBuilder{Telemetry: Telemetry, explain: e}
+	var buildErr *buildError
+	if isDigimarkUrl {
+		_, buildErr = builder.DigimarkProcChain(params)
+	} else {
+		_, buildErr = builder.Build(params)
+	}
+	if buildErr != nil {
+		e.ErrorType, e.Error = buildErr.Type(), buildErr.Error()
+	}
+	return e
+}
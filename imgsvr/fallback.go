@@ -0,0 +1,37 @@
+package imgsvr
+
+import (
+	"errors"
+	"github.com/ctripcorp/nephele/imgsvr/data"
+	"github.com/ctripcorp/nephele/telemetry"
+	"strings"
+)
+
+//response header of a fallback image, the storage error type of the source
+const FallbackHeader = "X-Nephele-Fallback"
+
+//storage errors of a missing source, the fallback image of the channel is
+//served in place of it
+var fallbackErrors = map[string]bool{"FileNotExistError": true, "HttpStatusError": true}
+
+//getFallback fetches the fallback image of the channel for a source which
+//failed with errType (e.g. NFS.FileNotExistError), ok is false if the source
+//isn't missing, the channel has no fallback image or it can't be fetched
+func getFallback(channel, errType, uri string, Telemetry telemetry.Telemetry) ([]byte, bool) {
+	path := data.Current().Channel(channel).FallbackImage
+	if path == "" || !fallbackErrors[errType[strings.LastIndex(errType, ".")+1:]] {
+		return nil, false
+	}
+	bts, err := GetImage("NFS", path, Telemetry)
+	if err == nil && len(bts) == 0 {
+		err = errors.New("recv image length is 0")
+	}
+	if err != nil {
+		logErrWithUri(uri, JoinString("fallback ", path, ": ", err.Error()), "errorLevel")
+		LogErrorEvent(Telemetry, "Fallback.Error", err.Error())
+		return nil, false
+	}
+	fallbackCounter.Inc(metricChannel(channel), errType)
+	LogEvent(Telemetry, "Fallback", errType, nil)
+	return bts, true
+}
@@ -0,0 +1,145 @@
+package imgsvr
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/ctripcorp/nephele/imgsvr/metrics"
+	"github.com/ctripcorp/nephele/telemetry"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var imageWorker sync.Once
+
+//startImageWorker runs the goroutine processing the tasks of Handler
+func startImageWorker() {
+	imageWorker.Do(func() { go CycleHandleImage() })
+}
+
+func writePng(t *testing.T, path string, width, height int) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//counterValue reads the value of a series, e.g. name{label="value"}, from
+//the metrics of workers
+func counterValue(series string) float64 {
+	var buf bytes.Buffer
+	metrics.Default.WriteText(&buf)
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, series+" ") {
+			v, _ := strconv.ParseFloat(strings.TrimPrefix(line, series+" "), 64)
+			return v
+		}
+	}
+	return 0
+}
+
+func TestFallback(t *testing.T) {
+	//no sample files directly in dir, so a missing source is
+	//FileNotExistError instead of a sample
+	dir, err := ioutil.TempDir("", "fallback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writePng(t, filepath.Join(dir, "fallback", "missing.png"), 300, 200)
+	writePng(t, filepath.Join(dir, "tg", "x", "a.png"), 300, 200)
+	ioutil.WriteFile(filepath.Join(dir, "tg", "x", "empty.png"), []byte{}, 0644)
+	SetLocalStorage(dir)
+	defer SetLocalStorage("")
+	loadTestPolicy(t, testConf+`
+[tg]
+fallbackimage=/fallback/missing.png
+fallbackttl=30
+
+[hotel]
+`)
+	startImageWorker()
+	series := `nephele_fallbacks_total{channel="tg",error="NFS.FileNotExistError"}`
+	fallbacks := counterValue(series)
+
+	cases := []struct {
+		uri      string
+		status   int
+		fallback string
+	}{
+		{"/images/tg/x/a_C_100_100.png", 200, ""},
+		{"/images/tg/x/missing_C_100_100.png", 200, "NFS.FileNotExistError"},
+		//not a missing source
+		{"/images/tg/x/empty_C_100_100.png", 404, ""},
+		//no fallback image
+		{"/images/hotel/x/missing_C_100_100.png", 404, ""},
+	}
+	for _, c := range cases {
+		recorder := httptest.NewRecorder()
+		(&Handler{}).ServeHTTP(recorder, httptest.NewRequest("GET", c.uri, nil))
+		if recorder.Code != c.status || recorder.Header().Get(FallbackHeader) != c.fallback {
+			t.Errorf("%s: expect %d %s, got %d %s %s", c.uri, c.status, c.fallback, recorder.Code,
+				recorder.Header().Get(FallbackHeader), recorder.Header().Get(ErrorHeader))
+			continue
+		}
+		cacheControl := recorder.Header().Get("Cache-Control")
+		if c.fallback != "" && (cacheControl != "max-age=30" || recorder.Header().Get("Content-Type") != "image/png") {
+			t.Errorf("%s: unexpected headers %v", c.uri, recorder.Header())
+		}
+		if c.fallback == "" && cacheControl != "" {
+			t.Errorf("%s: only fallbacks should have a short ttl", c.uri)
+		}
+	}
+	if v := counterValue(series); v != fallbacks+1 {
+		t.Errorf("expect 1 fallback counted, got %v", v-fallbacks)
+	}
+}
+
+func TestGetFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fallback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writePng(t, filepath.Join(dir, "fallback", "missing.png"), 10, 10)
+	SetLocalStorage(dir)
+	defer SetLocalStorage("")
+	loadTestPolicy(t, testConf+`
+[tg]
+fallbackimage=/fallback/missing.png
+
+[ctrip]
+fallbackimage=/fallback/gone.png
+`)
+	cases := []struct {
+		channel, errType string
+		ok               bool
+	}{
+		{"tg", "NFS.FileNotExistError", true},
+		{"tg", "NFS.HttpStatusError", true},
+		{"tg", "FastDFS.FileNotExistError", true},
+		{"tg", "NFS.UnExpectedError", false},
+		{"tg", "NFS.ImgLenZero", false},
+		{"hotel", "NFS.FileNotExistError", false},
+		//the fallback image can't be fetched
+		{"ctrip", "NFS.FileNotExistError", false},
+	}
+	for _, c := range cases {
+		bts, ok := getFallback(c.channel, c.errType, "/images/"+c.channel+"/a_C_100_100.png", telemetry.Noop)
+		if ok != c.ok || ok && len(bts) == 0 {
+			t.Errorf("%s %s: expect %v, got %v", c.channel, c.errType, c.ok, ok)
+		}
+	}
+}
@@ -4,15 +4,17 @@ import (
 	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
-	cat "github.com/ctripcorp/cat.go"
+	"github.com/ctripcorp/nephele/imgsvr/data"
 	"github.com/ctripcorp/nephele/imgsvr/img4g"
 	"github.com/ctripcorp/nephele/imgsvr/proc"
 	"github.com/ctripcorp/nephele/imgsvr/storage"
+	"github.com/ctripcorp/nephele/telemetry"
 	"github.com/ctripcorp/nephele/util"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,24 +25,40 @@ var (
 	proxyPassUrl = util.RegexpExt{regexp.MustCompile("^/images/fd/([a-zA-Z]+)/([a-zA-Z0-9]+)/(.*?).(?P<ext>jpg|jpeg|gif|png|Jpg)$")}
 )
 
+//response header of a failed request, the error type counted in metrics
+const ErrorHeader = "X-Nephele-Error"
+
 type Handler struct {
 	ChainBuilder *ProcChainBuilder
 }
 
 func (handler *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	Cat := cat.Instance()
-	handler.ChainBuilder = &ProcChainBuilder{Cat}
+	if placeholderUrl.MatchString(request.URL.String()) {
+		(&PlaceholderHandler{}).ServeHTTP(writer, request)
+		return
+	}
+	atomic.AddInt64(&servedRequests, 1)
+	atomic.AddInt64(&inFlightRequests, 1)
+	writer.Header().Set("X-Nephele-Config-Version", data.Current().Version)
+	Telemetry := telemetry.Instance()
+	handler.ChainBuilder = &ProcChainBuilder{Telemetry: Telemetry}
 	uri := request.URL.String()
-	tran := Cat.NewTransaction("URL", getShortUri(uri))
+	tran := Telemetry.NewTransaction("URL", getShortUri(uri))
 	var (
-		err       error
-		isSuccess bool = true
+		err         error
+		isSuccess   bool = true
+		channel     string
+		storagetype string
+		//the image goroutine has finished with the task
+		processed bool
 	)
+	start := time.Now()
+	timing := newRequestTiming(request)
 	defer func() {
 		p := recover()
 		if p != nil {
 			logErrWithUri(uri, fmt.Sprintf("%v", p), "errorLevel")
-			Cat.LogPanic(p)
+			Telemetry.LogPanic(p)
 			tran.SetStatus(p)
 		}
 
@@ -51,12 +69,25 @@ func (handler *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Requ
 			tran.SetStatus(err)
 			tran.Complete()
 		}
+		status, errType := "200", ""
 		if p != nil || err != nil {
+			atomic.AddInt64(&failedRequests, 1)
+			status, errType = "404", "Panic"
+			if err != nil {
+				errType = err.Error()
+			}
+			timing.writeHeader(writer.Header(), request, processed)
+			writer.Header().Set(ErrorHeader, errType)
 			http.Error(writer, http.StatusText(404), 404)
 		}
+		total := time.Since(start)
+		requestCounter.Inc(metricChannel(channel), status, errType)
+		timing.report(total, processed)
+		timing.writeAccess(request, channel, storagetype, status, errType, total, processed)
+		atomic.AddInt64(&inFlightRequests, -1)
 	}()
 
-	LogEvent(Cat, "URL", "URL.Client", map[string]string{
+	LogEvent(Telemetry, "URL", "URL.Client", map[string]string{
 		"clientip": util.GetClientIP(request),
 		"serverip": util.GetIP(),
 		"proto":    request.Proto,
@@ -64,32 +95,27 @@ func (handler *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Requ
 		//"agent":    request.UserAgent(),
 	})
 
-	LogEvent(Cat, "URL", "URL.Method", map[string]string{
+	LogEvent(Telemetry, "URL", "URL.Method", map[string]string{
 		"Http": request.Method + " " + uri,
 	})
 
-	LogEvent(Cat, "UpstreamProcess", JoinString(GetIP(), ":", WorkerPort), nil)
+	LogEvent(Telemetry, "UpstreamProcess", JoinString(GetIP(), ":", WorkerPort), nil)
 
-	isDigimarkUrl := false
-	params, ok1 := legalUrl.FindStringSubmatchMap(uri)
+	params, isDigimarkUrl, ok1 := matchUri(uri)
 	if !ok1 {
-		params, ok1 = digimarkUrl.FindStringSubmatchMap(uri)
-		if ok1 {
-			isDigimarkUrl = true
-		} else {
-			err = errors.New("URI.ParseError")
-			logErrWithUri(uri, err.Error(), "warnLevel")
-			LogErrorEvent(Cat, "URI.ParseError", "")
-			return
-		}
+		err = errors.New("URI.ParseError")
+		logErrWithUri(uri, err.Error(), "warnLevel")
+		LogErrorEvent(Telemetry, "URI.ParseError", "")
+		return
 	}
 
+	_, channel, _ = ParseUri(params[":1"])
 	//parse storage from url parameters
-	store, storagetype, err1 := FindStorage(params, Cat)
+	store, storagetype, err1 := FindStorage(params, Telemetry)
 	if err1 != nil {
 		err = errors.New("Storage.ParseError")
 		logErrWithUri(uri, err1.Error(), "warnLevel")
-		LogErrorEvent(Cat, "Storage.ParseError", err1.Error())
+		LogErrorEvent(Telemetry, "Storage.ParseError", err1.Error())
 		return
 	}
 	//parse handlers chain from url parameters
@@ -103,7 +129,7 @@ func (handler *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Requ
 	if buildErr != nil {
 		err = errors.New(buildErr.Type())
 		logErrWithUri(uri, buildErr.Error(), "warnLevel")
-		LogErrorEvent(Cat, buildErr.Type(), buildErr.Error())
+		LogErrorEvent(Telemetry, buildErr.Type(), buildErr.Error())
 		return
 	}
 	//download image from storage
@@ -116,22 +142,28 @@ func (handler *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Requ
 		}
 
 		var err1 error
-		getimagetran := Cat.NewTransaction("Storage", storagetype)
+		getimagetran := Telemetry.NewTransaction("Storage", storagetype)
 		defer func() {
 			if err1 != nil {
 				logErrWithUri(uri, err1.Error(), "errorLevel")
 				e, ok := err1.(storageError)
+				if ok {
+					storageErrorCounter.Inc(storagetype, e.Type())
+				} else {
+					storageErrorCounter.Inc(storagetype, "UnExpectedError")
+				}
 				if ok && e.Normal() {
 					err = errors.New(fmt.Sprintf("%v.%v", storagetype, e.Type()))
-					LogErrorEvent(Cat, fmt.Sprintf("%v.%v", storagetype, e.Type()), e.Error())
+					LogErrorEvent(Telemetry, fmt.Sprintf("%v.%v", storagetype, e.Type()), e.Error())
 				} else {
 					err = errors.New(storagetype + ".UnExpectedError")
-					LogErrorEvent(Cat, err.Error(), err1.Error())
+					LogErrorEvent(Telemetry, err.Error(), err1.Error())
 					isSuccess = false
 				}
 			} else if len(bts) == 0 {
+				storageErrorCounter.Inc(storagetype, "ImgLenZero")
 				err = errors.New(storagetype + ".ImgLenZero")
-				LogErrorEvent(Cat, err.Error(), "recv image length is 0")
+				LogErrorEvent(Telemetry, err.Error(), "recv image length is 0")
 				logErrWithUri(uri, "recv image length is 0", "warnLevel")
 			}
 			if isSuccess {
@@ -141,29 +173,39 @@ func (handler *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Requ
 			}
 			getimagetran.Complete()
 		}()
+		fetchstart := time.Now()
 		bts, err1 = store.GetImage()
+		timing.setStorage(time.Since(fetchstart), len(bts))
 	}()
+	//a missing source is served the fallback image of the channel
+	var fallback string
 	if err != nil {
-		return
+		var ok bool
+		if bts, ok = getFallback(channel, err.Error(), uri, Telemetry); !ok {
+			return
+		}
+		fallback, err = err.Error(), nil
 	}
 	size := len(bts)
 	sizestr := strconv.Itoa(size)
 	tran.AddData("size", sizestr)
-	Cat.LogEvent("Size", GetImageSizeDistribution(size))
+	Telemetry.LogEvent("Size", GetImageSizeDistribution(size))
 
 	log.WithFields(log.Fields{
 		"size": size,
 		"uri":  uri,
 	}).Debug("recv image length")
 	format, _ := params["ext"]
-	img := &img4g.Image{Blob: bts, Format: format, Cat: Cat}
+	img := &img4g.Image{Blob: bts, Format: format, Telemetry: Telemetry}
 
 	rspChan := make(chan bool, 1)
-	task := &nepheleTask{inImg: img, chain: chain, rspChan: rspChan, CatInstance: Cat, canceled: false}
+	task := &nepheleTask{inImg: img, chain: chain, rspChan: rspChan, Telemetry: Telemetry, canceled: false, timing: timing}
+	timing.setEnqueued()
 	taskChan <- task
 
 	select {
 	case ok := <-rspChan:
+		processed = true
 		if !ok {
 			err = errors.New("ProcessError")
 			isSuccess = false
@@ -175,13 +217,18 @@ func (handler *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Requ
 		err = errors.New("ProcessTimeout")
 		logErrWithUri(uri, err.Error(), "errorLevel")
 		isSuccess = false
-		LogErrorEvent(Cat, "ProcessTimeout", "")
+		LogErrorEvent(Telemetry, "ProcessTimeout", "")
 		return
 	}
 
 	writer.Header().Set("Content-Type", "image/"+format)
 	writer.Header().Set("Content-Length", strconv.Itoa(len(img.Blob)))
 	writer.Header().Set("Last-Modified", "2015/1/1 01:01:01")
+	if fallback != "" {
+		writer.Header().Set(FallbackHeader, fallback)
+		writer.Header().Set("Cache-Control", JoinString("max-age=", strconv.Itoa(data.Current().Channel(channel).FallbackTTL)))
+	}
+	timing.writeHeader(writer.Header(), request, processed)
 	log.WithFields(log.Fields{
 		"size": size,
 		"uri":  uri,
@@ -189,7 +236,7 @@ func (handler *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Requ
 	if _, err1 = writer.Write(img.Blob); err1 != nil {
 		logErrWithUri(uri, err1.Error(), "errorLevel")
 		err = errors.New("Response.WriteError")
-		LogErrorEvent(Cat, "Response.Writeerror", err1.Error())
+		LogErrorEvent(Telemetry, "Response.Writeerror", err1.Error())
 		isSuccess = false
 	}
 }
@@ -200,7 +247,7 @@ func CycleHandleImage() {
 			log.WithFields(log.Fields{
 				"type": "HandleImagePanic",
 			}).Error(fmt.Sprintf("%v", r))
-			LogErrorEvent(CatInstance, "HandleImagePanic", fmt.Sprintf("%v", r))
+			LogErrorEvent(TelemetryInstance, "HandleImagePanic", fmt.Sprintf("%v", r))
 			go CycleHandleImage()
 		}
 	}()
@@ -209,43 +256,57 @@ func CycleHandleImage() {
 		status := true
 		//get a task from task chan
 		task := <-taskChan
+		task.timing.setDequeued()
 		if task.GetCanceled() {
 			continue
 		}
 		chain := task.chain
 		image := task.inImg
-		if err := chainProcImg(task.CatInstance, chain, image); err != nil {
+		if err := chainProcImg(task.Telemetry, chain, image, task.timing); err != nil {
 			log.WithFields(log.Fields{
 				"type": "ProcessError",
 			}).Error(err.Error())
-			LogErrorEvent(task.CatInstance, "ProcessError", err.Error())
+			LogErrorEvent(task.Telemetry, "ProcessError", err.Error())
 			status = false
 		}
 		task.rspChan <- status
 	}
 }
 
-func chainProcImg(catinstance cat.Cat, chain *proc.ProcessorChain, img *img4g.Image) (err error) {
+func chainProcImg(instance telemetry.Telemetry, chain *proc.ProcessorChain, img *img4g.Image, timing *requestTiming) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.WithFields(log.Fields{
 				"type": "ProcessImage.Panic",
 			}).Error(fmt.Sprintf("%v", r))
-			LogErrorEvent(catinstance, "ProcessImage.Panic", fmt.Sprintf("%v", r))
+			LogErrorEvent(instance, "ProcessImage.Panic", fmt.Sprintf("%v", r))
 		}
 	}()
 	defer img.DestoryWand()
+	start := time.Now()
 	if err = img.CreateWand(); err != nil {
 		return
 	}
-	if err = chain.Process(img); err != nil {
+	timing.setDecode(time.Since(start), img)
+	if err = chain.ProcessWith(img, timing.observe()); err != nil {
 		return
 	}
+	start = time.Now()
 	err = img.WriteImageBlob()
+	timing.setEncode(time.Since(start), img)
 	return
 }
 
-func FindStorage(params map[string]string, Cat cat.Cat) (storage.Storage, string, error) {
+//matchUri matches an image url against legalUrl, then digimarkUrl
+func matchUri(uri string) (params map[string]string, isDigimarkUrl bool, ok bool) {
+	if params, ok = legalUrl.FindStringSubmatchMap(uri); ok {
+		return params, false, true
+	}
+	params, ok = digimarkUrl.FindStringSubmatchMap(uri)
+	return params, ok, ok
+}
+
+func FindStorage(params map[string]string, Telemetry telemetry.Telemetry) (storage.Storage, string, error) {
 	srcPath, ok := params[":1"]
 	if !ok {
 		return nil, "", errors.New("Url.UnExpected")
@@ -255,7 +316,7 @@ func FindStorage(params map[string]string, Cat cat.Cat) (storage.Storage, string
 		return nil, "", errors.New("Image.Ext.Invalid()")
 	}
 	sourceType, _, path := ParseUri(srcPath)
-	s, err := GetStorage(sourceType, path+"."+format, Cat)
+	s, err := GetStorage(sourceType, path+"."+format, Telemetry)
 	return s, sourceType, err
 }
 
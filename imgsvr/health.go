@@ -0,0 +1,167 @@
+package imgsvr
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ctripcorp/nephele/imgsvr/data"
+	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/imgsvr/storage"
+	"github.com/ctripcorp/nephele/telemetry"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	//the queue is saturated when it is filled to the ratio
+	queueSaturation = 0.9
+	//timeout of a dependency check
+	readyTimeout = 3 * time.Second
+)
+
+type readiness struct {
+	Ready bool `json:"ready"`
+	//check name: "ok" or the error
+	Checks map[string]string `json:"checks"`
+}
+
+//HealthzHandler is the liveness of a worker, it only tells that the worker
+//serves http
+type HealthzHandler struct{}
+
+func (this *HealthzHandler) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok"))
+}
+
+//ReadyzHandler is the readiness of a worker: config loaded, fdfs trackers
+//reachable, nfs roots accessible, GraphicsMagick working and the queue not
+//saturated. It responds 503 if any check fails.
+type ReadyzHandler struct{}
+
+func (this *ReadyzHandler) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	r := checkReadiness()
+	bts, _ := json.Marshal(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(bts)))
+	if !r.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(bts)
+}
+
+//checkReadiness runs the checks concurrently
+func checkReadiness() *readiness {
+	policy := data.Current()
+	checks := map[string]func() error{
+		"config": data.FileError,
+		"fdfs":   func() error { return checkFdfs(policy) },
+		"nfs":    func() error { return checkNfs(policy) },
+		"magick": checkMagick,
+		"queue":  checkQueue,
+	}
+	type result struct {
+		name string
+		err  error
+	}
+	c := make(chan result, len(checks))
+	for name, check := range checks {
+		go func(name string, check func() error) {
+			c <- result{name, check()}
+		}(name, check)
+	}
+	r := &readiness{Ready: true, Checks: make(map[string]string)}
+	for i := 0; i < len(checks); i++ {
+		res := <-c
+		if res.err != nil {
+			r.Ready = false
+			r.Checks[res.name] = res.err.Error()
+		} else {
+			r.Checks[res.name] = "ok"
+		}
+	}
+	return r
+}
+
+func checkFdfs(policy *data.Policy) error {
+	if localStorage != "" {
+		return nil
+	}
+	if policy.FdfsDomain == "" {
+		return errors.New("fdfsdomain is empty")
+	}
+	c := make(chan error, 1)
+	go func() {
+		c <- storage.FdfsActiveTest(policy.FdfsDomain, policy.FdfsPort)
+	}()
+	select {
+	case err := <-c:
+		return err
+	case <-time.After(readyTimeout):
+		return errors.New("active test timeout")
+	}
+}
+
+//checkNfs checks the nfs roots of every channel, a root served by http
+//is accessible if the server responds without 5xx. Neither fdfs nor nfs is
+//checked if the local storage stands in for them.
+func checkNfs(policy *data.Policy) error {
+	if localStorage != "" {
+		return nil
+	}
+	checked := make(map[string]bool)
+	channels := append([]string{""}, policy.Channels()...)
+	for _, channel := range channels {
+		cp := policy.Channel(channel)
+		for _, dir := range []string{cp.Nfs1, cp.Nfs2} {
+			if dir == "" || checked[dir] {
+				continue
+			}
+			checked[dir] = true
+			if err := checkDir(dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkDir(dir string) error {
+	if !strings.Contains(dir, "http://") {
+		_, err := os.Stat(dir)
+		return err
+	}
+	client := http.Client{Timeout: readyTimeout}
+	rsp, err := client.Head(dir)
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode >= 500 {
+		return errors.New(JoinString(dir, ": http status ", strconv.Itoa(rsp.StatusCode)))
+	}
+	return nil
+}
+
+//checkMagick decodes a 1x1 png by GraphicsMagick
+func checkMagick() error {
+	img, err := img4g.NewImageAsPNG(1, 1, telemetry.Noop)
+	if err != nil {
+		return err
+	}
+	defer img.DestoryWand()
+	if err = img.CreateWand(); err != nil {
+		return err
+	}
+	_, err = img.GetWidth()
+	return err
+}
+
+func checkQueue() error {
+	if float64(len(taskChan)) >= queueSaturation*float64(cap(taskChan)) {
+		return errors.New(JoinString("queue is saturated: ", strconv.Itoa(len(taskChan)), "/", strconv.Itoa(cap(taskChan))))
+	}
+	return nil
+}
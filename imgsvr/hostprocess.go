@@ -1,17 +1,24 @@
 package imgsvr
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
-	cat "github.com/ctripcorp/cat.go"
+	"github.com/ctripcorp/nephele/imgsvr/metrics"
+	"github.com/ctripcorp/nephele/telemetry"
 	"github.com/ctripcorp/nephele/util"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"os/signal"
 	"runtime"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -20,15 +27,68 @@ type HostProcessor struct {
 	ThreadCount int
 	NginxPath   string
 	NginxPort   string
+	//the built-in proxy listens on the port if it isn't empty, nginx isn't
+	//needed then
+	ProxyPort string
+	//params passed on to workers
+	Args []string
 }
 
+//worker port: consecutive checks the worker didn't respond
 var ports map[string]int
+
+//worker port: consecutive checks the worker responded not ready
+var unready = make(map[string]int)
+
+//workers in the nginx upstream
+var upstream map[string]int
+
+//the built-in proxy, nil unless HostProcessor.ProxyPort is set
+var proxy *workerProxy
+
+//completed cycles of monitorWorkerProcesses
+var monitorCycles int64
+
+const (
+	//a worker which doesn't respond is restarted
+	maxUnreachable = 3
+	//a worker which isn't ready is removed from the upstream, and restarted
+	//if it stays so. A dependency down for every worker shouldn't restart
+	//them in a loop, so the limit is much higher.
+	unreadyToRemove  = 2
+	unreadyToRestart = 30
+)
+
+//worker ports, unlike ports it isn't written after computePorts
+var workerPorts []string
 var hostPort string
-var portstats map[string]url.Values
+var portstats = make(map[string]url.Values)
+
+//portstats is written by heartbeat requests and read by sendCatHeartBeat
+var portstatsMutex sync.Mutex
 
-func (this *HostProcessor) Stop() {
-	cmd := exec.Command("pkill", "imgsvrd")
-	cmd.Output()
+//metrics of the daemon itself, workers have their own in metrics.Default
+var (
+	hostMetrics   = metrics.NewRegistry()
+	workerUpGauge = hostMetrics.NewGaugeVec("nephele_worker_up",
+		"Whether the last scrape of the worker succeeded.", "worker")
+)
+
+//Stop sends SIGTERM to the daemon on Port, which forwards it to its workers
+//and waits for them to drain. The daemon tells its pid by /status, so other
+//processes of the binary aren't signaled.
+func (this *HostProcessor) Stop() error {
+	client := http.Client{Timeout: 3 * time.Second}
+	rsp, err := client.Get(JoinString("http://127.0.0.1:", strconv.Itoa(this.Port), "/status?format=json"))
+	if err != nil {
+		return errors.New(JoinString("no daemon on port ", strconv.Itoa(this.Port), ": ", err.Error()))
+	}
+	defer rsp.Body.Close()
+	var s hostStatus
+	if err = json.NewDecoder(rsp.Body).Decode(&s); err != nil || s.Pid <= 0 {
+		return errors.New(JoinString("no daemon on port ", strconv.Itoa(this.Port), ": unexpected status"))
+	}
+	return syscall.Kill(s.Pid, syscall.SIGTERM)
 }
 
 func (this *HostProcessor) ReloadConf() {
@@ -50,7 +110,7 @@ func (this *HostProcessor) ModifyNginxconf() {
 				"nginxPort": this.NginxPort,
 				"type":      "Daemon.ModifyNginxError",
 			}).Error(err.Error())
-			LogErrorEvent(CatInstance, "Daemon.ModifyNginxError", err.Error())
+			LogErrorEvent(TelemetryInstance, "Daemon.ModifyNginxError", err.Error())
 			return
 		}
 		if err := RestartNginx(this.NginxPath); err != nil {
@@ -59,7 +119,7 @@ func (this *HostProcessor) ModifyNginxconf() {
 				"nginxPort": this.NginxPort,
 				"type":      "Daemon.RestartNginxError",
 			}).Error(err.Error())
-			LogErrorEvent(CatInstance, "Daemon.RestartNginxError", err.Error())
+			LogErrorEvent(TelemetryInstance, "Daemon.RestartNginxError", err.Error())
 			return
 		}
 	}
@@ -73,6 +133,7 @@ func (this *HostProcessor) Run() {
 		"threadCount": threadcount,
 		"nginxPath":   this.NginxPath,
 		"nginxPort":   this.NginxPort,
+		"proxyPort":   this.ProxyPort,
 	}).Debug("run host process")
 
 	defer func() {
@@ -82,18 +143,18 @@ func (this *HostProcessor) Run() {
 				"threadCount": threadcount,
 				"type":        "DaemonProcess.RunPanic",
 			}).Error(fmt.Sprintf("%v", p))
-			LogErrorEvent(CatInstance, "DaemonProcess.RunPanic", fmt.Sprintf("%v", p))
+			LogErrorEvent(TelemetryInstance, "DaemonProcess.RunPanic", fmt.Sprintf("%v", p))
 		}
 		os.Exit(2)
 	}()
 	func() {
-		Cat := cat.Instance()
-		tran := Cat.NewTransaction("System", Reboot)
+		Telemetry := telemetry.Instance()
+		tran := Telemetry.NewTransaction("System", Reboot)
 		defer func() {
 			tran.SetStatus("0")
 			tran.Complete()
 		}()
-		LogEvent(Cat, Reboot, JoinString(GetIP(), ":", hostPort), nil)
+		LogEvent(Telemetry, Reboot, JoinString(GetIP(), ":", hostPort), nil)
 	}()
 
 	this.computePorts()
@@ -104,7 +165,7 @@ func (this *HostProcessor) Run() {
 				"nginxPort": this.NginxPort,
 				"type":      "DaemonProcess.ModifyNginxError",
 			}).Error(err.Error())
-			LogErrorEvent(CatInstance, "DaemonProcess.ModifyNginxError", err.Error())
+			LogErrorEvent(TelemetryInstance, "DaemonProcess.ModifyNginxError", err.Error())
 			return
 		}
 		if err := RestartNginx(this.NginxPath); err != nil {
@@ -112,12 +173,16 @@ func (this *HostProcessor) Run() {
 				"nginxPath": this.NginxPath,
 				"type":      "DaemonProcess.RestartNginxError",
 			}).Error(err.Error())
-			LogErrorEvent(CatInstance, "DaemonProcess.RestartNginxError", err.Error())
+			LogErrorEvent(TelemetryInstance, "DaemonProcess.RestartNginxError", err.Error())
 			return
 		}
+		upstream = ports
+	}
+	if this.ProxyPort != "" {
+		proxy = newWorkerProxy(workerPorts)
+		go this.listenProxy()
 	}
 
-	portstats = make(map[string]url.Values)
 	for p, _ := range ports {
 		this.startWorkerProcess(p)
 		log.WithFields(log.Fields{
@@ -126,11 +191,17 @@ func (this *HostProcessor) Run() {
 	}
 
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	//the daemon reloads its own config and forwards SIGHUP to the workers
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go reloadOnSignal(hup, signalWorkers)
+	go watchConfig()
 	go this.monitorWorkerProcesses()
 	go this.sendCatHeartBeat()
 	go this.listenHeartbeat()
-	<-c //Interupt signal coming
+	sig := <-c
+	stopWorkers(sig)
 	os.Exit(0)
 }
 
@@ -139,24 +210,12 @@ func (this *HostProcessor) computePorts() {
 		this.ThreadCount = runtime.NumCPU()
 	}
 	ports = make(map[string]int, this.ThreadCount)
+	workerPorts = make([]string, 0, this.ThreadCount)
 	for i := 0; i < this.ThreadCount; i++ {
-		ports[strconv.Itoa(this.Port+i+1)] = 0
-	}
-}
-
-func (this *HostProcessor) startWorkerProcess(port string) {
-	cmd := exec.Command("go", "run", "imgsvrd.go", "-s", port, hostPort)
-	err := cmd.Start()
-	if err != nil {
-		log.WithFields(log.Fields{
-			"port":     port,
-			"hostPort": hostPort,
-			"type":     "DaemonProcess.StartWorkerError",
-		}).Error(err.Error())
-		LogErrorEvent(CatInstance, "DaemonProcess.StartWorkerError", err.Error())
-		return
+		port := strconv.Itoa(this.Port + i + 1)
+		ports[port] = 0
+		workerPorts = append(workerPorts, port)
 	}
-	return
 }
 
 func (this *HostProcessor) monitorWorkerProcesses() {
@@ -165,7 +224,7 @@ func (this *HostProcessor) monitorWorkerProcesses() {
 			log.WithFields(log.Fields{
 				"type": "DaemonProcess.MonitorPanic",
 			}).Error(fmt.Sprintf("%v", p))
-			LogErrorEvent(CatInstance, "DaemonProcess.MonitorPanic", fmt.Sprintf("%v", p))
+			LogErrorEvent(TelemetryInstance, "DaemonProcess.MonitorPanic", fmt.Sprintf("%v", p))
 			this.monitorWorkerProcesses()
 		}
 	}()
@@ -174,39 +233,141 @@ func (this *HostProcessor) monitorWorkerProcesses() {
 		time.Sleep(2 * time.Second)
 		log.Debug("monitor......")
 		for port, countor := range ports {
-			if port == "" {
+			if port == "" || isRestarting(port) {
 				continue
 			}
 
-			if countor > 2 {
+			if countor >= maxUnreachable || unready[port] >= unreadyToRestart {
 				log.WithFields(log.Fields{
 					"port": port,
 				}).Debug("restart port")
-				err := KillProcessByPort(port)
+				//the worker is restarted once reaped
+				err := killWorker(port)
 				if err != nil {
 					log.WithFields(log.Fields{
 						"port": port,
 						"type": "DaemonProcess.KillProcessError",
 					}).Error(err.Error())
-					LogErrorEvent(CatInstance, "DaemonProcess.KillProcessError", err.Error())
+					LogErrorEvent(TelemetryInstance, "DaemonProcess.KillProcessError", err.Error())
 				}
-				this.startWorkerProcess(port)
 				ports[port] = 0
-			} else {
-				_, err := GetHttp(JoinString("http://127.0.0.1:", port, "/heartbeat/"))
-				if err != nil {
-					ports[port] = ports[port] + 1
-					log.WithFields(log.Fields{
-						"port": port,
-						"type": "WorkerProcess.HeartbeatError",
-					}).Error(err.Error())
-					LogErrorEvent(CatInstance, "WorkerProcess.HeartbeatError", err.Error())
-				} else {
-					ports[port] = 0
+				//out of the upstream until it is ready
+				unready[port] = unreadyToRemove
+				continue
+			}
+			reachable, err := getReadiness(port)
+			switch {
+			case err == nil:
+				ports[port] = 0
+				unready[port] = 0
+				setWorkerHealth(port, "ready")
+				if reason := recycleReason(port); reason != "" {
+					go this.recycleWorker(port, reason)
 				}
+			case !reachable:
+				ports[port] = ports[port] + 1
+				log.WithFields(log.Fields{
+					"port": port,
+					"type": "WorkerProcess.HeartbeatError",
+				}).Error(err.Error())
+				LogErrorEvent(TelemetryInstance, "WorkerProcess.HeartbeatError", err.Error())
+				setWorkerHealth(port, "unreachable")
+			default:
+				ports[port] = 0
+				unready[port] = unready[port] + 1
+				log.WithFields(log.Fields{
+					"port": port,
+					"type": "WorkerProcess.NotReady",
+				}).Warn(err.Error())
+				LogErrorEvent(TelemetryInstance, "WorkerProcess.NotReady", err.Error())
+				setWorkerHealth(port, "unready")
 			}
 		}
+		this.updateUpstream()
+		atomic.AddInt64(&monitorCycles, 1)
+	}
+}
+
+//waitMonitorCycle waits until a whole monitor cycle has run
+func waitMonitorCycle() {
+	n := atomic.LoadInt64(&monitorCycles)
+	waitWorkers(func() bool { return atomic.LoadInt64(&monitorCycles) >= n+2 }, 30*time.Second)
+}
+
+//getReadiness requests /readyz of a worker, reachable is false if the
+//worker doesn't respond
+func getReadiness(port string) (bool, error) {
+	client := http.Client{Timeout: readyTimeout + time.Second}
+	rsp, err := client.Get(JoinString("http://127.0.0.1:", port, "/readyz"))
+	if err != nil {
+		return false, err
+	}
+	defer rsp.Body.Close()
+	bts, _ := ioutil.ReadAll(rsp.Body)
+	if rsp.StatusCode != http.StatusOK {
+		return true, errors.New(JoinString(strconv.Itoa(rsp.StatusCode), " ", string(bts)))
+	}
+	return true, nil
+}
+
+//updateUpstream keeps the workers which respond and are ready in the nginx
+//upstream and in rotation of the proxy. All workers are kept if none is, an
+//empty upstream would fail every request.
+func (this *HostProcessor) updateUpstream() {
+	if this.NginxPath == "" && proxy == nil {
+		return
+	}
+	ready := make(map[string]int)
+	for port, countor := range ports {
+		if port != "" && countor == 0 && unready[port] < unreadyToRemove && !isRestarting(port) {
+			ready[port] = 0
+		}
+	}
+	if len(ready) == 0 {
+		ready = ports
+	}
+	if proxy != nil {
+		//also puts back the workers the proxy took out after a failed
+		//connection
+		proxy.setPorts(ready)
+	}
+	if this.NginxPath == "" || samePorts(ready, upstream) {
+		return
+	}
+	if err := ModifyNginxconf(this.NginxPath, this.NginxPort, ready); err != nil {
+		log.WithFields(log.Fields{
+			"nginxPath": this.NginxPath,
+			"nginxPort": this.NginxPort,
+			"type":      "DaemonProcess.ModifyNginxError",
+		}).Error(err.Error())
+		LogErrorEvent(TelemetryInstance, "DaemonProcess.ModifyNginxError", err.Error())
+		return
+	}
+	if err := RestartNginx(this.NginxPath); err != nil {
+		log.WithFields(log.Fields{
+			"nginxPath": this.NginxPath,
+			"type":      "DaemonProcess.RestartNginxError",
+		}).Error(err.Error())
+		LogErrorEvent(TelemetryInstance, "DaemonProcess.RestartNginxError", err.Error())
+		return
+	}
+	log.WithFields(log.Fields{
+		"upstream": len(ready),
+		"workers":  len(ports),
+	}).Info("nginx upstream updated")
+	upstream = ready
+}
+
+func samePorts(a, b map[string]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for port := range a {
+		if _, ok := b[port]; !ok {
+			return false
+		}
 	}
+	return true
 }
 
 func (this *HostProcessor) sendCatHeartBeat() {
@@ -223,7 +384,7 @@ func (this *HostProcessor) sendCatHeartBeat() {
 		time.Sleep(sleep)
 	}
 
-	catinstance := cat.Instance()
+	instance := telemetry.Instance()
 	for {
 		log.Debug("send cat heartbeat")
 		stats1 := util.GetStatus()
@@ -232,10 +393,11 @@ func (this *HostProcessor) sendCatHeartBeat() {
 		for k, v := range stats1 {
 			data.Add(k, v)
 		}
+		portstatsMutex.Lock()
 		portstats[hostPort] = data
 
-		tran := catinstance.NewTransaction("System", "Status")
-		h := catinstance.NewHeartbeat("HeartBeat", ip)
+		tran := instance.NewTransaction("System", "Status")
+		h := instance.NewHeartbeat("HeartBeat", ip)
 		for _, heart := range portstats {
 			if heart == nil {
 				continue
@@ -264,6 +426,7 @@ func (this *HostProcessor) sendCatHeartBeat() {
 			h.Set("GC", JoinString("NumGC_", port), heart.Get("NumGC"))
 			portstats[port] = nil
 		}
+		portstatsMutex.Unlock()
 		h.SetStatus("0")
 		h.Complete()
 		tran.SetStatus("0")
@@ -280,21 +443,59 @@ func (this *HostProcessor) listenHeartbeat() {
 	}).Debug("listen and serve port")
 	//start server
 	http.HandleFunc("/heartbeat/", this.heartbeatHandler)
+	http.HandleFunc("/restart/", this.restartHandler)
+	http.HandleFunc("/status", this.statusHandler)
+	metrics.RegisterRuntime(hostMetrics)
+	http.HandleFunc("/metrics", this.metricsHandler)
 	if err := http.ListenAndServe(":"+hostPort, nil); err != nil {
 		log.WithFields(log.Fields{
 			"hostPort": hostPort,
 			"type":     "DaemonProcess.ListenAndServeError",
 		}).Error(err.Error())
-		LogErrorEvent(CatInstance, "DaemonProcess.ListenAndServeError", err.Error())
+		LogErrorEvent(TelemetryInstance, "DaemonProcess.ListenAndServeError", err.Error())
 		os.Exit(1)
 	}
 }
 
+//listenProxy serves the public port by the built-in proxy
+func (this *HostProcessor) listenProxy() {
+	log.WithFields(log.Fields{
+		"proxyPort": this.ProxyPort,
+	}).Info("listen and serve proxy")
+	if err := http.ListenAndServe(":"+this.ProxyPort, proxy); err != nil {
+		log.WithFields(log.Fields{
+			"proxyPort": this.ProxyPort,
+			"type":      "DaemonProcess.ListenProxyError",
+		}).Error(err.Error())
+		LogErrorEvent(TelemetryInstance, "DaemonProcess.ListenProxyError", err.Error())
+		os.Exit(1)
+	}
+}
+
+//restartHandler runs a rolling restart, it responds 1 once every worker is
+//replaced and 0 with the error otherwise
+func (this *HostProcessor) restartHandler(w http.ResponseWriter, request *http.Request) {
+	value := "1"
+	if err := this.RollingRestart(); err != nil {
+		value = JoinString("0 ", err.Error())
+		log.WithFields(log.Fields{
+			"type": "DaemonProcess.RollingRestartError",
+		}).Error(err.Error())
+		LogErrorEvent(TelemetryInstance, "DaemonProcess.RollingRestartError", err.Error())
+	}
+	a := []byte(value)
+	w.Header().Set("Content-Length", strconv.Itoa(len(a)))
+	w.Write(a)
+}
+
 func (this *HostProcessor) heartbeatHandler(w http.ResponseWriter, request *http.Request) {
 	port := request.FormValue("port")
 	var value string = "0"
 	if port != "" {
+		portstatsMutex.Lock()
 		portstats[port] = request.Form
+		portstatsMutex.Unlock()
+		recordHeartbeat(port, request.Form)
 		value = "1"
 	}
 	log.WithFields(log.Fields{
@@ -306,3 +507,39 @@ func (this *HostProcessor) heartbeatHandler(w http.ResponseWriter, request *http
 	w.Header().Set("Content-Length", strconv.Itoa(len(a)))
 	w.Write(a)
 }
+
+//metricsHandler serves the metrics of all workers with a port label, the
+//daemon's own metrics are labeled with the host port
+func (this *HostProcessor) metricsHandler(w http.ResponseWriter, request *http.Request) {
+	type result struct {
+		port string
+		bts  []byte
+		err  error
+	}
+	results := make(chan result, len(workerPorts))
+	for _, port := range workerPorts {
+		go func(port string) {
+			bts, err := GetHttp(JoinString("http://127.0.0.1:", port, "/metrics"))
+			results <- result{port, bts, err}
+		}(port)
+	}
+	sources := make(map[string][]byte)
+	for i := 0; i < len(workerPorts); i++ {
+		r := <-results
+		if r.err != nil {
+			workerUpGauge.Set(0, r.port)
+			log.WithFields(log.Fields{
+				"port": r.port,
+				"type": "DaemonProcess.ScrapeMetricsError",
+			}).Warn(r.err.Error())
+			continue
+		}
+		workerUpGauge.Set(1, r.port)
+		sources[r.port] = r.bts
+	}
+	var buf bytes.Buffer
+	hostMetrics.WriteText(&buf)
+	sources[hostPort] = buf.Bytes()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.Merge(w, "port", sources)
+}
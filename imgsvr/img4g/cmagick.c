@@ -9,6 +9,7 @@
 #include <magick/resize.h>
 #include <magick/resource.h> 
 #include <magick/api.h>    
+#include <string.h>
 
 #include "cmagick.h"
 
@@ -95,3 +96,21 @@ unsigned int createWand(MagickWand **wand,const unsigned char *blob,const size_t
     *wand = NewMagickWand();
     return MagickReadImageBlob(*wand, blob, length);
 }
+
+/* resource: memory, map, disk or threads */
+unsigned int setResourceLimit(const char *resource,const unsigned long limit)
+{
+    ResourceType type;
+
+    if (strcmp(resource, "memory") == 0)
+        type = MemoryResource;
+    else if (strcmp(resource, "map") == 0)
+        type = MapResource;
+    else if (strcmp(resource, "disk") == 0)
+        type = DiskResource;
+    else if (strcmp(resource, "threads") == 0)
+        type = ThreadsResource;
+    else
+        return False;
+    return SetMagickResourceLimit(type, limit);
+}
@@ -8,5 +8,6 @@
 extern unsigned int dissolveImage(MagickWand *, const unsigned int);
 extern unsigned int rotateImage(MagickWand *, double);
 extern unsigned int createWand(MagickWand **,const unsigned char *,const size_t);
+extern unsigned int setResourceLimit(const char *,const unsigned long);
 
 
@@ -14,7 +14,7 @@ import (
 	"image/png"
 	"image/color"
 
-	cat "github.com/ctripcorp/cat.go"
+	"github.com/ctripcorp/nephele/telemetry"
 )
 
 var (
@@ -26,19 +26,19 @@ func (this *Image) Write (p []byte) (int, error) {
 	return len(this.Blob), nil
 }
 
-func NewImage(width, height int, format string, CAT cat.Cat) (*Image, error){
+func NewImage(width, height int, format string, t telemetry.Telemetry) (*Image, error){
 	switch (format) {
 	case "PNG":
-		return NewImageAsPNG(width, height, CAT)
+		return NewImageAsPNG(width, height, t)
 	default:
 		return nil, ErrIllegalFormat
 	}
 }
 
-func NewImageAsPNG(width, height int, CAT cat.Cat) (*Image, error){
+func NewImageAsPNG(width, height int, t telemetry.Telemetry) (*Image, error){
 	i := &Image{
 		Format : "PNG",
-		Cat : CAT,
+		Telemetry : t,
 	}
 	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
 	for x := 0; x < width ; x++ {
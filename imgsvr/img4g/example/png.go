@@ -4,11 +4,11 @@ import (
 	"io/ioutil"
 
 	im4g "../"
-	cat "github.com/ctripcorp/cat.go"
+	"github.com/ctripcorp/nephele/telemetry"
 )
 
 func main() {
-	CAT := cat.Instance()
-	i, _ := im4g.NewImageAsPNG(100, 100, CAT)
+	t := telemetry.Instance()
+	i, _ := im4g.NewImageAsPNG(100, 100, t)
 	ioutil.WriteFile("1.png", i.Blob, 0644)
 }
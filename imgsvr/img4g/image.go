@@ -12,13 +12,13 @@ import "C"
 import "unsafe"
 import "errors"
 import "fmt"
-import cat "github.com/ctripcorp/cat.go"
+import "github.com/ctripcorp/nephele/telemetry"
 
 type Image struct {
 	Format     string        // png, jpeg, bmp, gif, ...
 	Blob       []byte        // raw image data
 	magickWand *C.MagickWand //wand object
-	cat.Cat                  //cat instance
+	Telemetry  telemetry.Telemetry
 }
 
 /*
@@ -26,7 +26,7 @@ CreateWand() creates a new wand for this Image by using Blob data
 */
 func (this *Image) CreateWand() error {
 	var err error = nil
-	tran := this.Cat.NewTransaction("GraphicsMagickCmd", "CreateWand")
+	tran := this.Telemetry.NewTransaction("GraphicsMagickCmd", "CreateWand")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -52,7 +52,7 @@ DestroyWand() deallocates memory associated with this Image wand.
 */
 func (this *Image) DestoryWand() {
 	var err error = nil
-	tran := this.Cat.NewTransaction("GraphicsMagickCmd", "DestoryWand")
+	tran := this.Telemetry.NewTransaction("GraphicsMagickCmd", "DestoryWand")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -71,7 +71,7 @@ height: height of the resized image
 */
 func (this *Image) Resize(width int64, height int64) error {
 	var err error = nil
-	tran := this.Cat.NewTransaction("GraphicsMagickCmd", "Resize")
+	tran := this.Telemetry.NewTransaction("GraphicsMagickCmd", "Resize")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -130,7 +130,7 @@ y: The row offset of the composited image.
 */
 func (this *Image) Composite(compositeImg *Image, x int64, y int64) error {
 	var err error = nil
-	tran := this.Cat.NewTransaction("GraphicsMagickCmd", "Composite")
+	tran := this.Telemetry.NewTransaction("GraphicsMagickCmd", "Composite")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -166,7 +166,7 @@ y: the region y offset
 */
 func (this *Image) Crop(width int64, height int64, x int64, y int64) error {
 	var err error = nil
-	tran := this.Cat.NewTransaction("GraphicsMagickCmd", "Crop")
+	tran := this.Telemetry.NewTransaction("GraphicsMagickCmd", "Crop")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -195,7 +195,7 @@ degrees: degrees of the rotated image
 */
 func (this *Image) Rotate(degrees float64) error {
 	var err error = nil
-	tran := this.Cat.NewTransaction("GraphicsMagickCmd", "Rotate")
+	tran := this.Telemetry.NewTransaction("GraphicsMagickCmd", "Rotate")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -225,7 +225,7 @@ rows: The number of rows in the scaled image
 */
 func (this *Image) Scale(columns int64, rows int64) error {
 	var err error = nil
-	tran := this.Cat.NewTransaction("GraphicsMagickCmd", "Scale")
+	tran := this.Telemetry.NewTransaction("GraphicsMagickCmd", "Scale")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -247,6 +247,37 @@ func (this *Image) Scale(columns int64, rows int64) error {
 	return nil
 }
 
+/*
+Sharpen() sharpens this image with a gaussian operator of the given radius
+and standard deviation
+
+radius: radius of the gaussian in pixels, 0 lets GraphicsMagick choose
+sigma: standard deviation of the gaussian in pixels
+*/
+func (this *Image) Sharpen(radius, sigma float64) error {
+	var err error = nil
+	tran := this.Telemetry.NewTransaction("GraphicsMagickCmd", "Sharpen")
+	defer func() {
+		tran.SetStatus(err)
+		tran.Complete()
+	}()
+	if this.magickWand == nil {
+		err = errors.New("error sharpen image:magickwand is nil")
+		return err
+	}
+
+	status := C.MagickSharpenImage(this.magickWand, C.double(radius), C.double(sigma))
+	if status == 0 {
+		var etype int
+		descr := C.MagickGetException(this.magickWand, (*C.ExceptionType)(unsafe.Pointer(&etype)))
+		defer C.MagickRelinquishMemory(unsafe.Pointer(descr))
+		err = errors.New(fmt.Sprintf("error sharpen image: %s (ExceptionType = %d)", C.GoString(descr), etype))
+		return err
+	}
+
+	return nil
+}
+
 /*
 Dissovle() sets transparency of this image to the specified value dissolve
 
@@ -254,7 +285,7 @@ dissolve: 0~100,0 means totally transparent while 100 means opa
 */
 func (this *Image) Dissolve(dissolve int) error {
 	var err error = nil
-	tran := this.Cat.NewTransaction("GraphicsMagickCmd", "Dissolve")
+	tran := this.Telemetry.NewTransaction("GraphicsMagickCmd", "Dissolve")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -283,7 +314,7 @@ quality: The image quality
 */
 func (this *Image) SetCompressionQuality(quality int) error {
 	var err error = nil
-	tran := this.Cat.NewTransaction("GraphicsMagickCmd", "SetCompressionQuality")
+	tran := this.Telemetry.NewTransaction("GraphicsMagickCmd", "SetCompressionQuality")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -315,7 +346,7 @@ format: The file or blob format
 */
 func (this *Image) SetFormat(format string) error {
 	var err error = nil
-	tran := this.Cat.NewTransaction("GraphicsMagickCmd", "SetFormat")
+	tran := this.Telemetry.NewTransaction("GraphicsMagickCmd", "SetFormat")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -344,7 +375,7 @@ Returns the image height
 */
 func (this *Image) GetHeight() (int64, error) {
 	var err error = nil
-	tran := this.Cat.NewTransaction("GraphicsMagickCmd", "GetHeight")
+	tran := this.Telemetry.NewTransaction("GraphicsMagickCmd", "GetHeight")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -364,7 +395,7 @@ Returns the image width
 */
 func (this *Image) GetWidth() (int64, error) {
 	var err error = nil
-	tran := this.Cat.NewTransaction("GraphicsMagickCmd", "GetWidth")
+	tran := this.Telemetry.NewTransaction("GraphicsMagickCmd", "GetWidth")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -383,7 +414,7 @@ GetFormat() return format of this image
 */
 func (this *Image) GetFormat() (string, error) {
 	var err error = nil
-	tran := this.Cat.NewTransaction("GraphicsMagickCmd", "GetFormat")
+	tran := this.Telemetry.NewTransaction("GraphicsMagickCmd", "GetFormat")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -409,7 +440,7 @@ Strip() removes all profiles and text attributes from this image.
 */
 func (this *Image) Strip() error {
 	var err error = nil
-	tran := this.Cat.NewTransaction("GraphicsMagickCmd", "Strip")
+	tran := this.Telemetry.NewTransaction("GraphicsMagickCmd", "Strip")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -451,7 +482,7 @@ WriteImageBlob() writes this image wand to Blob
 */
 func (this *Image) WriteImageBlob() error {
 	var err error = nil
-	tran := this.Cat.NewTransaction("GraphicsMagickCmd", "WriteImageBlob")
+	tran := this.Telemetry.NewTransaction("GraphicsMagickCmd", "WriteImageBlob")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -477,3 +508,17 @@ func (this *Image) WriteImageBlob() error {
 
 	return nil
 }
+
+/*
+SetResourceLimit() limits a GraphicsMagick resource of the process.
+
+resource: memory, map, disk (limit in bytes) or threads
+*/
+func SetResourceLimit(resource string, limit int64) error {
+	cresource := C.CString(resource)
+	defer C.free(unsafe.Pointer(cresource))
+	if C.setResourceLimit(cresource, C.ulong(limit)) == 0 {
+		return errors.New(fmt.Sprintf("error set resource limit: %s %d", resource, limit))
+	}
+	return nil
+}
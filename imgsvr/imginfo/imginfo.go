@@ -0,0 +1,250 @@
+//Package imginfo reads the dimensions and metadata of an image from its
+//header and a scan of its segments, without decoding the pixels.
+package imginfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+)
+
+type Info struct {
+	//format as image.DecodeConfig names it: jpeg, png or gif
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Bytes  int    `json:"bytes"`
+	//RGB, Gray or CMYK
+	ColorSpace string `json:"colorSpace"`
+	Alpha      bool   `json:"alpha"`
+	Animated   bool   `json:"animated"`
+	Frames     int    `json:"frames"`
+	//exif orientation 1 to 8, 0 if there is none
+	Orientation int `json:"orientation,omitempty"`
+	//exif DateTimeOriginal, else DateTime, like 2016-01-02T15:04:05
+	DateTime string `json:"dateTime,omitempty"`
+}
+
+var ErrTruncated = errors.New("image is truncated")
+
+//Read reads the info of the image in bts by image.DecodeConfig, formats
+//without a registered decoder give image.ErrFormat
+func Read(bts []byte) (*Info, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(bts))
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{
+		Format:     format,
+		Width:      config.Width,
+		Height:     config.Height,
+		Bytes:      len(bts),
+		ColorSpace: colorSpace(config.ColorModel),
+		Alpha:      hasAlpha(config.ColorModel),
+		Frames:     1,
+	}
+	switch format {
+	case "jpeg":
+		err = info.readJpeg(bts)
+	case "png":
+		err = info.readPng(bts)
+	case "gif":
+		err = info.readGif(bts)
+	}
+	info.Animated = info.Frames > 1
+	return info, err
+}
+
+func colorSpace(model color.Model) string {
+	switch model {
+	case color.GrayModel, color.Gray16Model:
+		return "Gray"
+	case color.CMYKModel:
+		return "CMYK"
+	}
+	return "RGB"
+}
+
+func hasAlpha(model color.Model) bool {
+	switch model {
+	case color.NRGBAModel, color.NRGBA64Model:
+		return true
+	}
+	if palette, ok := model.(color.Palette); ok {
+		for _, c := range palette {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//readJpeg reads the exif of the APP1 segments before the image data
+func (this *Info) readJpeg(bts []byte) error {
+	for i := 2; i+4 <= len(bts); {
+		if bts[i] != 0xff {
+			return ErrTruncated
+		}
+		marker := bts[i+1]
+		if marker == 0xff {
+			//fill byte
+			i++
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			//start of scan, end of image
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(bts[i+2:]))
+		if length < 2 || i+2+length > len(bts) {
+			return ErrTruncated
+		}
+		segment := bts[i+4 : i+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			this.readExif(segment[6:])
+		}
+		i += 2 + length
+	}
+	return ErrTruncated
+}
+
+//readPng reads the number of frames of acTL, the alpha of tRNS and the
+//exif of eXIf
+func (this *Info) readPng(bts []byte) error {
+	for i := 8; i+8 <= len(bts); {
+		length := int(binary.BigEndian.Uint32(bts[i:]))
+		chunk := string(bts[i+4 : i+8])
+		if length < 0 || i+12+length > len(bts) {
+			return ErrTruncated
+		}
+		data := bts[i+8 : i+8+length]
+		switch chunk {
+		case "acTL":
+			if length >= 4 {
+				this.Frames = int(binary.BigEndian.Uint32(data))
+			}
+		case "tRNS":
+			this.Alpha = true
+		case "eXIf":
+			this.readExif(data)
+		case "IEND":
+			return nil
+		}
+		i += 12 + length
+	}
+	return ErrTruncated
+}
+
+//readGif counts the image descriptors of a gif, the transparency is that
+//of the graphic control extensions
+func (this *Info) readGif(bts []byte) error {
+	if len(bts) < 13 {
+		return ErrTruncated
+	}
+	i := 13
+	if bts[10]&0x80 != 0 {
+		//global color table
+		i += 3 << (uint(bts[10]&0x07) + 1)
+	}
+	frames := 0
+	for i < len(bts) {
+		switch bts[i] {
+		case 0x21:
+			//extension: label and data sub-blocks
+			if i+4 < len(bts) && bts[i+1] == 0xf9 && bts[i+3]&0x01 != 0 {
+				this.Alpha = true
+			}
+			i += 2
+		case 0x2c:
+			frames++
+			if i+10 > len(bts) {
+				return ErrTruncated
+			}
+			if bts[i+9]&0x80 != 0 {
+				//local color table
+				i += 3 << (uint(bts[i+9]&0x07) + 1)
+			}
+			//descriptor and lzw minimum code size, then data sub-blocks
+			i += 11
+		case 0x3b:
+			this.Frames = frames
+			return nil
+		default:
+			return ErrTruncated
+		}
+		for i < len(bts) && bts[i] != 0 {
+			i += int(bts[i]) + 1
+		}
+		i++
+	}
+	return ErrTruncated
+}
+
+const (
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+)
+
+//readExif reads the orientation and the date of a tiff structure, broken
+//exif is ignored
+func (this *Info) readExif(tiff []byte) {
+	if len(tiff) < 8 {
+		return
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+	dateTime, original := "", ""
+	exifIFD := 0
+	readIFD := func(offset int) {
+		if offset <= 0 || offset+2 > len(tiff) {
+			return
+		}
+		n := int(order.Uint16(tiff[offset:]))
+		for e := offset + 2; e+12 <= len(tiff) && e < offset+2+n*12; e += 12 {
+			tag, count := order.Uint16(tiff[e:]), int(order.Uint32(tiff[e+4:]))
+			switch tag {
+			case tagOrientation:
+				this.Orientation = int(order.Uint16(tiff[e+8:]))
+			case tagExifIFD:
+				exifIFD = int(order.Uint32(tiff[e+8:]))
+			case tagDateTime, tagDateTimeOriginal:
+				at := int(order.Uint32(tiff[e+8:]))
+				if count <= 4 || at < 0 || at+count > len(tiff) {
+					continue
+				}
+				value := strings.TrimRight(string(tiff[at:at+count]), "\x00 ")
+				if tag == tagDateTime {
+					dateTime = value
+				} else {
+					original = value
+				}
+			}
+		}
+	}
+	readIFD(int(order.Uint32(tiff[4:])))
+	readIFD(exifIFD)
+	if original != "" {
+		dateTime = original
+	}
+	//2016:01:02 15:04:05
+	if len(dateTime) == 19 {
+		dateTime = strings.Replace(dateTime[:10], ":", "-", 2) + "T" + dateTime[11:]
+	}
+	this.DateTime = dateTime
+}
@@ -0,0 +1,131 @@
+package imginfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestJpeg(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 30)), nil); err != nil {
+		t.Fatal(err)
+	}
+	bts := buf.Bytes()
+	payload := append([]byte("Exif\x00\x00"), exif()...)
+	app1 := append([]byte{0xff, 0xe1, 0, byte(len(payload) + 2)}, payload...)
+	bts = append(append(append([]byte{}, bts[:2]...), app1...), bts[2:]...)
+
+	info, err := Read(bts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Info{"jpeg", 40, 30, len(bts), "RGB", false, false, 1, 6, "2016-01-02T15:04:05"}
+	if *info != expected {
+		t.Errorf("unexpected info %+v", info)
+	}
+
+	buf.Reset()
+	if err = jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = Read(buf.Bytes()); err != nil || info.ColorSpace != "Gray" || info.Orientation != 0 {
+		t.Errorf("unexpected info %+v of a gray jpeg", info)
+	}
+}
+
+//exif of big endian with the orientation, DateTime and DateTimeOriginal
+func exif() []byte {
+	var b bytes.Buffer
+	w := func(v interface{}) { binary.Write(&b, binary.BigEndian, v) }
+	b.WriteString("MM")
+	w(uint16(42))
+	w(uint32(8))
+	//ifd0 at 8
+	w(uint16(3))
+	w([]uint16{0x0112, 3})
+	w(uint32(1))
+	w([]uint16{6, 0})
+	w([]uint16{0x0132, 2})
+	w(uint32(20))
+	w(uint32(50))
+	w([]uint16{0x8769, 4})
+	w(uint32(1))
+	w(uint32(70))
+	w(uint32(0))
+	b.WriteString("2000:01:01 00:00:00\x00")
+	//exif ifd at 70
+	w(uint16(1))
+	w([]uint16{0x9003, 2})
+	w(uint32(20))
+	w(uint32(88))
+	w(uint32(0))
+	b.WriteString("2016:01:02 15:04:05\x00")
+	return b.Bytes()
+}
+
+func TestPng(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 20, 10))); err != nil {
+		t.Fatal(err)
+	}
+	info, err := Read(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != "png" || info.Width != 20 || info.Height != 10 || !info.Alpha || info.Animated {
+		t.Errorf("unexpected info %+v", info)
+	}
+
+	buf.Reset()
+	opaque := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for i := range opaque.Pix {
+		opaque.Pix[i] = 255
+	}
+	if err = png.Encode(&buf, opaque); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = Read(buf.Bytes()); err != nil || info.Alpha {
+		t.Errorf("unexpected info %+v of an opaque png", info)
+	}
+
+	//an acTL chunk after IHDR makes an apng
+	bts := buf.Bytes()
+	actl := []byte{0, 0, 0, 8, 'a', 'c', 'T', 'L', 0, 0, 0, 3, 0, 0, 0, 0}
+	actl = append(actl, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(actl[16:], crc32.ChecksumIEEE(actl[4:16]))
+	bts = append(append(append([]byte{}, bts[:33]...), actl...), bts[33:]...)
+	if info, err = Read(bts); err != nil || !info.Animated || info.Frames != 3 {
+		t.Errorf("unexpected info %+v of an apng, %v", info, err)
+	}
+}
+
+func TestGif(t *testing.T) {
+	palette := color.Palette{color.Black, color.White, color.Transparent}
+	anim := &gif.GIF{}
+	for i := 0; i < 4; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 16, 16), palette))
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	info, err := Read(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != "gif" || !info.Animated || info.Frames != 4 || !info.Alpha {
+		t.Errorf("unexpected info %+v", info)
+	}
+
+	if _, err = Read([]byte("not an image")); err != image.ErrFormat {
+		t.Error("unknown formats should give image.ErrFormat")
+	}
+}
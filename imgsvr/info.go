@@ -0,0 +1,144 @@
+package imgsvr
+
+import (
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/imgsvr/imginfo"
+	"github.com/ctripcorp/nephele/imgsvr/proc"
+	"github.com/ctripcorp/nephele/telemetry"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//imageInfo is the source image of an url and the size the url produces
+type imageInfo struct {
+	Uri     string        `json:"uri"`
+	Channel string        `json:"channel"`
+	Storage string        `json:"storage"`
+	Source  *imginfo.Info `json:"source,omitempty"`
+	Output  *outputInfo   `json:"output,omitempty"`
+	//the source is decoded by GraphicsMagick if its format isn't known to
+	//image.DecodeConfig, only its format and dimensions are given then
+	Decoded   bool   `json:"decoded,omitempty"`
+	ErrorType string `json:"errorType,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type outputInfo struct {
+	Format string `json:"format"`
+	Width  int64  `json:"width"`
+	Height int64  `json:"height"`
+}
+
+//InfoHandler serves /info/images/..., it fetches the source image of the
+//url and returns its metadata and the dimensions of the processed image as
+//json without processing it
+type InfoHandler struct{}
+
+func (handler *InfoHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	Telemetry := telemetry.Instance()
+	uri := strings.TrimPrefix(request.URL.String(), "/info")
+	info := readImageInfo(uri, Telemetry)
+	bts, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		http.Error(writer, err.Error(), 500)
+		return
+	}
+	status := 200
+	if info.ErrorType != "" {
+		logErrWithUri(uri, JoinString(info.ErrorType, " ", info.Error), "warnLevel")
+		writer.Header().Set(ErrorHeader, info.ErrorType)
+		status = 404
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Content-Length", strconv.Itoa(len(bts)))
+	writer.WriteHeader(status)
+	writer.Write(bts)
+}
+
+//readImageInfo resolves the url like explainUri, reads the source image by
+//imginfo and computes the output size by the resize processors of the chain
+func readImageInfo(uri string, Telemetry telemetry.Telemetry) *imageInfo {
+	info := &imageInfo{Uri: uri}
+	params, isDigimarkUrl, ok := matchUri(uri)
+	if !ok {
+		info.ErrorType = "URI.ParseError"
+		return info
+	}
+	_, info.Channel, _ = ParseUri(params[":1"])
+	store, storageType, err := FindStorage(params, Telemetry)
+	if err != nil {
+		info.ErrorType, info.Error = "Storage.ParseError", err.Error()
+		return info
+	}
+	info.Storage = storageType
+
+	//logos aren't fetched when explaining
+	builder := &ProcChainBuilder{Telemetry: Telemetry, explain: &chainExplain{}}
+	var chain *proc.ProcessorChain
+	var buildErr *buildError
+	if isDigimarkUrl {
+		chain, buildErr = builder.DigimarkProcChain(params)
+	} else {
+		chain, buildErr = builder.Build(params)
+	}
+	if buildErr != nil {
+		info.ErrorType, info.Error = buildErr.Type(), buildErr.Error()
+		return info
+	}
+
+	bts, err := store.GetImage()
+	if err != nil {
+		info.ErrorType, info.Error = JoinString(storageType, ".", storageErrorType(err)), err.Error()
+		return info
+	}
+	if len(bts) == 0 {
+		info.ErrorType = JoinString(storageType, ".ImgLenZero")
+		return info
+	}
+	if info.Source, err = imginfo.Read(bts); info.Source == nil {
+		log.WithFields(log.Fields{
+			"uri": uri,
+		}).Debug("decode image info by graphicsmagick, " + err.Error())
+		info.Decoded = true
+		if info.Source, err = decodeImageInfo(bts, Telemetry); err != nil {
+			info.ErrorType, info.Error = "ProcessError", err.Error()
+			return info
+		}
+	}
+	width, height := chain.OutputSize(int64(info.Source.Width), int64(info.Source.Height))
+	info.Output = &outputInfo{params["ext"], width, height}
+	return info
+}
+
+//decodeImageInfo reads the format and the dimensions of an image which
+//imginfo doesn't know
+func decodeImageInfo(bts []byte, Telemetry telemetry.Telemetry) (*imginfo.Info, error) {
+	img := &img4g.Image{Blob: bts, Telemetry: Telemetry}
+	defer img.DestoryWand()
+	if err := img.CreateWand(); err != nil {
+		return nil, err
+	}
+	width, height, err := img.Size()
+	if err != nil {
+		return nil, err
+	}
+	format, err := img.GetFormat()
+	if err != nil {
+		return nil, err
+	}
+	return &imginfo.Info{Format: strings.ToLower(format), Width: int(width), Height: int(height), Bytes: len(bts)}, nil
+}
+
+//storageErrorType is the type of a storage error, UnExpectedError if it
+//has none
+func storageErrorType(err error) string {
+	if e, ok := err.(interface {
+		Type() string
+	}); ok {
+		return e.Type()
+	}
+	return "UnExpectedError"
+}
@@ -0,0 +1,57 @@
+package imgsvr
+
+import (
+	"github.com/ctripcorp/nephele/imgsvr/data"
+	"github.com/ctripcorp/nephele/imgsvr/metrics"
+	"time"
+)
+
+var (
+	latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+	//same bounds as GetImageSizeDistribution
+	sizeBuckets = []float64{512 * 1024, 1024 * 1024, 2 * 1024 * 1024, 4 * 1024 * 1024, 6 * 1024 * 1024, 10 * 1024 * 1024, 20 * 1024 * 1024, 30 * 1024 * 1024}
+
+	requestCounter = metrics.Default.NewCounterVec("nephele_requests_total",
+		"Image requests by channel, http status and error type.", "channel", "status", "error")
+	stageHistogram = metrics.Default.NewHistogramVec("nephele_stage_duration_seconds",
+		"Duration of each stage of image requests, processors are stages of their own.", latencyBuckets, "stage")
+	storageErrorCounter = metrics.Default.NewCounterVec("nephele_storage_errors_total",
+		"Errors of fetching images by storage backend and error type.", "backend", "error")
+	fallbackCounter = metrics.Default.NewCounterVec("nephele_fallbacks_total",
+		"Fallback images served in place of missing sources by channel and storage error type.", "channel", "error")
+	imageBytesHistogram = metrics.Default.NewHistogramVec("nephele_image_bytes",
+		"Size of source (in) and processed (out) images.", sizeBuckets, "direction")
+	_ = metrics.Default.NewGaugeFunc("nephele_queue_depth",
+		"Tasks waiting in the image queue.", func() float64 { return float64(len(taskChan)) })
+	_ = metrics.Default.NewGaugeFunc("nephele_queue_capacity",
+		"Capacity of the image queue.", func() float64 { return float64(cap(taskChan)) })
+)
+
+//metricChannel bounds the channel label to configured channels
+func metricChannel(channel string) string {
+	if channel == "" || data.Current().HasChannel(channel) {
+		return channel
+	}
+	return "other"
+}
+
+//report observes the stages which have run. The processing stages are
+//only read if processed, otherwise the image goroutine may still write them.
+func (t *requestTiming) report(total time.Duration, processed bool) {
+	if t.fetched {
+		stageHistogram.Observe(t.storage.Seconds(), "storage")
+		imageBytesHistogram.Observe(float64(t.inBytes), "in")
+	}
+	if processed {
+		stageHistogram.Observe(t.queue.Seconds(), "queue")
+		stageHistogram.Observe(t.decode.Seconds(), "decode")
+		for _, p := range t.processors {
+			stageHistogram.Observe(p.duration.Seconds(), p.name)
+		}
+		if t.encoded {
+			stageHistogram.Observe(t.encode.Seconds(), "encode")
+			imageBytesHistogram.Observe(float64(t.outBytes), "out")
+		}
+	}
+	stageHistogram.Observe(total.Seconds(), "total")
+}
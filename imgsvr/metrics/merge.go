@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+)
+
+type family struct {
+	help    string
+	typ     string
+	samples []string
+}
+
+//Merge combines the text format outputs of several processes into w, every
+//sample gets the label name="key of its source". Samples of one metric are
+//grouped under a single HELP and TYPE as the format requires.
+func Merge(w io.Writer, name string, sources map[string][]byte) error {
+	keys := make([]string, 0, len(sources))
+	for k := range sources {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	families := make(map[string]*family)
+	order := []string{}
+	get := func(n string) *family {
+		f, ok := families[n]
+		if !ok {
+			f = &family{}
+			families[n] = f
+			order = append(order, n)
+		}
+		return f
+	}
+	for _, key := range keys {
+		label := name + `="` + escapeLabel(key) + `"`
+		current := ""
+		scanner := bufio.NewScanner(bytes.NewReader(sources[key]))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			if strings.HasPrefix(line, "#") {
+				fields := strings.SplitN(line, " ", 4)
+				if len(fields) < 3 || (fields[1] != "HELP" && fields[1] != "TYPE") {
+					continue
+				}
+				current = fields[2]
+				f := get(current)
+				rest := ""
+				if len(fields) == 4 {
+					rest = fields[3]
+				}
+				if fields[1] == "HELP" && f.help == "" {
+					f.help = rest
+				}
+				if fields[1] == "TYPE" && f.typ == "" {
+					f.typ = rest
+				}
+				continue
+			}
+			i := strings.IndexAny(line, "{ ")
+			if i < 0 {
+				continue
+			}
+			sample := line[:i]
+			owner := sample
+			if current != "" && strings.HasPrefix(sample, current) {
+				owner = current
+			}
+			if line[i] == '{' {
+				sep := ","
+				if strings.HasPrefix(line[i+1:], "}") {
+					sep = ""
+				}
+				line = sample + "{" + label + sep + line[i+1:]
+			} else {
+				line = sample + "{" + label + "}" + line[i:]
+			}
+			get(owner).samples = append(get(owner).samples, line)
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, n := range order {
+		f := families[n]
+		if f.help != "" {
+			buf.WriteString("# HELP " + n + " " + f.help + "\n")
+		}
+		if f.typ != "" {
+			buf.WriteString("# TYPE " + n + " " + f.typ + "\n")
+		}
+		for _, s := range f.samples {
+			buf.WriteString(s + "\n")
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,256 @@
+//Package metrics exposes counters, gauges and histograms in the
+//Prometheus text format without depending on the Prometheus client.
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type metric interface {
+	write(buf *bytes.Buffer)
+}
+
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+//Default is the registry of the process, served by Handler
+var Default = NewRegistry()
+
+func (r *Registry) register(m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+//WriteText writes all metrics in the Prometheus text format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.mutex.Unlock()
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		m.write(&buf)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+//Handler serves the metrics of r
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteText(w)
+	})
+}
+
+//series is the labels of a sample joined by '\xff'
+type series struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (s *series) key(values []string) string {
+	if len(values) != len(s.labels) {
+		panic("metrics: " + s.name + " expects " + strconv.Itoa(len(s.labels)) + " label values")
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (s *series) header(buf *bytes.Buffer, typ string) {
+	buf.WriteString("# HELP " + s.name + " " + s.help + "\n")
+	buf.WriteString("# TYPE " + s.name + " " + typ + "\n")
+}
+
+//labelString formats labels as {a="x",b="y"}, extra is appended as is
+func (s *series) labelString(key string, extra string) string {
+	arr := []string{}
+	if len(s.labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			arr = append(arr, s.labels[i]+`="`+escapeLabel(v)+`"`)
+		}
+	}
+	if extra != "" {
+		arr = append(arr, extra)
+	}
+	if len(arr) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(arr, ",") + "}"
+}
+
+func escapeLabel(v string) string {
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, `"`, `\"`, -1)
+	return strings.Replace(v, "\n", `\n`, -1)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	series
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{series: series{name, help, labels}, values: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) Add(v float64, values ...string) {
+	key := c.key(values)
+	c.mutex.Lock()
+	c.values[key] += v
+	c.mutex.Unlock()
+}
+
+func (c *CounterVec) write(buf *bytes.Buffer) {
+	c.header(buf, "counter")
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		buf.WriteString(c.name + c.labelString(key, "") + " " + formatFloat(c.values[key]) + "\n")
+	}
+}
+
+//GaugeFunc is a gauge whose value is read when scraped
+type GaugeFunc struct {
+	series
+	fn func() float64
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{series: series{name: name, help: help}, fn: fn}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(buf *bytes.Buffer) {
+	g.header(buf, "gauge")
+	buf.WriteString(g.name + " " + formatFloat(g.fn()) + "\n")
+}
+
+//GaugeVec is a gauge partitioned by labels
+type GaugeVec struct {
+	series
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{series: series{name, help, labels}, values: make(map[string]float64)}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeVec) Set(v float64, values ...string) {
+	key := g.key(values)
+	g.mutex.Lock()
+	g.values[key] = v
+	g.mutex.Unlock()
+}
+
+func (g *GaugeVec) write(buf *bytes.Buffer) {
+	g.header(buf, "gauge")
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for _, key := range sortedKeys(g.values) {
+		buf.WriteString(g.name + g.labelString(key, "") + " " + formatFloat(g.values[key]) + "\n")
+	}
+}
+
+//HistogramVec is a histogram partitioned by labels, buckets are the upper
+//bounds in increasing order, +Inf is implicit
+type HistogramVec struct {
+	series
+	buckets []float64
+	mutex   sync.Mutex
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{series: series{name, help, labels}, buckets: buckets, values: make(map[string]*histogram)}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := h.key(values)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			hist.counts[i]++
+		}
+	}
+	hist.sum += v
+	hist.count++
+}
+
+func (h *HistogramVec) write(buf *bytes.Buffer) {
+	h.header(buf, "histogram")
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		hist := h.values[key]
+		for i, bound := range h.buckets {
+			buf.WriteString(h.name + "_bucket" + h.labelString(key, `le="`+formatFloat(bound)+`"`) + " " + strconv.FormatUint(hist.counts[i], 10) + "\n")
+		}
+		buf.WriteString(h.name + "_bucket" + h.labelString(key, `le="+Inf"`) + " " + strconv.FormatUint(hist.count, 10) + "\n")
+		buf.WriteString(h.name + "_sum" + h.labelString(key, "") + " " + formatFloat(hist.sum) + "\n")
+		buf.WriteString(h.name + "_count" + h.labelString(key, "") + " " + strconv.FormatUint(hist.count, 10) + "\n")
+	}
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("requests_total", "Requests.", "channel", "status")
+	c.Inc("tg", "200")
+	c.Inc("tg", "200")
+	c.Inc("hotel", "404")
+	h := r.NewHistogramVec("duration_seconds", "Durations.", []float64{0.1, 1}, "stage")
+	h.Observe(0.05, "storage")
+	h.Observe(0.5, "storage")
+	r.NewGaugeFunc("queue_depth", "Queue depth.", func() float64 { return 3 })
+	g := r.NewGaugeVec("up", "Up.", "path")
+	g.Set(1, `a"b`)
+
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+	expect := `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{channel="hotel",status="404"} 1
+requests_total{channel="tg",status="200"} 2
+# HELP duration_seconds Durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{stage="storage",le="0.1"} 1
+duration_seconds_bucket{stage="storage",le="1"} 2
+duration_seconds_bucket{stage="storage",le="+Inf"} 2
+duration_seconds_sum{stage="storage"} 0.55
+duration_seconds_count{stage="storage"} 2
+# HELP queue_depth Queue depth.
+# TYPE queue_depth gauge
+queue_depth 3
+# HELP up Up.
+# TYPE up gauge
+up{path="a\"b"} 1
+`
+	if buf.String() != expect {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestMerge(t *testing.T) {
+	w1 := []byte("# HELP a_total A.\n# TYPE a_total counter\na_total{x=\"1\"} 2\n# HELP h H.\n# TYPE h histogram\nh_bucket{le=\"+Inf\"} 1\nh_sum 0.5\nh_count 1\n")
+	w2 := []byte("# HELP a_total A.\n# TYPE a_total counter\na_total{x=\"1\"} 3\n")
+	var buf bytes.Buffer
+	Merge(&buf, "port", map[string][]byte{"8081": w1, "8082": w2})
+	expect := `# HELP a_total A.
+# TYPE a_total counter
+a_total{port="8081",x="1"} 2
+a_total{port="8082",x="1"} 3
+# HELP h H.
+# TYPE h histogram
+h_bucket{port="8081",le="+Inf"} 1
+h_sum{port="8081"} 0.5
+h_count{port="8081"} 1
+`
+	if buf.String() != expect {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestRuntime(t *testing.T) {
+	r := NewRegistry()
+	RegisterRuntime(r)
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+	if !strings.Contains(buf.String(), "\ngo_memstats_heap_alloc_bytes ") {
+		t.Error("heap alloc is missing")
+	}
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"bytes"
+	"runtime"
+)
+
+//runtimeCollector exports the memory stats sent to CAT by util.GetStatus,
+//MemStats is read once per scrape
+type runtimeCollector struct{}
+
+//RegisterRuntime adds go runtime stats to r
+func RegisterRuntime(r *Registry) {
+	r.register(runtimeCollector{})
+}
+
+func (runtimeCollector) write(buf *bytes.Buffer) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	gauge := func(name, help string, v float64) {
+		buf.WriteString("# HELP " + name + " " + help + "\n# TYPE " + name + " gauge\n" + name + " " + formatFloat(v) + "\n")
+	}
+	counter := func(name, help string, v float64) {
+		buf.WriteString("# HELP " + name + " " + help + "\n# TYPE " + name + " counter\n" + name + " " + formatFloat(v) + "\n")
+	}
+	gauge("go_goroutines", "Number of goroutines.", float64(runtime.NumGoroutine()))
+	gauge("go_memstats_alloc_bytes", "Bytes allocated and still in use.", float64(mem.Alloc))
+	counter("go_memstats_alloc_bytes_total", "Total bytes allocated.", float64(mem.TotalAlloc))
+	gauge("go_memstats_sys_bytes", "Bytes obtained from system.", float64(mem.Sys))
+	counter("go_memstats_mallocs_total", "Total number of mallocs.", float64(mem.Mallocs))
+	counter("go_memstats_frees_total", "Total number of frees.", float64(mem.Frees))
+	gauge("go_memstats_heap_alloc_bytes", "Heap bytes allocated and still in use.", float64(mem.HeapAlloc))
+	gauge("go_memstats_heap_sys_bytes", "Heap bytes obtained from system.", float64(mem.HeapSys))
+	gauge("go_memstats_heap_idle_bytes", "Heap bytes waiting to be used.", float64(mem.HeapIdle))
+	gauge("go_memstats_heap_inuse_bytes", "Heap bytes in use.", float64(mem.HeapInuse))
+	gauge("go_memstats_heap_released_bytes", "Heap bytes released to the OS.", float64(mem.HeapReleased))
+	gauge("go_memstats_heap_objects", "Number of allocated objects.", float64(mem.HeapObjects))
+	gauge("go_memstats_other_sys_bytes", "Bytes used for other system allocations.", float64(mem.OtherSys))
+	gauge("go_memstats_next_gc_bytes", "Heap size when the next GC will take place.", float64(mem.NextGC))
+	gauge("go_memstats_last_gc_time_seconds", "Unix time of the last GC.", float64(mem.LastGC)/1e9)
+	gauge("go_gc_last_pause_seconds", "Duration of the last GC pause.", float64(mem.PauseNs[(mem.NumGC+255)%256])/1e9)
+	counter("go_gc_cycles_total", "Number of completed GC cycles.", float64(mem.NumGC))
+	gauge("go_gomaxprocs", "Value of GOMAXPROCS.", float64(runtime.GOMAXPROCS(0)))
+}
@@ -0,0 +1,158 @@
+package imgsvr
+
+import (
+	"errors"
+	"github.com/ctripcorp/nephele/imgsvr/data"
+	"github.com/ctripcorp/nephele/imgsvr/proc"
+	"strconv"
+)
+
+//operation is a processor which can be used in sequenceofoperation,
+//channel quirks are configured as fixed params: resize,sharpen(sigma=0.5)
+type operation struct {
+	//cat event type of build errors
+	errType string
+	//fixed params accepted in config
+	params []string
+	//validate checks the fixed params, it may be nil
+	validate func(args map[string]string) error
+	//build returns the processors of a request, nil processors are skipped
+	build func(b *ProcChainBuilder, req *buildRequest, args map[string]string) ([]proc.ImageProcessor, error)
+}
+
+type buildRequest struct {
+	sourceType string
+	channel    string
+	path       string
+	policy     *data.ChannelPolicy
+	params     map[string]string
+	//the config policy was taken from, other channels are read from it too
+	config *data.Policy
+}
+
+var (
+	CmdStrip            = "s"
+	CmdFormat           = "f"
+	CmdResize           = "resize"
+	CmdQuality          = "q"
+	CmdWaterMark        = "m"
+	CmdRotate           = "rotate"
+	CmdDigitalWatermark = "d"
+	CmdSharpen          = "sharpen"
+)
+
+var operations = map[string]*operation{
+	CmdStrip: {
+		errType: "UrlStripCmdError",
+		build: func(b *ProcChainBuilder, req *buildRequest, args map[string]string) ([]proc.ImageProcessor, error) {
+			p, err := b.getStripProcessor(req.channel, req.params)
+			return []proc.ImageProcessor{p}, err
+		},
+	},
+	CmdFormat: {
+		errType: "UrlFormatCmdError",
+		build: func(b *ProcChainBuilder, req *buildRequest, args map[string]string) ([]proc.ImageProcessor, error) {
+			p, err := b.getFormatProcessor(req.channel, req.params)
+			return []proc.ImageProcessor{p}, err
+		},
+	},
+	CmdResize: {
+		errType: "UrlResizeCmdError",
+		build: func(b *ProcChainBuilder, req *buildRequest, args map[string]string) ([]proc.ImageProcessor, error) {
+			p, err := b.getResizeProcessor(req.channel, req.policy, req.params)
+			return []proc.ImageProcessor{p}, err
+		},
+	},
+	CmdQuality: {
+		errType: "UrlQualityCmdError",
+		build: func(b *ProcChainBuilder, req *buildRequest, args map[string]string) ([]proc.ImageProcessor, error) {
+			p, err := b.getQualityProcessor(req.channel, req.policy, req.params)
+			return []proc.ImageProcessor{p}, err
+		},
+	},
+	CmdWaterMark: {
+		errType: "UrlWaterMarkCmdError",
+		build: func(b *ProcChainBuilder, req *buildRequest, args map[string]string) ([]proc.ImageProcessor, error) {
+			return b.getWaterMarkProcessors(req.sourceType, req.channel, req.path, req.policy, req.params)
+		},
+	},
+	CmdRotate: {
+		errType: "UrlRotateCmdError",
+		build: func(b *ProcChainBuilder, req *buildRequest, args map[string]string) ([]proc.ImageProcessor, error) {
+			p, err := b.getRotateProcessor(req.channel, req.policy, req.config.Channel(Hotel), req.params)
+			return []proc.ImageProcessor{p}, err
+		},
+	},
+	CmdDigitalWatermark: {
+		errType: "UrlDigitalWatermarkCmdError",
+		build: func(b *ProcChainBuilder, req *buildRequest, args map[string]string) ([]proc.ImageProcessor, error) {
+			p, err := b.getDigitalWatermarkProcessor(req.policy, req.params)
+			return []proc.ImageProcessor{p}, err
+		},
+	},
+	CmdSharpen: {
+		errType: "UrlSharpenCmdError",
+		params:  []string{"radius", "sigma"},
+		validate: func(args map[string]string) error {
+			_, _, err := parseSharpenArgs(args)
+			return err
+		},
+		build: func(b *ProcChainBuilder, req *buildRequest, args map[string]string) ([]proc.ImageProcessor, error) {
+			radius, sigma, err := parseSharpenArgs(args)
+			if err != nil {
+				return nil, errors.New(JoinString("channel: ", req.channel, ", reason: ", err.Error()))
+			}
+			return []proc.ImageProcessor{&proc.SharpenProcessor{radius, sigma, b.Telemetry}}, nil
+		},
+	},
+}
+
+//the digital watermark url doesn't follow the sequence of the channel
+var digimarkSequence = []data.Step{{Name: CmdStrip}, {Name: CmdDigitalWatermark}, {Name: CmdFormat}}
+
+//CheckStep checks the operation and fixed params of a step of
+//sequenceofoperation
+func CheckStep(step data.Step) error {
+	op, ok := operations[step.Name]
+	if !ok {
+		return errors.New("unknown operation")
+	}
+	for key := range step.Args {
+		if !containsString(op.params, key) {
+			return errors.New("unknown param " + key)
+		}
+	}
+	if op.validate != nil {
+		return op.validate(step.Args)
+	}
+	return nil
+}
+
+//sharpen(radius=R,sigma=S), radius 0 lets GraphicsMagick choose one
+func parseSharpenArgs(args map[string]string) (float64, float64, error) {
+	var radius float64
+	var err error
+	if v, ok := args["radius"]; ok {
+		if radius, err = strconv.ParseFloat(v, 64); err != nil || radius < 0 {
+			return 0, 0, errors.New("invalid sharpen radius " + v)
+		}
+	}
+	v, ok := args["sigma"]
+	if !ok {
+		return 0, 0, errors.New("sharpen sigma is required")
+	}
+	sigma, err := strconv.ParseFloat(v, 64)
+	if err != nil || sigma <= 0 || sigma > 10 {
+		return 0, 0, errors.New("invalid sharpen sigma " + v)
+	}
+	return radius, sigma, nil
+}
+
+func containsString(arr []string, s string) bool {
+	for _, v := range arr {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
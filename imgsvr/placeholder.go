@@ -0,0 +1,157 @@
+package imgsvr
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"github.com/ctripcorp/nephele/imgsvr/data"
+	"github.com/ctripcorp/nephele/telemetry"
+	"github.com/ctripcorp/nephele/util"
+	"github.com/ctripcorp/nephele/util/placeholder"
+	"net/http"
+	"regexp"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//the placeholder of the source image of /images/PATH.EXT
+var placeholderUrl = util.RegexpExt{regexp.MustCompile("^/images/(.*?)_P.(?P<ext>jpg|jpeg|gif|png|Jpg)$")}
+
+//response header of placeholders, HIT if it is served from the cache
+const PlaceholderCacheHeader = "X-Nephele-Cache"
+
+var (
+	placeholders = &placeholderCache{entries: make(map[string]*list.Element), order: list.New()}
+	//placeholders are decoded in go on the request goroutine, at most one
+	//per cpu at a time
+	placeholderSlots = make(chan struct{}, runtime.NumCPU())
+)
+
+//PlaceholderHandler serves the BlurHash and the colors of an image as json,
+//Handler hands _P urls to it
+type PlaceholderHandler struct{}
+
+func (handler *PlaceholderHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	atomic.AddInt64(&servedRequests, 1)
+	atomic.AddInt64(&inFlightRequests, 1)
+	defer atomic.AddInt64(&inFlightRequests, -1)
+	Telemetry := telemetry.Instance()
+	uri := request.URL.String()
+	tran := Telemetry.NewTransaction("URL", "Placeholder")
+	channel, status, errType := "", "200", ""
+	defer func() {
+		if errType != "" {
+			atomic.AddInt64(&failedRequests, 1)
+			status = "404"
+			tran.SetStatus(errors.New(errType))
+			writer.Header().Set(ErrorHeader, errType)
+			http.Error(writer, http.StatusText(404), 404)
+		} else {
+			tran.SetStatus("0")
+		}
+		tran.Complete()
+		requestCounter.Inc(metricChannel(channel), status, errType)
+	}()
+
+	params, ok := placeholderUrl.FindStringSubmatchMap(uri)
+	if !ok {
+		errType = "URI.ParseError"
+		logErrWithUri(uri, errType, "warnLevel")
+		return
+	}
+	_, channel, _ = ParseUri(params[":1"])
+	bts, cached := placeholders.get(uri)
+	if !cached {
+		var err error
+		if bts, errType, err = makePlaceholder(params, Telemetry); errType != "" {
+			logErrWithUri(uri, err.Error(), "warnLevel")
+			LogErrorEvent(Telemetry, errType, err.Error())
+			return
+		}
+		placeholders.add(uri, bts, data.Current().PlaceholderCache)
+	}
+	if cached {
+		writer.Header().Set(PlaceholderCacheHeader, "HIT")
+	} else {
+		writer.Header().Set(PlaceholderCacheHeader, "MISS")
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Content-Length", strconv.Itoa(len(bts)))
+	writer.Header().Set("Last-Modified", "2015/1/1 01:01:01")
+	writer.Write(bts)
+}
+
+//makePlaceholder fetches the source image and makes its placeholder as
+//json, the error types are those of Handler
+func makePlaceholder(params map[string]string, Telemetry telemetry.Telemetry) ([]byte, string, error) {
+	store, storageType, err := FindStorage(params, Telemetry)
+	if err != nil {
+		return nil, "Storage.ParseError", err
+	}
+	bts, err := store.GetImage()
+	if err != nil {
+		errType := storageErrorType(err)
+		storageErrorCounter.Inc(storageType, errType)
+		return nil, JoinString(storageType, ".", errType), err
+	}
+	if len(bts) == 0 {
+		storageErrorCounter.Inc(storageType, "ImgLenZero")
+		return nil, JoinString(storageType, ".ImgLenZero"), errors.New("recv image length is 0")
+	}
+
+	placeholderSlots <- struct{}{}
+	start := time.Now()
+	p, err := placeholder.Decode(bts)
+	stageHistogram.Observe(time.Since(start).Seconds(), "placeholder")
+	<-placeholderSlots
+	if err != nil {
+		return nil, "ProcessError", err
+	}
+	if bts, err = json.Marshal(p); err != nil {
+		return nil, "ProcessError", err
+	}
+	return bts, "", nil
+}
+
+//placeholderCache keeps the most recently used placeholders
+type placeholderCache struct {
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type placeholderEntry struct {
+	key string
+	bts []byte
+}
+
+func (c *placeholderCache) get(key string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*placeholderEntry).bts, true
+}
+
+//add adds an entry and evicts the least recently used ones beyond capacity
+func (c *placeholderCache) add(key string, bts []byte, capacity int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*placeholderEntry).bts = bts
+		c.order.MoveToFront(e)
+	} else {
+		c.entries[key] = c.order.PushFront(&placeholderEntry{key, bts})
+	}
+	for c.order.Len() > capacity {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.entries, e.Value.(*placeholderEntry).key)
+	}
+}
@@ -0,0 +1,73 @@
+package proc
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/telemetry"
+)
+
+//Tile is an image processed by its own chain and composited in the cell at
+//X, Y of Width x Height, it is centered in the cell if it is smaller
+type Tile struct {
+	Image  *img4g.Image
+	Chain  *ProcessorChain
+	X      int64
+	Y      int64
+	Width  int64
+	Height int64
+}
+
+//CompositeProcessor scales the image to Width x Height and composites the
+//tiles onto it, e.g. of a sprite sheet. The image is the background, a pixel
+//of its color is enough, so the canvas is only allocated by GraphicsMagick.
+type CompositeProcessor struct {
+	Width     int64
+	Height    int64
+	Tiles     []*Tile
+	Telemetry telemetry.Telemetry
+}
+
+func (this *CompositeProcessor) Process(img *img4g.Image) error {
+	log.WithFields(log.Fields{
+		"width":  this.Width,
+		"height": this.Height,
+		"tiles":  len(this.Tiles),
+	}).Debug("process composite")
+	var err error
+	tran := this.Telemetry.NewTransaction("Command", "Composite")
+	defer func() {
+		tran.SetStatus(err)
+		tran.Complete()
+	}()
+	if err = img.Scale(this.Width, this.Height); err != nil {
+		return err
+	}
+	for _, tile := range this.Tiles {
+		if err = this.composite(img, tile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *CompositeProcessor) composite(img *img4g.Image, tile *Tile) error {
+	defer tile.Image.DestoryWand()
+	if err := tile.Image.CreateWand(); err != nil {
+		return err
+	}
+	if err := tile.Chain.Process(tile.Image); err != nil {
+		return err
+	}
+	width, height, err := tile.Image.Size()
+	if err != nil {
+		return err
+	}
+	x, y := tile.X, tile.Y
+	if width < tile.Width {
+		x += (tile.Width - width) / 2
+	}
+	if height < tile.Height {
+		y += (tile.Height - height) / 2
+	}
+	return img.Composite(tile.Image, x, y)
+}
@@ -2,8 +2,8 @@ package proc
 
 import (
 	log "github.com/Sirupsen/logrus"
-	cat "github.com/ctripcorp/cat.go"
 	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/telemetry"
 	"math"
 	"strconv"
 	"strings"
@@ -11,7 +11,7 @@ import (
 
 type DigitalWatermarkProcessor struct {
 	Copyright *img4g.Image
-	Cat       cat.Cat
+	Telemetry telemetry.Telemetry
 }
 
 func (this *DigitalWatermarkProcessor) Process(img *img4g.Image) error {
@@ -25,7 +25,7 @@ func (this *DigitalWatermarkProcessor) Process(img *img4g.Image) error {
 	if strings.ToLower(format) != "jpg" && strings.ToLower(format) != "jpeg" {
 		info := make(map[string]string)
 		info["format"] = format
-		logEvent(this.Cat, "DigitalWatermarkRefuse", "NotSupportFormat", info)
+		telemetry.LogEvent(this.Telemetry, "DigitalWatermarkRefuse", "NotSupportFormat", info)
 		return nil
 	}
 
@@ -36,7 +36,7 @@ func (this *DigitalWatermarkProcessor) Process(img *img4g.Image) error {
 	if width < 256 {
 		info := make(map[string]string)
 		info["width"] = strconv.Itoa(int(width))
-		logEvent(this.Cat, "DigitalWatermarkRefuse", "NotSupportSize", info)
+		telemetry.LogEvent(this.Telemetry, "DigitalWatermarkRefuse", "NotSupportSize", info)
 		return nil
 	}
 
@@ -47,12 +47,12 @@ func (this *DigitalWatermarkProcessor) Process(img *img4g.Image) error {
 	if height < 256 {
 		info := make(map[string]string)
 		info["height"] = strconv.Itoa(int(height))
-		logEvent(this.Cat, "DigitalWatermarkRefuse", "NotSupportSize", info)
+		telemetry.LogEvent(this.Telemetry, "DigitalWatermarkRefuse", "NotSupportSize", info)
 		return nil
 	}
 
 	upr := ((int(math.Min(float64(width), float64(height))) / 100.0) + 1) * 100
-	tran := this.Cat.NewTransaction("DigitalWatermark", "Min(width, height)<"+strconv.Itoa(int(upr)))
+	tran := this.Telemetry.NewTransaction("DigitalWatermark", "Min(width, height)<"+strconv.Itoa(int(upr)))
 	tran.AddData("size", "width: "+strconv.Itoa(int(width))+"height: "+strconv.Itoa(int(height)))
 	defer func() {
 		this.Copyright.DestoryWand()
@@ -66,14 +66,3 @@ func (this *DigitalWatermarkProcessor) Process(img *img4g.Image) error {
 
 	return err
 }
-
-func logEvent(cat cat.Cat, title string, name string, data map[string]string) {
-	event := cat.NewEvent(title, name)
-	if data != nil {
-		for k, v := range data {
-			event.AddData(k, v)
-		}
-	}
-	event.SetStatus("0")
-	event.Complete()
-}
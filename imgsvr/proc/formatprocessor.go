@@ -2,13 +2,13 @@ package proc
 
 import (
 	log "github.com/Sirupsen/logrus"
-	cat "github.com/ctripcorp/cat.go"
 	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/telemetry"
 )
 
 type FormatProcessor struct {
-	Format string
-	Cat    cat.Cat
+	Format    string
+	Telemetry telemetry.Telemetry
 }
 
 func (this *FormatProcessor) Process(img *img4g.Image) error {
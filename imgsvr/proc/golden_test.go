@@ -0,0 +1,382 @@
+package proc
+
+//Golden image tests: every fixture of testdata/golden.json processes a
+//generated input image by a chain of url-like operations and checks the
+//format and the dimensions of the result, and its pixels against
+//testdata/golden/NAME.png within the PSNR and SSIM tolerances of the
+//fixture. After an intended change of the output, rewrite the goldens by
+//
+//	go test ./imgsvr/proc -run TestGolden -update
+//
+//and review the changed images before committing them.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/telemetry"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden images of TestGolden")
+
+const (
+	defaultMinPSNR = 35
+	defaultMinSSIM = 0.95
+)
+
+type goldenFixture struct {
+	Name  string `json:"name"`
+	Input string `json:"input"`
+	//operations in the order of the chain:
+	//	C_W_H, R_W_H, W_W_H, Z_W_H   resize by the mode of the url
+	//	rotate_DEGREES, Q_QUALITY, M_LOCATION (the logo, 9 if 0), strip,
+	//	sharpen_RADIUS_SIGMA, F_FORMAT
+	Ops    []string `json:"ops"`
+	Width  int64    `json:"width"`
+	Height int64    `json:"height"`
+	//format of the result as image.DecodeConfig names it
+	Format string `json:"format"`
+	//the result is smaller than the one of the named fixture
+	SmallerThan string `json:"smallerThan,omitempty"`
+	//the result has no exif, which the jpeg inputs carry
+	NoExif  bool    `json:"noExif,omitempty"`
+	MinPSNR float64 `json:"minPsnr,omitempty"`
+	MinSSIM float64 `json:"minSsim,omitempty"`
+}
+
+func TestGolden(t *testing.T) {
+	bts, err := ioutil.ReadFile("testdata/golden.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []*goldenFixture
+	if err = json.Unmarshal(bts, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+	outputs := make(map[string][]byte)
+	for _, f := range fixtures {
+		out, err := f.process()
+		if err != nil {
+			t.Errorf("%s: %s", f.Name, err.Error())
+			continue
+		}
+		outputs[f.Name] = out
+		if err = f.check(out, outputs); err != nil {
+			t.Errorf("%s: %s", f.Name, err.Error())
+		}
+	}
+}
+
+//process runs the chain of the fixture on its input and encodes the result
+func (this *goldenFixture) process() ([]byte, error) {
+	input, err := goldenInput(this.Input)
+	if err != nil {
+		return nil, err
+	}
+	img := &img4g.Image{Blob: input, Format: strings.TrimPrefix(filepath.Ext(this.Input), "."), Telemetry: telemetry.Noop}
+	if err = img.CreateWand(); err != nil {
+		return nil, err
+	}
+	defer img.DestoryWand()
+	chain := &ProcessorChain{}
+	for _, op := range this.Ops {
+		p, err := goldenProcessor(op)
+		if err != nil {
+			return nil, err
+		}
+		chain.Chain = append(chain.Chain, p)
+	}
+	if err = chain.Process(img); err != nil {
+		return nil, err
+	}
+	if err = img.WriteImageBlob(); err != nil {
+		return nil, err
+	}
+	return img.Blob, nil
+}
+
+func (this *goldenFixture) check(out []byte, outputs map[string][]byte) error {
+	config, format, err := image.DecodeConfig(bytes.NewReader(out))
+	if err != nil {
+		return err
+	}
+	if format != this.Format {
+		return errors.New("format " + format + ", expected " + this.Format)
+	}
+	if int64(config.Width) != this.Width || int64(config.Height) != this.Height {
+		return errors.New(size(config.Width, config.Height) + ", expected " + size(int(this.Width), int(this.Height)))
+	}
+	if this.SmallerThan != "" && len(out) >= len(outputs[this.SmallerThan]) {
+		return errors.New(strconv.Itoa(len(out)) + " bytes, expected less than " + this.SmallerThan)
+	}
+	if this.NoExif && bytes.Contains(out, []byte("Exif\x00\x00")) {
+		return errors.New("exif isn't stripped")
+	}
+
+	got, _, err := image.Decode(bytes.NewReader(out))
+	if err != nil {
+		return err
+	}
+	path := filepath.Join("testdata", "golden", this.Name+".png")
+	if *update {
+		var buf bytes.Buffer
+		if err = png.Encode(&buf, got); err != nil {
+			return err
+		}
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, buf.Bytes(), 0644)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.New("no golden image " + path + ", generate it on a host with GraphicsMagick by go test ./imgsvr/proc -run TestGolden -update")
+	}
+	defer file.Close()
+	golden, err := png.Decode(file)
+	if err != nil {
+		return err
+	}
+	minPSNR, minSSIM := this.MinPSNR, this.MinSSIM
+	if minPSNR == 0 {
+		minPSNR = defaultMinPSNR
+	}
+	if minSSIM == 0 {
+		minSSIM = defaultMinSSIM
+	}
+	p, err := psnr(got, golden)
+	if err != nil {
+		return err
+	}
+	if p < minPSNR {
+		return errors.New("psnr " + strconv.FormatFloat(p, 'f', 2, 64) + " against the golden image is below " + strconv.FormatFloat(minPSNR, 'f', 2, 64))
+	}
+	if s := ssim(got, golden); s < minSSIM {
+		return errors.New("ssim " + strconv.FormatFloat(s, 'f', 4, 64) + " against the golden image is below " + strconv.FormatFloat(minSSIM, 'f', 4, 64))
+	}
+	return nil
+}
+
+func size(w, h int) string {
+	return strconv.Itoa(w) + "x" + strconv.Itoa(h)
+}
+
+func goldenProcessor(op string) (ImageProcessor, error) {
+	args := strings.Split(op, "_")
+	ints := make([]int64, len(args)-1)
+	for i, arg := range args[1:] {
+		if args[0] == "F" || args[0] == "sharpen" {
+			break
+		}
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, errors.New(op + ": " + err.Error())
+		}
+		ints[i] = n
+	}
+	switch {
+	case len(args) == 3 && args[0] == "C":
+		return &ResizeCProcessor{Width: ints[0], Height: ints[1], Telemetry: telemetry.Noop}, nil
+	case len(args) == 3 && args[0] == "R":
+		return &ResizeRProcessor{ints[0], ints[1], telemetry.Noop}, nil
+	case len(args) == 3 && args[0] == "W":
+		return &ResizeWProcessor{ints[0], ints[1], telemetry.Noop}, nil
+	case len(args) == 3 && args[0] == "Z":
+		return &ResizeZProcessor{Width: ints[0], Height: ints[1], Telemetry: telemetry.Noop}, nil
+	case len(args) == 2 && args[0] == "rotate":
+		return &RotateProcessor{float64(ints[0]), telemetry.Noop}, nil
+	case len(args) == 2 && args[0] == "Q":
+		return &QualityProcessor{int(ints[0]), telemetry.Noop}, nil
+	case len(args) == 2 && args[0] == "M":
+		logo, err := goldenInput("logo.png")
+		if err != nil {
+			return nil, err
+		}
+		return &WaterMarkProcessor{
+			Logo:          &img4g.Image{Blob: logo, Format: "png", Telemetry: telemetry.Noop},
+			Location:      int(ints[0]),
+			Telemetry:     telemetry.Noop,
+			WaterMarkType: "Logo",
+		}, nil
+	case len(args) == 1 && args[0] == "strip":
+		return &StripProcessor{telemetry.Noop}, nil
+	case len(args) == 3 && args[0] == "sharpen":
+		radius, err1 := strconv.ParseFloat(args[1], 64)
+		sigma, err2 := strconv.ParseFloat(args[2], 64)
+		if err1 != nil || err2 != nil {
+			return nil, errors.New(op + ": invalid radius or sigma")
+		}
+		return &SharpenProcessor{radius, sigma, telemetry.Noop}, nil
+	case len(args) == 2 && args[0] == "F":
+		return &FormatProcessor{args[1], telemetry.Noop}, nil
+	}
+	return nil, errors.New(op + ": unknown operation")
+}
+
+//goldenInput generates the input images, so that no binary input is kept
+//in testdata. The jpeg inputs carry an exif segment for strip.
+func goldenInput(name string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch name {
+	case "landscape.jpg":
+		err = jpeg.Encode(&buf, pattern(400, 300, false), &jpeg.Options{Quality: 95})
+	case "small.jpg":
+		err = jpeg.Encode(&buf, pattern(80, 60, false), &jpeg.Options{Quality: 95})
+	case "portrait.png":
+		err = png.Encode(&buf, pattern(240, 320, true))
+	case "logo.png":
+		logo := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+		for y := 0; y < 20; y++ {
+			for x := 0; x < 40; x++ {
+				if x < 2 || x > 37 || y < 2 || y > 17 || (x+y)%6 < 2 {
+					logo.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 200})
+				}
+			}
+		}
+		err = png.Encode(&buf, logo)
+	default:
+		return nil, errors.New("unknown input " + name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(name, ".jpg") {
+		return withExif(buf.Bytes()), nil
+	}
+	return buf.Bytes(), nil
+}
+
+//pattern draws gradients, a grid and a disc off center, so that crops,
+//rotations and resizes of the image differ from each other
+func pattern(w, h int, alpha bool) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	cx, cy, r := float64(w)/3, float64(h)/3, float64(w+h)/10
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), 128, 255}
+			if x%40 == 0 || y%40 == 0 {
+				c = color.NRGBA{0, 0, 0, 255}
+			}
+			if math.Hypot(float64(x)-cx, float64(y)-cy) < r {
+				c = color.NRGBA{240, 200, 20, 255}
+			}
+			if alpha {
+				c.A = uint8(128 + y*127/h)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+//withExif inserts an APP1 segment with an empty exif after the SOI marker
+func withExif(jpg []byte) []byte {
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(payload) + 2
+	app1 := append([]byte{0xff, 0xe1, byte(length >> 8), byte(length)}, payload...)
+	return append(append(append([]byte{}, jpg[:2]...), app1...), jpg[2:]...)
+}
+
+//psnr of the rgba channels in dB, +Inf for identical images
+func psnr(a, b image.Image) (float64, error) {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return 0, errors.New("the size differs from the golden image")
+	}
+	var sum float64
+	w, h := a.Bounds().Dx(), a.Bounds().Dy()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			ca, cb := nrgba(a, x, y), nrgba(b, x, y)
+			for i := range ca {
+				d := float64(ca[i]) - float64(cb[i])
+				sum += d * d
+			}
+		}
+	}
+	mse := sum / float64(w*h*4)
+	if mse == 0 {
+		return math.Inf(1), nil
+	}
+	return 10 * math.Log10(255*255/mse), nil
+}
+
+//ssim of the luma averaged over 8x8 windows, the images have the same size
+func ssim(a, b image.Image) float64 {
+	const c1, c2 = (0.01 * 255) * (0.01 * 255), (0.03 * 255) * (0.03 * 255)
+	w, h := a.Bounds().Dx(), a.Bounds().Dy()
+	var total float64
+	windows := 0
+	for y0 := 0; y0 < h; y0 += 8 {
+		for x0 := 0; x0 < w; x0 += 8 {
+			var sa, sb, saa, sbb, sab, n float64
+			for y := y0; y < y0+8 && y < h; y++ {
+				for x := x0; x < x0+8 && x < w; x++ {
+					la, lb := luma(nrgba(a, x, y)), luma(nrgba(b, x, y))
+					sa, sb = sa+la, sb+lb
+					saa, sbb, sab = saa+la*la, sbb+lb*lb, sab+la*lb
+					n++
+				}
+			}
+			ma, mb := sa/n, sb/n
+			va, vb, cov := saa/n-ma*ma, sbb/n-mb*mb, sab/n-ma*mb
+			total += (2*ma*mb + c1) * (2*cov + c2) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			windows++
+		}
+	}
+	if windows == 0 {
+		return 1
+	}
+	return total / float64(windows)
+}
+
+func nrgba(img image.Image, x, y int) [4]uint8 {
+	b := img.Bounds()
+	c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+	return [4]uint8{c.R, c.G, c.B, c.A}
+}
+
+func luma(c [4]uint8) float64 {
+	return 0.299*float64(c[0]) + 0.587*float64(c[1]) + 0.114*float64(c[2])
+}
+
+func TestPSNRAndSSIM(t *testing.T) {
+	a := pattern(64, 48, false)
+	if p, err := psnr(a, a); err != nil || !math.IsInf(p, 1) || ssim(a, a) < 0.9999 {
+		t.Error("identical images should have infinite psnr and ssim 1")
+	}
+	b := image.NewNRGBA(a.Bounds())
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			c := a.At(x, y).(color.NRGBA)
+			if (x+y)%2 == 0 && c.R < 250 {
+				c.R += 4
+			}
+			b.SetNRGBA(x, y, c)
+		}
+	}
+	if p, _ := psnr(a, b); p < 40 || p > 60 {
+		t.Error("slight noise should have a high but finite psnr")
+	}
+	if p, _ := psnr(a, pattern(64, 48, true)); p > 20 {
+		t.Error("a different alpha should have a low psnr")
+	}
+	if _, err := psnr(a, pattern(48, 64, false)); err == nil {
+		t.Error("different sizes should fail")
+	}
+}
@@ -3,23 +3,40 @@ package proc
 import (
 	"errors"
 	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"time"
 )
 
 type ImageProcessor interface {
 	Process(*img4g.Image) error
 }
 
+//Sizer is a processor which changes the dimensions of the image,
+//OutputSize computes them from those of the input without processing
+type Sizer interface {
+	OutputSize(width, height int64) (int64, int64)
+}
+
 type ProcessorChain struct {
 	Chain []ImageProcessor
 }
 
 func (p *ProcessorChain) Process(img *img4g.Image) error {
+	return p.ProcessWith(img, nil)
+}
+
+//ProcessWith processes img like Process, observe is called with the
+//duration of every processor if it isn't nil
+func (p *ProcessorChain) ProcessWith(img *img4g.Image, observe func(ImageProcessor, time.Duration)) error {
 	if len(p.Chain) == 0 {
 		return errors.New("procchain.unexpected.mark(len:0)")
 	}
 
 	for _, proc := range p.Chain {
+		start := time.Now()
 		err := proc.Process(img)
+		if observe != nil {
+			observe(proc, time.Since(start))
+		}
 		if err != nil {
 			return err
 		}
@@ -27,3 +44,14 @@ func (p *ProcessorChain) Process(img *img4g.Image) error {
 
 	return nil
 }
+
+//OutputSize is the size of the result of the chain for an image of width x
+//height, the processors which aren't a Sizer keep the size
+func (p *ProcessorChain) OutputSize(width, height int64) (int64, int64) {
+	for _, proc := range p.Chain {
+		if s, ok := proc.(Sizer); ok {
+			width, height = s.OutputSize(width, height)
+		}
+	}
+	return width, height
+}
@@ -0,0 +1,54 @@
+package proc
+
+import (
+	"testing"
+)
+
+func TestOutputSize(t *testing.T) {
+	cases := []struct {
+		name          string
+		p             Sizer
+		width, height int64
+		w, h          int64
+	}{
+		{"w landscape", &ResizeWProcessor{Width: 100, Height: 100}, 400, 300, 100, 75},
+		{"w portrait", &ResizeWProcessor{Width: 100, Height: 100}, 240, 320, 75, 100},
+		{"w floor", &ResizeWProcessor{Width: 100, Height: 100}, 300, 200, 100, 66},
+		//75 is within 3 pixels of 77, not of 78
+		{"w snap height", &ResizeWProcessor{Width: 100, Height: 77}, 400, 300, 100, 77},
+		{"w snap width", &ResizeWProcessor{Width: 77, Height: 100}, 240, 320, 77, 100},
+		{"w no snap", &ResizeWProcessor{Width: 100, Height: 78}, 400, 300, 100, 75},
+		{"w width only", &ResizeWProcessor{Width: 200}, 400, 300, 200, 150},
+		{"w height only", &ResizeWProcessor{Height: 150}, 400, 300, 200, 150},
+		{"w within", &ResizeWProcessor{Width: 100, Height: 100}, 80, 60, 80, 60},
+		{"w within width", &ResizeWProcessor{Width: 100}, 80, 60, 80, 60},
+		{"w within height", &ResizeWProcessor{Height: 100}, 80, 60, 80, 60},
+		{"z enlarge", &ResizeZProcessor{Width: 200, Height: 200}, 80, 60, 200, 150},
+		{"z snap", &ResizeZProcessor{Width: 200, Height: 152}, 80, 60, 200, 152},
+		{"z height only", &ResizeZProcessor{Height: 120}, 80, 60, 160, 120},
+		{"z width only", &ResizeZProcessor{Width: 100}, 400, 300, 100, 75},
+		//no ratio to keep
+		{"z empty", &ResizeZProcessor{Width: 100, Height: 50}, 0, 60, 100, 50},
+		{"c", &ResizeCProcessor{Width: 100, Height: 50}, 80, 60, 100, 50},
+		{"r larger", &ResizeRProcessor{Width: 100, Height: 50}, 400, 300, 100, 50},
+		{"r crop height", &ResizeRProcessor{Width: 100, Height: 50}, 80, 60, 80, 50},
+		{"r smaller", &ResizeRProcessor{Width: 100, Height: 50}, 80, 40, 80, 40},
+		{"rotate 90", &RotateProcessor{Degress: 90}, 80, 60, 60, 80},
+		{"rotate -180", &RotateProcessor{Degress: -180}, 80, 60, 80, 60},
+		{"rotate 45", &RotateProcessor{Degress: 45}, 100, 100, 142, 142},
+	}
+	for _, c := range cases {
+		if w, h := c.p.OutputSize(c.width, c.height); w != c.w || h != c.h {
+			t.Errorf("%s: %s, expected %s", c.name, size(int(w), int(h)), size(int(c.w), int(c.h)))
+		}
+	}
+
+	chain := &ProcessorChain{Chain: []ImageProcessor{
+		&ResizeWProcessor{Width: 100, Height: 100},
+		&RotateProcessor{Degress: 270},
+		&QualityProcessor{Quality: 80},
+	}}
+	if w, h := chain.OutputSize(400, 300); w != 75 || h != 100 {
+		t.Errorf("chain: %s, expected 75x100", size(int(w), int(h)))
+	}
+}
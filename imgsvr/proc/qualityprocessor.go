@@ -2,13 +2,13 @@ package proc
 
 import (
 	log "github.com/Sirupsen/logrus"
-	cat "github.com/ctripcorp/cat.go"
 	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/telemetry"
 )
 
 type QualityProcessor struct {
-	Quality int
-	Cat     cat.Cat
+	Quality   int
+	Telemetry telemetry.Telemetry
 }
 
 func (this *QualityProcessor) Process(img *img4g.Image) error {
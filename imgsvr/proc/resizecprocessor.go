@@ -2,21 +2,21 @@ package proc
 
 import (
 	log "github.com/Sirupsen/logrus"
-	cat "github.com/ctripcorp/cat.go"
 	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/telemetry"
 	"math"
 )
 
 type ResizeCProcessor struct {
-	Width  int64
-	Height int64
-	Cat    cat.Cat
+	Width     int64
+	Height    int64
+	Telemetry telemetry.Telemetry
 }
 
 func (this *ResizeCProcessor) Process(img *img4g.Image) error {
 	log.Debug("process resize c")
 	var err error
-	tran := this.Cat.NewTransaction("Command", "ResizeC")
+	tran := this.Telemetry.NewTransaction("Command", "ResizeC")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -55,3 +55,9 @@ func (this *ResizeCProcessor) Process(img *img4g.Image) error {
 	err = img.Resize(this.Width, this.Height)
 	return err
 }
+
+//OutputSize is always the size of the processor, the image is cropped to
+//its ratio
+func (this *ResizeCProcessor) OutputSize(width, height int64) (int64, int64) {
+	return this.Width, this.Height
+}
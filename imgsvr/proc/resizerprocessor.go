@@ -2,21 +2,21 @@ package proc
 
 import (
 	log "github.com/Sirupsen/logrus"
-	cat "github.com/ctripcorp/cat.go"
 	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/telemetry"
 	"math"
 )
 
 type ResizeRProcessor struct {
-	Width  int64
-	Height int64
-	Cat    cat.Cat
+	Width     int64
+	Height    int64
+	Telemetry telemetry.Telemetry
 }
 
 func (this *ResizeRProcessor) Process(img *img4g.Image) error {
 	log.Debug("process resize r")
 	var err error
-	tran := this.Cat.NewTransaction("Command", "ResizeR")
+	tran := this.Telemetry.NewTransaction("Command", "ResizeR")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -72,3 +72,18 @@ func (this *ResizeRProcessor) Process(img *img4g.Image) error {
 	}
 
 }
+
+//OutputSize is the size of the processor if the image is larger in both
+//dimensions, otherwise the larger dimension is cropped
+func (this *ResizeRProcessor) OutputSize(width, height int64) (int64, int64) {
+	if width > this.Width && height > this.Height {
+		return this.Width, this.Height
+	}
+	if width > this.Width {
+		width = this.Width
+	}
+	if height > this.Height {
+		height = this.Height
+	}
+	return width, height
+}
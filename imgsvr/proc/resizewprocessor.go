@@ -2,22 +2,21 @@ package proc
 
 import (
 	log "github.com/Sirupsen/logrus"
-	cat "github.com/ctripcorp/cat.go"
 	"github.com/ctripcorp/nephele/imgsvr/img4g"
-	"math"
+	"github.com/ctripcorp/nephele/telemetry"
 )
 
 type ResizeWProcessor struct {
-	Width  int64
-	Height int64
-	Cat    cat.Cat
+	Width     int64
+	Height    int64
+	Telemetry telemetry.Telemetry
 }
 
 //高固定，宽（原图比例计算），宽固定，高（原图比例计算） （压缩）
 func (this *ResizeWProcessor) Process(img *img4g.Image) error {
 	log.Debug("process resize w")
 	var err error
-	tran := this.Cat.NewTransaction("Command", "ResizeW")
+	tran := this.Telemetry.NewTransaction("Command", "ResizeW")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -28,36 +27,25 @@ func (this *ResizeWProcessor) Process(img *img4g.Image) error {
 		return err
 	}
 
-	if (width <= this.Width && height <= this.Height && this.Width != 0 && this.Height != 0) || (this.Width == 0 && height <= this.Height) || (this.Height == 0 && width <= this.Width) {
+	if withinSize(width, height, this.Width, this.Height) {
 		return nil
 	}
+	w, h := scaleSize(width, height, this.Width, this.Height)
+	err = img.Resize(w, h)
+	return err
+}
 
-	w, h := this.Width, this.Height
-	if w == 0 {
-		w = width * h / height
-		err = img.Resize(w, h)
-		return err
-	}
-	if h == 0 {
-		h = height * w / width
-		err = img.Resize(w, h)
-		return err
+//OutputSize is the size Process resizes to, images within the size are
+//kept
+func (this *ResizeWProcessor) OutputSize(width, height int64) (int64, int64) {
+	if withinSize(width, height, this.Width, this.Height) {
+		return width, height
 	}
+	return scaleSize(width, height, this.Width, this.Height)
+}
 
-	p1 := float64(this.Width) / float64(this.Height)
-	p2 := float64(width) / float64(height)
-
-	if p2 > p1 {
-		h = int64(math.Floor(float64(this.Width) / p2))
-		if int64(math.Abs(float64(h-this.Height))) < 3 {
-			h = this.Height
-		}
-	} else {
-		w = int64(math.Floor(float64(this.Height) * p2))
-		if int64(math.Abs(float64(w-this.Width))) < 3 {
-			w = this.Width
-		}
-	}
-	err = img.Resize(w, h)
-	return err
+//withinSize tells whether width x height fits into w x h, which resize w
+//keeps as is, a dimension of 0 isn't checked
+func withinSize(width, height, w, h int64) bool {
+	return (width <= w && height <= h && w != 0 && h != 0) || (w == 0 && height <= h) || (h == 0 && width <= w)
 }
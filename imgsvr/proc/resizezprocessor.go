@@ -2,22 +2,22 @@ package proc
 
 import (
 	log "github.com/Sirupsen/logrus"
-	cat "github.com/ctripcorp/cat.go"
 	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/telemetry"
 	"math"
 )
 
 type ResizeZProcessor struct {
-	Width  int64
-	Height int64
-	Cat    cat.Cat
+	Width     int64
+	Height    int64
+	Telemetry telemetry.Telemetry
 }
 
 //高固定，宽（原图比例计算），宽固定，高（原图比例计算） （压缩）
 func (this *ResizeZProcessor) Process(img *img4g.Image) error {
 	log.Debug("process resize z")
 	var err error
-	tran := this.Cat.NewTransaction("Command", "ResizeW")
+	tran := this.Telemetry.NewTransaction("Command", "ResizeW")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -29,32 +29,41 @@ func (this *ResizeZProcessor) Process(img *img4g.Image) error {
 		return err1
 	}
 
-	w, h := this.Width, this.Height
+	w, h := scaleSize(width, height, this.Width, this.Height)
+	err = img.Resize(w, h)
+	return err
+}
+
+//OutputSize is the size Process resizes to
+func (this *ResizeZProcessor) OutputSize(width, height int64) (int64, int64) {
+	return scaleSize(width, height, this.Width, this.Height)
+}
+
+//scaleSize fits width x height into w x h keeping the ratio like resize w
+//and z do, a dimension of 0 follows the other one and a computed dimension
+//within 3 pixels of the requested one is snapped to it
+func scaleSize(width, height, w, h int64) (int64, int64) {
+	if width == 0 || height == 0 {
+		return w, h
+	}
 	if w == 0 {
-		w = width * h / height
-		err = img.Resize(w, h)
-		return err
+		return width * h / height, h
 	}
 	if h == 0 {
-		h = height * w / width
-		err = img.Resize(w, h)
-		return err
+		return w, height * w / width
 	}
-
-	p1 := float64(this.Width) / float64(this.Height)
+	p1 := float64(w) / float64(h)
 	p2 := float64(width) / float64(height)
-
 	if p2 > p1 {
-		h = int64(math.Floor(float64(this.Width) / p2))
-		if int64(math.Abs(float64(h-this.Height))) < 3 {
-			h = this.Height
-		}
-	} else {
-		w = int64(math.Floor(float64(this.Height) * p2))
-		if int64(math.Abs(float64(w-this.Width))) < 3 {
-			w = this.Width
+		sh := int64(math.Floor(float64(w) / p2))
+		if int64(math.Abs(float64(sh-h))) < 3 {
+			sh = h
 		}
+		return w, sh
 	}
-	err = img.Resize(w, h)
-	return err
+	sw := int64(math.Floor(float64(h) * p2))
+	if int64(math.Abs(float64(sw-w))) < 3 {
+		sw = w
+	}
+	return sw, h
 }
@@ -2,19 +2,20 @@ package proc
 
 import (
 	log "github.com/Sirupsen/logrus"
-	cat "github.com/ctripcorp/cat.go"
 	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/telemetry"
+	"math"
 )
 
 type RotateProcessor struct {
-	Degress float64
-	Cat     cat.Cat
+	Degress   float64
+	Telemetry telemetry.Telemetry
 }
 
 func (this *RotateProcessor) Process(img *img4g.Image) error {
 	log.Debug("process rotate ")
 	var err error
-	tran := this.Cat.NewTransaction("Command", "Rotate")
+	tran := this.Telemetry.NewTransaction("Command", "Rotate")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
@@ -22,3 +23,20 @@ func (this *RotateProcessor) Process(img *img4g.Image) error {
 	err = img.Rotate(this.Degress)
 	return err
 }
+
+//OutputSize swaps the dimensions for 90 and 270 degrees, other angles
+//than multiples of 90 give the bounding box of the rotated image
+func (this *RotateProcessor) OutputSize(width, height int64) (int64, int64) {
+	degrees := math.Mod(this.Degress, 360)
+	if degrees < 0 {
+		degrees += 360
+	}
+	switch degrees {
+	case 0, 180:
+		return width, height
+	case 90, 270:
+		return height, width
+	}
+	sin, cos := math.Abs(math.Sin(degrees*math.Pi/180)), math.Abs(math.Cos(degrees*math.Pi/180))
+	return int64(math.Ceil(float64(width)*cos + float64(height)*sin)), int64(math.Ceil(float64(width)*sin + float64(height)*cos))
+}
@@ -2,20 +2,20 @@ package proc
 
 import (
 	log "github.com/Sirupsen/logrus"
-	cat "github.com/ctripcorp/cat.go"
 	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/telemetry"
 )
 
 type ScaleProcessor struct {
-	Width  int64
-	Height int64
-	Cat    cat.Cat
+	Width     int64
+	Height    int64
+	Telemetry telemetry.Telemetry
 }
 
 func (p *ScaleProcessor) Process(img *img4g.Image) error {
 	log.Debug("process scale")
 	var err error
-	tran := cat.Instance().NewTransaction("Command", "Scale")
+	tran := telemetry.Instance().NewTransaction("Command", "Scale")
 	defer func() {
 		tran.SetStatus(err)
 		tran.Complete()
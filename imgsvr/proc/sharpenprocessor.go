@@ -0,0 +1,25 @@
+package proc
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/telemetry"
+)
+
+type SharpenProcessor struct {
+	Radius    float64
+	Sigma     float64
+	Telemetry telemetry.Telemetry
+}
+
+func (this *SharpenProcessor) Process(img *img4g.Image) error {
+	log.Debug("process sharpen")
+	var err error
+	tran := this.Telemetry.NewTransaction("Command", "Sharpen")
+	defer func() {
+		tran.SetStatus(err)
+		tran.Complete()
+	}()
+	err = img.Sharpen(this.Radius, this.Sigma)
+	return err
+}
@@ -2,12 +2,12 @@ package proc
 
 import (
 	log "github.com/Sirupsen/logrus"
-	cat "github.com/ctripcorp/cat.go"
 	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/telemetry"
 )
 
 type StripProcessor struct {
-	Cat cat.Cat
+	Telemetry telemetry.Telemetry
 }
 
 func (this *StripProcessor) Process(img *img4g.Image) error {
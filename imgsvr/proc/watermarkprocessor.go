@@ -3,22 +3,23 @@ package proc
 import (
 	"errors"
 	log "github.com/Sirupsen/logrus"
-	cat "github.com/ctripcorp/cat.go"
 	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/telemetry"
+	"strconv"
 )
 
 type WaterMarkProcessor struct {
 	Logo          *img4g.Image
 	Location      int
 	Dissolve      int
-	Cat           cat.Cat
+	Telemetry     telemetry.Telemetry
 	WaterMarkType string
 }
 
 func (this *WaterMarkProcessor) Process(img *img4g.Image) error {
 	log.Debug("process watermark")
 	var err error = nil
-	tran := this.Cat.NewTransaction("Command", this.WaterMarkType)
+	tran := this.Telemetry.NewTransaction("Command", this.WaterMarkType)
 
 	defer func() {
 		this.Logo.DestoryWand()
@@ -30,7 +31,7 @@ func (this *WaterMarkProcessor) Process(img *img4g.Image) error {
 		this.Location = 9
 	}
 	if this.Location < 1 || this.Location > 9 {
-		err = errors.New("Logo location(" + string(this.Location) + ") isn't right!")
+		err = errors.New("Logo location(" + strconv.Itoa(this.Location) + ") isn't right!")
 		return err
 	}
 	var x, y int64
@@ -3,28 +3,20 @@ package imgsvr
 import (
 	"errors"
 	log "github.com/Sirupsen/logrus"
-	cat "github.com/ctripcorp/cat.go"
 	"github.com/ctripcorp/nephele/imgsvr/data"
 	"github.com/ctripcorp/nephele/imgsvr/img4g"
 	"github.com/ctripcorp/nephele/imgsvr/proc"
+	"github.com/ctripcorp/nephele/telemetry"
 	"strconv"
 	"strings"
 )
 
 type ProcChainBuilder struct {
-	Cat cat.Cat
+	Telemetry telemetry.Telemetry
+	//explain records how the url is resolved, logos aren't fetched if set
+	explain *chainExplain
 }
 
-var (
-	CmdStrip            = "s"
-	CmdFormat           = "f"
-	CmdResize           = "resize"
-	CmdQuality          = "q"
-	CmdWaterMark        = "m"
-	CmdRotate           = "rotate"
-	CmdDigitalWatermark = "d"
-)
-
 type buildError struct {
 	error
 	errType string
@@ -34,107 +26,42 @@ func (e *buildError) Type() string {
 	return e.errType
 }
 
+//DigimarkProcChain builds the chain of digital watermark urls, which
+//doesn't follow the sequence of the channel
 func (this *ProcChainBuilder) DigimarkProcChain(params map[string]string) (*proc.ProcessorChain, *buildError) {
-	procChain := &proc.ProcessorChain{Chain: make([]proc.ImageProcessor, 0, 10)}
-	_, channel, _ := ParseUri(params[":1"])
-	stripProcessor, e := this.getStripProcessor(channel, params)
-	if e != nil {
-		return nil, &buildError{e, "UrlStripCmdError"}
-	}
-	procChain.Chain = append(procChain.Chain, stripProcessor)
-	log.Debug("add strip processor")
-	dwmProcessor, e := this.getDigitalWatermarkProcessor(channel, params)
-	if e != nil {
-		return nil, &buildError{e, "UrlDigitalWatermarkCmdError"}
-	}
-	if dwmProcessor != nil {
-		procChain.Chain = append(procChain.Chain, dwmProcessor)
-		log.Debug("add digital watermark processor")
-	}
-	formatProcessor, e := this.getFormatProcessor(channel, params)
-	if e != nil {
-		return nil, &buildError{e, "UrlFormatCmdError"}
-	}
-	if formatProcessor != nil {
-		procChain.Chain = append(procChain.Chain, formatProcessor)
-		log.Debug("add format processor")
-	}
-
-	return procChain, nil
+	return this.build(params, digimarkSequence)
 }
 
 func (this *ProcChainBuilder) Build(params map[string]string) (*proc.ProcessorChain, *buildError) {
+	return this.build(params, nil)
+}
+
+//build appends the processors of each step of sequence, the sequence of
+//the channel is used if sequence is nil
+func (this *ProcChainBuilder) build(params map[string]string, sequence []data.Step) (*proc.ProcessorChain, *buildError) {
 	procChain := &proc.ProcessorChain{Chain: make([]proc.ImageProcessor, 0, 10)}
 
 	sourceType, channel, path := ParseUri(params[":1"])
-	//_, channel, _ := ParseUri(params[":1"])
-	sequences, e := data.GetSequenceofoperation(channel)
-	if e != nil {
-		return nil, &buildError{e, "UrlSequenceCmdError"}
-	}
-	for _, t := range sequences {
-		switch t {
-		case CmdStrip:
-			stripProcessor, e := this.getStripProcessor(channel, params)
-			if e != nil {
-				return nil, &buildError{e, "UrlStripCmdError"}
-			}
-			procChain.Chain = append(procChain.Chain, stripProcessor)
-			log.Debug("add strip processor")
-		case CmdResize:
-			resizeProcessor, e := this.getResizeProcessor(channel, params)
-			if e != nil {
-				return nil, &buildError{e, "UrlResizeCmdError"}
-			}
-			procChain.Chain = append(procChain.Chain, resizeProcessor)
-			log.Debug("add resize processor")
-		case CmdQuality:
-			qualityProcessor, e := this.getQualityProcessor(channel, params)
-			if e != nil {
-				return nil, &buildError{e, "UrlQualityCmdError"}
-			}
-			if qualityProcessor != nil {
-				procChain.Chain = append(procChain.Chain, qualityProcessor)
-				log.Debug("add quality processor")
-			}
-		case CmdRotate:
-			rotateProcessor, e := this.getRotateProcessor(channel, params)
-			if e != nil {
-				return nil, &buildError{e, "UrlRotateCmdError"}
-			}
-			if rotateProcessor != nil {
-				procChain.Chain = append(procChain.Chain, rotateProcessor)
-				log.Debug("add rotate processor")
-			}
-		case CmdWaterMark:
-			waterMarkProcessors, e := this.getWaterMarkProcessors(sourceType, channel, path, params)
-			if e != nil {
-				return nil, &buildError{e, "UrlWaterMarkCmdError"}
-			}
-			if waterMarkProcessors != nil {
-				for _, p := range waterMarkProcessors {
-					if p != nil {
-						procChain.Chain = append(procChain.Chain, p)
-					}
-				}
-			}
-		case CmdFormat:
-			formatProcessor, e := this.getFormatProcessor(channel, params)
-			if e != nil {
-				return nil, &buildError{e, "UrlFormatCmdError"}
-			}
-			if formatProcessor != nil {
-				procChain.Chain = append(procChain.Chain, formatProcessor)
-				log.Debug("add format processor")
-			}
-		case CmdDigitalWatermark:
-			dwmProcessor, e := this.getDigitalWatermarkProcessor(channel, params)
-			if e != nil {
-				return nil, &buildError{e, "UrlDigitalWatermarkCmdError"}
-			}
-			if dwmProcessor != nil {
-				procChain.Chain = append(procChain.Chain, dwmProcessor)
-				log.Debug("add digital watermark processor")
+	config := data.Current()
+	req := &buildRequest{sourceType, channel, path, config.Channel(channel), params, config}
+	if sequence == nil {
+		sequence = req.policy.Sequence
+	}
+	for _, step := range sequence {
+		op, ok := operations[step.Name]
+		if !ok {
+			log.Debug("skip unknown operation " + step.Name)
+			continue
+		}
+		processors, e := op.build(this, req, step.Args)
+		if e != nil {
+			return nil, &buildError{e, op.errType}
+		}
+		for _, p := range processors {
+			if p != nil {
+				procChain.Chain = append(procChain.Chain, p)
+				this.explain.addProcessor(step.Name, p)
+				log.Debug("add " + step.Name + " processor")
 			}
 		}
 	}
@@ -143,14 +70,15 @@ func (this *ProcChainBuilder) Build(params map[string]string) (*proc.ProcessorCh
 
 func (this *ProcChainBuilder) getFormatProcessor(channel string, params map[string]string) (proc.ImageProcessor, error) {
 	ext, _ := params["ext"]
-	return &proc.FormatProcessor{ext, this.Cat}, nil
+	this.explain.accept("ext", ext, "url pattern")
+	return &proc.FormatProcessor{ext, this.Telemetry}, nil
 }
 
 func (this *ProcChainBuilder) getStripProcessor(channel string, params map[string]string) (proc.ImageProcessor, error) {
-	return &proc.StripProcessor{this.Cat}, nil
+	return &proc.StripProcessor{this.Telemetry}, nil
 }
 
-func (this *ProcChainBuilder) getResizeProcessor(channel string, params map[string]string) (proc.ImageProcessor, error) {
+func (this *ProcChainBuilder) getResizeProcessor(channel string, policy *data.ChannelPolicy, params map[string]string) (proc.ImageProcessor, error) {
 	cmdVal, ok := params[":2"]
 	if !ok {
 		return nil, errors.New("proc.command.notfound.mark()")
@@ -164,48 +92,36 @@ func (this *ProcChainBuilder) getResizeProcessor(channel string, params map[stri
 		return nil, errors.New("image.height.notfound.mark()")
 	}
 	cmd := strings.ToLower(cmdVal)
-	width, height, err := this.getValidSizeParam(widthVal, heightVal, cmd, channel)
+	width, height, err := this.getValidSizeParam(widthVal, heightVal, cmd, channel, policy)
 	if err != nil {
 		return nil, err
 	}
 
-	//feature
-	var (
-		process proc.ImageProcessor = nil
-		isnext  bool                = true
-	)
-	switch {
-	case channel == TG:
-		ft := tgresizefeature{width, height, cmd, this.Cat}
-		process, isnext, err = ft.Process()
-	case channel == Hotel || channel == Globalhotel:
-		ft := hotelresizefeature{width, height, cmd, this.Cat}
-		process, isnext, err = ft.Process()
-	}
+	//channel specific resize rules, see resizerules in conf
+	size := JoinString(widthVal, "x", heightVal)
+	cmd, width, height, rule, err := policy.ResizeRules.Apply(cmd, width, height)
 	if err != nil {
-		return nil, err
+		this.explain.reject("size", size, "resizerules "+rule, err.Error())
+		return nil, errors.New(JoinString("channel: ", channel, ", reason: ", err.Error()))
 	}
-	if process != nil {
-		return process, nil
-	}
-	if !isnext {
-		return nil, nil
+	if rule != "" {
+		this.explain.accept("size", size, JoinString("resizerules ", rule, " => ", cmd, " ", strconv.FormatInt(width, 10), "x", strconv.FormatInt(height, 10)))
 	}
 
 	switch cmd {
 	case "r":
-		return &proc.ResizeRProcessor{width, height, this.Cat}, nil
+		return &proc.ResizeRProcessor{width, height, this.Telemetry}, nil
 	case "c":
-		return &proc.ResizeCProcessor{Width: width, Height: height, Cat: this.Cat}, nil
+		return &proc.ResizeCProcessor{Width: width, Height: height, Telemetry: this.Telemetry}, nil
 	case "w":
-		return &proc.ResizeWProcessor{width, height, this.Cat}, nil
+		return &proc.ResizeWProcessor{width, height, this.Telemetry}, nil
 	case "z":
-		return &proc.ResizeZProcessor{Width: width, Height: height, Cat: this.Cat}, nil
+		return &proc.ResizeZProcessor{Width: width, Height: height, Telemetry: this.Telemetry}, nil
 	}
 	return nil, nil
 }
 
-func (this *ProcChainBuilder) getValidSizeParam(widthVal, heightVal, cmdVal, channel string) (int64, int64, error) {
+func (this *ProcChainBuilder) getValidSizeParam(widthVal, heightVal, cmdVal, channel string, policy *data.ChannelPolicy) (int64, int64, error) {
 	width, err := strconv.ParseInt(widthVal, 10, 64)
 	if err != nil {
 		return 0, 0, err
@@ -216,108 +132,89 @@ func (this *ProcChainBuilder) getValidSizeParam(widthVal, heightVal, cmdVal, cha
 	}
 
 	//check type
-	resizetypes, err := data.GetResizeTypes(channel)
-	if err != nil {
-		return 0, 0, err
-	}
-
-	if !strings.Contains(resizetypes, cmdVal) {
+	if !policy.ResizeTypes[cmdVal] {
+		this.explain.reject("type", cmdVal, "resizetypes", "not in resizetypes")
 		return 0, 0, errors.New(JoinString("channel: ", channel, ", reason: not support type ", cmdVal))
 	}
+	this.explain.accept("type", cmdVal, "resizetypes")
 
 	//check size
-	sizes, err := data.GetSizes(channel)
-	if err != nil {
-		return 0, 0, err
-	}
-	var wh = JoinString(",", widthVal, "x", heightVal, ",")
-	if !strings.Contains(sizes, wh) {
-		return 0, 0, errors.New(JoinString("channel: ", channel, ", reason: not support size ", wh))
+	token, ok := policy.Sizes.Match(width, height)
+	if !ok {
+		this.explain.reject("size", JoinString(widthVal, "x", heightVal), "sizes", "no size matched")
+		return 0, 0, errors.New(JoinString("channel: ", channel, ", reason: not support size ", widthVal, "x", heightVal))
 	}
+	this.explain.accept("size", JoinString(widthVal, "x", heightVal), "sizes "+token)
 	return width, height, nil
 }
 
-func (this *ProcChainBuilder) getRotateProcessor(channel string, params map[string]string) (proc.ImageProcessor, error) {
+//getRotateProcessor takes a rotate which isn't in rotates as the dissolve of
+//the watermark if it is in the dissolves of hotel
+func (this *ProcChainBuilder) getRotateProcessor(channel string, policy, hotel *data.ChannelPolicy, params map[string]string) (proc.ImageProcessor, error) {
 	rotate, ok := params[":6"]
 	if !ok {
 		return nil, nil
 	}
-
-	rotateStr, err := data.GetRotates(channel)
+	degress, err := strconv.Atoi(rotate)
 	if err != nil {
 		return nil, err
 	}
 
-	var checkstr = JoinString(",", rotate, ",")
-	if !strings.Contains(rotateStr, checkstr) {
-		opacitiesStr, err := data.GetDissolves(Hotel)
-		if err != nil {
-			return nil, err
-		}
-		if strings.Contains(opacitiesStr, checkstr) {
+	if !policy.Rotates[degress] {
+		//the rotate param is taken as watermark dissolve
+		if hotel.Dissolves[degress] {
+			this.explain.accept("rotate", rotate, "dissolves of hotel, taken as watermark dissolve")
 			return nil, nil
 		} else {
+			this.explain.reject("rotate", rotate, "rotates", "not in rotates")
 			return nil, errors.New(JoinString("channel: ", channel, ", reason: not support rotate degree ", rotate))
 		}
 	}
-	degress, err := strconv.ParseFloat(rotate, 64)
-	if err != nil {
-		return nil, err
-	}
-	return &proc.RotateProcessor{degress, this.Cat}, nil
+	this.explain.accept("rotate", rotate, "rotates")
+	return &proc.RotateProcessor{float64(degress), this.Telemetry}, nil
 }
 
-func (this *ProcChainBuilder) getQualityProcessor(channel string, params map[string]string) (proc.ImageProcessor, error) {
-	var (
-		quality int
-		err     error
-	)
+func (this *ProcChainBuilder) getQualityProcessor(channel string, policy *data.ChannelPolicy, params map[string]string) (proc.ImageProcessor, error) {
+	quality := policy.Quality
 	qualityStr, _ := params["n0"]
 	if qualityStr != "" {
-		qualitiesStr, err := data.GetQualities(channel)
+		q, err := strconv.Atoi(qualityStr)
 		if err != nil {
 			return nil, err
 		}
-		if !strings.Contains(qualitiesStr, JoinString(",", qualityStr, ",")) {
+		if !policy.Qualities[q] {
+			this.explain.reject("quality", qualityStr, "qualities", "not in qualities")
 			return nil, errors.New(JoinString("channel: ", channel, ", reason: not support quality ", qualityStr))
 		}
-	} else {
-		qualityStr, err = data.GetQuality(channel)
-		if err != nil {
-			return nil, err
-		}
+		this.explain.accept("quality", qualityStr, "qualities")
+		quality = q
 	}
-
-	quality, err = strconv.Atoi(qualityStr)
-	if err != nil {
-		return nil, err
+	if quality == 0 {
+		return nil, errors.New(JoinString("channel: ", channel, ", reason: quality isn't configured"))
 	}
 
-	return &proc.QualityProcessor{quality, this.Cat}, nil
+	return &proc.QualityProcessor{quality, this.Telemetry}, nil
 }
 
-func (this *ProcChainBuilder) getDigitalWatermarkProcessor(channel string, params map[string]string) (proc.ImageProcessor, error) {
+func (this *ProcChainBuilder) getDigitalWatermarkProcessor(policy *data.ChannelPolicy, params map[string]string) (proc.ImageProcessor, error) {
 	dwm, _ := params["dwm"]
 	if dwm == "" {
 		return nil, nil
 	}
-	copyrightdir, err := data.GetLogodir(channel)
+	this.explain.accept("dwm", dwm, "url pattern")
+	bts, err := this.getLogo("NFS", policy.LogoDir+"copy.jpg")
 	if err != nil {
 		return nil, err
 	}
-	bts, err := GetImage("NFS", copyrightdir+"copy.jpg", this.Cat)
-	if err != nil {
-		return nil, err
-	}
-	copyright := &img4g.Image{Format: "jpg", Blob: bts, Cat: this.Cat}
+	copyright := &img4g.Image{Format: "jpg", Blob: bts, Telemetry: this.Telemetry}
 
-	return &proc.DigitalWatermarkProcessor{copyright, this.Cat}, nil
+	return &proc.DigitalWatermarkProcessor{copyright, this.Telemetry}, nil
 }
 
-func (this *ProcChainBuilder) getWaterMarkProcessors(sourceType string, channel string, path string, params map[string]string) ([]proc.ImageProcessor, error) {
+func (this *ProcChainBuilder) getWaterMarkProcessors(sourceType string, channel string, path string, policy *data.ChannelPolicy, params map[string]string) ([]proc.ImageProcessor, error) {
 	processors := make([]proc.ImageProcessor, 2)
 	//processors
-	logoprocessor, err := this.getLogoWaterMarkProcessor(channel, params)
+	logoprocessor, err := this.getLogoWaterMarkProcessor(channel, policy, params)
 	if err != nil {
 		return nil, err
 	}
@@ -325,7 +222,7 @@ func (this *ProcChainBuilder) getWaterMarkProcessors(sourceType string, channel
 		processors = append(processors, logoprocessor)
 		log.Debug("add logo watermark processor")
 	}
-	nameprocessor, err := this.getNameWaterMarkProcessor(sourceType, channel, path, params)
+	nameprocessor, err := this.getNameWaterMarkProcessor(sourceType, path, policy, params)
 	if err != nil {
 		return nil, err
 	}
@@ -337,97 +234,84 @@ func (this *ProcChainBuilder) getWaterMarkProcessors(sourceType string, channel
 	return processors, nil
 }
 
-func (this *ProcChainBuilder) getLogoWaterMarkProcessor(channel string, params map[string]string) (proc.ImageProcessor, error) {
-	dissolve := this.getLogoDissolve(channel, params)
-	logodir, err := data.GetLogodir(channel)
-	if err != nil {
-		return nil, err
-	}
+func (this *ProcChainBuilder) getLogoWaterMarkProcessor(channel string, policy *data.ChannelPolicy, params map[string]string) (proc.ImageProcessor, error) {
+	dissolve := this.getLogoDissolve(policy, params)
 	wn, _ := params["wn"]
 	wl, _ := params["wl"]
+	l, err := strconv.Atoi(wl)
+	if err != nil {
+		l = 9
+	}
+	param := "watermark"
 	if wn == "" {
-		defaultlogo, err := data.GetDefaultLogo(channel)
-		if err != nil {
-			return nil, err
-		}
-		arr := strings.Split(defaultlogo, ",")
-		if len(arr) == 2 {
-			wn = arr[0]
-			wl = arr[1]
-		}
+		wn = policy.DefaultLogo
+		l = policy.DefaultLogoLocation
+		param = "defaultlogo"
 	}
 	if wn == "" {
 		return nil, nil
 	}
 	//check watermarkname
-	logonames, err := data.GetLogoNames(channel)
-	if err != nil {
-		return nil, err
-	}
-	if !strings.Contains(logonames, JoinString(",", wn, ",")) {
+	if !policy.LogoNames[wn] {
+		this.explain.reject(param, wn, "logonames", "not in logonames")
 		return nil, errors.New(JoinString("channel: ", channel, ", reason: not support watermark ", wn))
 	}
+	this.explain.accept(param, wn, "logonames")
 	//check size
-	lesswidth, err := data.GetImagelesswidthForLogo(channel)
-	if err != nil {
-		return nil, err
-	}
-	lessheight, err := data.GetImagelessheightForLogo(channel)
-	if err != nil {
-		return nil, err
-	}
+	lesswidth, lessheight := policy.ImagelessWidthForLogo, policy.ImagelessHeightForLogo
 	if lesswidth > 0 || lessheight > 0 {
 		widthVal, _ := params[":3"]
 		heightVal, _ := params[":4"]
 		w, _ := strconv.ParseInt(widthVal, 10, 64)
 		h, _ := strconv.ParseInt(heightVal, 10, 64)
 		if !(w >= lesswidth && h >= lessheight) {
+			this.explain.reject(param, wn, "imagelesswidthforlogo,imagelessheightforlogo", "image is too small, logo is skipped")
 			return nil, nil
 		}
 	}
-	l, err := strconv.Atoi(wl)
-	if err != nil {
+	if l == 0 {
 		l = 9
 	}
-	var path = logodir + wn + ".png"
-	bts, err := GetImage("NFS", path, this.Cat)
+	var path = policy.LogoDir + wn + ".png"
+	bts, err := this.getLogo("NFS", path)
 	if err != nil {
 		return nil, err
 	}
-	logo := &img4g.Image{Format: "png", Blob: bts, Cat: this.Cat}
-	return &proc.WaterMarkProcessor{Logo: logo, Location: l, Dissolve: dissolve, Cat: this.Cat, WaterMarkType: "WaterMark"}, nil
+	logo := &img4g.Image{Format: "png", Blob: bts, Telemetry: this.Telemetry}
+	return &proc.WaterMarkProcessor{Logo: logo, Location: l, Dissolve: dissolve, Telemetry: this.Telemetry, WaterMarkType: "WaterMark"}, nil
 }
 
-func (this *ProcChainBuilder) getLogoDissolve(channel string, params map[string]string) int {
+func (this *ProcChainBuilder) getLogoDissolve(policy *data.ChannelPolicy, params map[string]string) int {
 	rotate, ok := params[":6"]
 	if ok {
-		dissolves, _ := data.GetDissolves(channel)
-		if !strings.Contains(dissolves, JoinString(",", rotate, ",")) {
-			return data.GetDissolve(channel)
-		}
 		dissolve, _ := strconv.Atoi(rotate)
+		if !policy.Dissolves[dissolve] {
+			return policy.Dissolve
+		}
+		this.explain.accept("dissolve", rotate, "dissolves")
 		return dissolve
 	} else {
-		return data.GetDissolve(channel)
+		return policy.Dissolve
 	}
 }
 
-func (this *ProcChainBuilder) getNameWaterMarkProcessor(sourceType string, channel string, path string, params map[string]string) (proc.ImageProcessor, error) {
-	isMark, err := data.IsEnableNameLogo(channel)
-	if err != nil {
-		return nil, err
-	}
-	if isMark == false {
+func (this *ProcChainBuilder) getNameWaterMarkProcessor(sourceType string, path string, policy *data.ChannelPolicy, params map[string]string) (proc.ImageProcessor, error) {
+	if policy.IsEnableNameLogo == false {
 		return nil, nil
 	}
 	widthVal, _ := params[":3"]
 	width, _ := strconv.ParseInt(widthVal, 10, 64)
 	var logoname = this.getnamelogo(width)
-	imagebts, err := GetImage(sourceType, path+logoname, this.Cat)
+	if this.explain != nil {
+		//the location depends on the width of the name logo
+		this.explain.skip(path + logoname)
+		return &proc.WaterMarkProcessor{Location: 9, Dissolve: policy.NameLogoDissolve, WaterMarkType: "NameWaterMark"}, nil
+	}
+	imagebts, err := GetImage(sourceType, path+logoname, this.Telemetry)
 	if err != nil {
 		return nil, nil
 	}
-	logo := &img4g.Image{Format: "png", Blob: imagebts, Cat: this.Cat}
+	logo := &img4g.Image{Format: "png", Blob: imagebts, Telemetry: this.Telemetry}
 	defer func() {
 		logo.DestoryWand()
 	}()
@@ -442,12 +326,16 @@ func (this *ProcChainBuilder) getNameWaterMarkProcessor(sourceType string, chann
 	if logowidth > width {
 		l = 7
 	}
-	dissolve := this.getNameLogoDissolve(channel)
-	return &proc.WaterMarkProcessor{Logo: logo, Location: l, Dissolve: dissolve, Cat: this.Cat, WaterMarkType: "NameWaterMark"}, nil
+	return &proc.WaterMarkProcessor{Logo: logo, Location: l, Dissolve: policy.NameLogoDissolve, Telemetry: this.Telemetry, WaterMarkType: "NameWaterMark"}, nil
 }
 
-func (this *ProcChainBuilder) getNameLogoDissolve(channel string) int {
-	return data.GetNamelogoDissolve(channel)
+//getLogo fetches a logo, it isn't fetched when explaining a url
+func (this *ProcChainBuilder) getLogo(storageType, path string) ([]byte, error) {
+	if this.explain != nil {
+		this.explain.skip(path)
+		return nil, nil
+	}
+	return GetImage(storageType, path, this.Telemetry)
 }
 
 func (this *ProcChainBuilder) getnamelogo(width int64) string {
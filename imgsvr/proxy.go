@@ -0,0 +1,195 @@
+package imgsvr
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var errNoWorker = errors.New("no worker in rotation")
+
+var (
+	//a worker which doesn't accept a connection in time is out of rotation
+	proxyDialTimeout = 2 * time.Second
+	//longer than the processing timeouts of the workers (15s of a sprite),
+	//a hung worker doesn't hold proxied requests beyond it
+	proxyResponseTimeout = 30 * time.Second
+)
+
+//workerProxy balances requests across the workers in rotation by least
+//outstanding requests. A worker failing a connection is taken out of
+//rotation until the monitor finds it ready again.
+type workerProxy struct {
+	workers []*proxyWorker
+	//the next worker to try first among those with least requests
+	next      int
+	mutex     sync.Mutex
+	transport *http.Transport
+	handler   *httputil.ReverseProxy
+}
+
+type proxyWorker struct {
+	port        string
+	outstanding int
+	inRotation  bool
+}
+
+//newWorkerProxy creates the proxy with every worker in rotation
+func newWorkerProxy(ports []string) *workerProxy {
+	p := &workerProxy{
+		//keep-alive pool of each worker
+		transport: &http.Transport{
+			DialContext:           (&net.Dialer{Timeout: proxyDialTimeout, KeepAlive: 30 * time.Second}).DialContext,
+			ResponseHeaderTimeout: proxyResponseTimeout,
+			MaxIdleConnsPerHost:   256,
+			DisableCompression:    true,
+		},
+	}
+	for _, port := range ports {
+		p.workers = append(p.workers, &proxyWorker{port: port, inRotation: true})
+	}
+	p.handler = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			//the host is set by RoundTrip when a worker is picked
+			req.URL.Host = "worker"
+		},
+		Transport: p,
+	}
+	return p
+}
+
+func (this *workerProxy) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	if this.inRotation() == 0 {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	this.handler.ServeHTTP(w, request)
+}
+
+//RoundTrip sends the request to the worker with least outstanding requests.
+//A worker which can't be connected is out of rotation and GET and HEAD are
+//retried on other workers, other errors (e.g. the client is gone) are
+//returned as they are.
+func (this *workerProxy) RoundTrip(req *http.Request) (*http.Response, error) {
+	tried := make(map[*proxyWorker]bool)
+	for {
+		w := this.pick(tried)
+		if w == nil {
+			return nil, errNoWorker
+		}
+		req.URL.Host = JoinString("127.0.0.1:", w.port)
+		rsp, err := this.transport.RoundTrip(req)
+		if err == nil {
+			rsp.Body = &outstandingBody{rsp.Body, this, w, sync.Once{}}
+			return rsp, nil
+		}
+		this.done(w)
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		LogErrorEvent(TelemetryInstance, "Proxy.WorkerError", err.Error())
+		if !isDialError(err) {
+			return nil, err
+		}
+		this.setRotation(w, false)
+		if req.Method != "GET" && req.Method != "HEAD" {
+			return nil, err
+		}
+		tried[w] = true
+	}
+}
+
+//isDialError reports whether err is of connecting to a worker rather than
+//of a request sent to it
+func isDialError(err error) bool {
+	for {
+		switch e := err.(type) {
+		case *net.OpError:
+			if e.Op == "dial" {
+				return true
+			}
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		case syscall.Errno:
+			return e == syscall.ECONNREFUSED
+		default:
+			return false
+		}
+	}
+}
+
+func (this *workerProxy) pick(tried map[*proxyWorker]bool) *proxyWorker {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	var best *proxyWorker
+	n := len(this.workers)
+	for i := 0; i < n; i++ {
+		w := this.workers[(this.next+i)%n]
+		if !w.inRotation || tried[w] {
+			continue
+		}
+		if best == nil || w.outstanding < best.outstanding {
+			best = w
+		}
+	}
+	if best != nil {
+		best.outstanding++
+		this.next = (this.next + 1) % n
+	}
+	return best
+}
+
+func (this *workerProxy) done(w *proxyWorker) {
+	this.mutex.Lock()
+	w.outstanding--
+	this.mutex.Unlock()
+}
+
+func (this *workerProxy) setRotation(w *proxyWorker, in bool) {
+	this.mutex.Lock()
+	w.inRotation = in
+	this.mutex.Unlock()
+}
+
+//setPorts puts the workers of ports in rotation and takes out the others
+func (this *workerProxy) setPorts(ports map[string]int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for _, w := range this.workers {
+		_, w.inRotation = ports[w.port]
+	}
+}
+
+func (this *workerProxy) inRotation() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	n := 0
+	for _, w := range this.workers {
+		if w.inRotation {
+			n++
+		}
+	}
+	return n
+}
+
+//outstandingBody ends the request of a worker when the response body is
+//closed
+type outstandingBody struct {
+	io.ReadCloser
+	proxy  *workerProxy
+	worker *proxyWorker
+	once   sync.Once
+}
+
+func (this *outstandingBody) Close() error {
+	this.once.Do(func() { this.proxy.done(this.worker) })
+	return this.ReadCloser.Close()
+}
@@ -0,0 +1,67 @@
+package imgsvr
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func testWorker(t *testing.T) (*httptest.Server, string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	u, _ := url.Parse(server.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+	return server, port
+}
+
+//closedPort is a port nothing listens on
+func closedPort(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+	return port
+}
+
+func TestProxyDialError(t *testing.T) {
+	server, port := testWorker(t)
+	defer server.Close()
+	p := newWorkerProxy([]string{closedPort(t), port})
+	req, _ := http.NewRequest("GET", "http://worker/healthz", nil)
+	rsp, err := p.RoundTrip(req)
+	if err != nil {
+		t.Fatal("GET should be retried on the other worker: " + err.Error())
+	}
+	rsp.Body.Close()
+	if p.inRotation() != 1 || p.workers[0].inRotation {
+		t.Error("the worker refusing connections should be out of rotation")
+	}
+}
+
+func TestProxyClientCanceled(t *testing.T) {
+	server1, port1 := testWorker(t)
+	defer server1.Close()
+	server2, port2 := testWorker(t)
+	defer server2.Close()
+	p := newWorkerProxy([]string{port1, port2})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequest("GET", "http://worker/healthz", nil)
+	if _, err := p.RoundTrip(req.WithContext(ctx)); err == nil {
+		t.Fatal("a canceled request should fail")
+	}
+	if p.inRotation() != 2 {
+		t.Error("a canceled request shouldn't take workers out of rotation")
+	}
+	for _, w := range p.workers {
+		if w.outstanding != 0 {
+			t.Error("a canceled request should end its outstanding request")
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package imgsvr
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/ctripcorp/nephele/imgsvr/data"
+	"os"
+	"time"
+)
+
+//how often watchConfig checks the config file
+var configCheckInterval = time.Second
+
+//reloadConfig reloads the config file, source tells what triggered it. The
+//previous config is kept if the file is invalid.
+func reloadConfig(source string) error {
+	previous := data.Current().Version
+	if err := data.Reload(); err != nil {
+		log.WithFields(log.Fields{
+			"source":  source,
+			"version": previous,
+			"type":    "Config.ReloadError",
+		}).Error(err.Error())
+		LogErrorEvent(TelemetryInstance, "Config.ReloadError", err.Error())
+		return err
+	}
+	if version := data.Current().Version; version != previous {
+		log.WithFields(log.Fields{
+			"source":   source,
+			"version":  version,
+			"previous": previous,
+		}).Info("config reloaded")
+		LogEvent(TelemetryInstance, "Config.Version", version, map[string]string{"previous": previous, "source": source})
+	}
+	return nil
+}
+
+//watchConfig reloads the config when the file changes, and every
+//configreloadinterval seconds if it is set
+func watchConfig() {
+	last := time.Now()
+	for {
+		time.Sleep(configCheckInterval)
+		interval := time.Duration(data.Current().ConfigReloadInterval) * time.Second
+		switch {
+		case data.Changed():
+			reloadConfig("file")
+		case interval > 0 && time.Since(last) >= interval:
+			reloadConfig("periodic")
+		default:
+			continue
+		}
+		last = time.Now()
+	}
+}
+
+//reloadOnSignal reloads the config on each signal of c, forward is called
+//after the reload
+func reloadOnSignal(c chan os.Signal, forward func(os.Signal)) {
+	for sig := range c {
+		reloadConfig("signal")
+		if forward != nil {
+			forward(sig)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package replay
+
+import (
+	"math"
+	"sort"
+)
+
+//Comparison shows what changed from the base run to the next one
+type Comparison struct {
+	Base           string  `json:"base"`
+	Next           string  `json:"next"`
+	ThroughputBase float64 `json:"throughputBase"`
+	ThroughputNext float64 `json:"throughputNext"`
+	//the latencies of the next run minus those of the base
+	LatencyMsDelta Percentiles `json:"latencyMsDelta"`
+	//error type: requests of the next run minus those of the base
+	ErrorsDelta map[string]int `json:"errorsDelta"`
+	//urls successful in both runs, and those whose size changed
+	Compared  int   `json:"compared"`
+	Changed   int   `json:"changed"`
+	BytesBase int64 `json:"bytesBase"`
+	BytesNext int64 `json:"bytesNext"`
+	//change of the bytes of the compared urls in percent
+	BytesDeltaPercent float64 `json:"bytesDeltaPercent"`
+	//the changed urls by the largest relative change
+	Largest []SizeDelta `json:"largest"`
+}
+
+type SizeDelta struct {
+	Uri     string  `json:"uri"`
+	Base    int     `json:"base"`
+	Next    int     `json:"next"`
+	Percent float64 `json:"percent"`
+}
+
+//Compare compares two reports of the same log, top is how many of the
+//largest size changes are listed, none if it is negative
+func Compare(base, next *Report, top int) *Comparison {
+	c := &Comparison{
+		Base:           base.Target,
+		Next:           next.Target,
+		ThroughputBase: base.Throughput,
+		ThroughputNext: next.Throughput,
+		LatencyMsDelta: Percentiles{
+			Mean: next.LatencyMs.Mean - base.LatencyMs.Mean,
+			P50:  next.LatencyMs.P50 - base.LatencyMs.P50,
+			P90:  next.LatencyMs.P90 - base.LatencyMs.P90,
+			P99:  next.LatencyMs.P99 - base.LatencyMs.P99,
+			Max:  next.LatencyMs.Max - base.LatencyMs.Max,
+		},
+		ErrorsDelta: make(map[string]int),
+		Largest:     []SizeDelta{},
+	}
+	for errType, n := range next.Errors {
+		c.ErrorsDelta[errType] += n
+	}
+	for errType, n := range base.Errors {
+		c.ErrorsDelta[errType] -= n
+	}
+	for errType, n := range c.ErrorsDelta {
+		if n == 0 {
+			delete(c.ErrorsDelta, errType)
+		}
+	}
+	deltas := []SizeDelta{}
+	for uri, b := range base.Sizes {
+		n, ok := next.Sizes[uri]
+		if !ok {
+			continue
+		}
+		c.Compared++
+		c.BytesBase += int64(b)
+		c.BytesNext += int64(n)
+		if n != b {
+			deltas = append(deltas, SizeDelta{uri, b, n, percent(int64(b), int64(n))})
+		}
+	}
+	c.Changed = len(deltas)
+	c.BytesDeltaPercent = percent(c.BytesBase, c.BytesNext)
+	sort.Sort(byChange(deltas))
+	if top < 0 {
+		top = 0
+	}
+	if len(deltas) > top {
+		deltas = deltas[:top]
+	}
+	c.Largest = append(c.Largest, deltas...)
+	return c
+}
+
+func percent(base, next int64) float64 {
+	if base == 0 {
+		return 0
+	}
+	return float64(next-base) * 100 / float64(base)
+}
+
+type byChange []SizeDelta
+
+func (a byChange) Len() int      { return len(a) }
+func (a byChange) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byChange) Less(i, j int) bool {
+	if pi, pj := math.Abs(a[i].Percent), math.Abs(a[j].Percent); pi != pj {
+		return pi > pj
+	}
+	return a[i].Uri < a[j].Uri
+}
@@ -0,0 +1,238 @@
+//Package replay replays recorded image urls against a worker and compares
+//the reports of two runs, e.g. before and after a config or engine change.
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//response header of a failed request carrying the error type, see
+//imgsvr.ErrorHeader
+var ErrorHeader = "X-Nephele-Error"
+
+//Options of a run
+type Options struct {
+	//base url of the worker, e.g. http://127.0.0.1:8081
+	Target string
+	//requests started per second, unlimited if 0 or above MaxRate
+	Rate float64
+	//requests in flight at most, 1 if less
+	Concurrency int
+	//timeout of a request, none if 0
+	Timeout time.Duration
+}
+
+//Report is the result of a run
+type Report struct {
+	Target   string  `json:"target"`
+	Requests int     `json:"requests"`
+	Failed   int     `json:"failed"`
+	Seconds  float64 `json:"seconds"`
+	//requests per second
+	Throughput float64        `json:"throughput"`
+	LatencyMs  Percentiles    `json:"latencyMs"`
+	Statuses   map[string]int `json:"statuses"`
+	//error type: requests, the type is the error header of the worker, or
+	//Timeout, RequestError or HTTP STATUS
+	Errors   map[string]int `json:"errors"`
+	BytesOut int64          `json:"bytesOut"`
+	//url: bytes of the last successful response, compared between runs
+	Sizes map[string]int `json:"sizes"`
+}
+
+type Percentiles struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+type result struct {
+	uri       string
+	status    int
+	errorType string
+	bytes     int
+	latency   time.Duration
+}
+
+//ReadLog reads the urls of a log: lines of json with "uri" like the access
+//log of imgsvr, or text lines whose first field starting with / is the
+//url, which covers plain url lists and the request of nginx logs. Empty
+//lines and lines starting with # are skipped.
+func ReadLog(r io.Reader) ([]string, error) {
+	uris := []string{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '{' {
+			var entry struct {
+				Uri string `json:"uri"`
+			}
+			if err := json.Unmarshal([]byte(line), &entry); err == nil && entry.Uri != "" {
+				uris = append(uris, entry.Uri)
+			}
+			continue
+		}
+		for _, field := range strings.Fields(line) {
+			if field[0] == '/' {
+				uris = append(uris, field)
+				break
+			}
+		}
+	}
+	return uris, scanner.Err()
+}
+
+//MaxRate is the highest rate which can be paced, one request a nanosecond
+const MaxRate = 1e9
+
+//Run requests the urls from the target with the rate and concurrency of
+//options and reports the responses
+func Run(uris []string, options Options) *Report {
+	concurrency := options.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	client := &http.Client{
+		Timeout:   options.Timeout,
+		Transport: &http.Transport{MaxIdleConnsPerHost: concurrency},
+	}
+	target := strings.TrimSuffix(options.Target, "/")
+	c := make(chan string)
+	results := make([]*result, 0, len(uris))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for uri := range c {
+				r := request(client, target, uri)
+				mutex.Lock()
+				results = append(results, r)
+				mutex.Unlock()
+			}
+		}()
+	}
+	start := time.Now()
+	var tick <-chan time.Time
+	if options.Rate > 0 && options.Rate <= MaxRate {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / options.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for i, uri := range uris {
+		if tick != nil && i > 0 {
+			<-tick
+		}
+		c <- uri
+	}
+	close(c)
+	wg.Wait()
+	return newReport(options.Target, results, time.Since(start))
+}
+
+func request(client *http.Client, target, uri string) *result {
+	r := &result{uri: uri}
+	start := time.Now()
+	rsp, err := client.Get(target + uri)
+	if err == nil {
+		var bts []byte
+		bts, err = ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		r.status, r.bytes = rsp.StatusCode, len(bts)
+	}
+	r.latency = time.Since(start)
+	switch {
+	case err != nil:
+		r.errorType = "RequestError"
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			r.errorType = "Timeout"
+		}
+	case r.status != http.StatusOK:
+		r.errorType = rsp.Header.Get(ErrorHeader)
+		if r.errorType == "" {
+			r.errorType = "HTTP " + strconv.Itoa(r.status)
+		}
+	}
+	return r
+}
+
+func newReport(target string, results []*result, elapsed time.Duration) *Report {
+	report := &Report{
+		Target:   target,
+		Requests: len(results),
+		Seconds:  elapsed.Seconds(),
+		Statuses: make(map[string]int),
+		Errors:   make(map[string]int),
+		Sizes:    make(map[string]int),
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(len(results)) / elapsed.Seconds()
+	}
+	latencies := make([]time.Duration, 0, len(results))
+	for _, r := range results {
+		latencies = append(latencies, r.latency)
+		if r.status != 0 {
+			report.Statuses[strconv.Itoa(r.status)]++
+		}
+		if r.errorType != "" {
+			report.Failed++
+			report.Errors[r.errorType]++
+			continue
+		}
+		report.BytesOut += int64(r.bytes)
+		report.Sizes[r.uri] = r.bytes
+	}
+	report.LatencyMs = percentiles(latencies)
+	return report
+}
+
+//percentiles of the latencies in milliseconds, by the nearest rank
+func percentiles(latencies []time.Duration) Percentiles {
+	p := Percentiles{}
+	if len(latencies) == 0 {
+		return p
+	}
+	sort.Sort(durations(latencies))
+	var sum time.Duration
+	for _, d := range latencies {
+		sum += d
+	}
+	rank := func(q float64) float64 {
+		i := int(q*float64(len(latencies))+0.5) - 1
+		if i < 0 {
+			i = 0
+		}
+		return milliseconds(latencies[i])
+	}
+	p.Mean = milliseconds(sum / time.Duration(len(latencies)))
+	p.P50, p.P90, p.P99 = rank(0.5), rank(0.9), rank(0.99)
+	p.Max = milliseconds(latencies[len(latencies)-1])
+	return p
+}
+
+type durations []time.Duration
+
+func (a durations) Len() int           { return len(a) }
+func (a durations) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a durations) Less(i, j int) bool { return a[i] < a[j] }
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
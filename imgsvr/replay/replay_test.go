@@ -0,0 +1,98 @@
+package replay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadLog(t *testing.T) {
+	log := `# comment
+/images/tg/a_C_100_100.jpg
+{"time":"2016-01-01T00:00:00Z","uri":"/images/tg/b_R_200_200.jpg","status":"200"}
+127.0.0.1 - - [01/Jan/2016:00:00:00 +0800] "GET /images/tg/c_Z_300_300.jpg HTTP/1.1" 200 1024
+
+{"uri":""}
+`
+	uris, err := ReadLog(strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/images/tg/a_C_100_100.jpg", "/images/tg/b_R_200_200.jpg", "/images/tg/c_Z_300_300.jpg"}
+	if strings.Join(uris, ",") != strings.Join(expected, ",") {
+		t.Error("unexpected urls: " + strings.Join(uris, ","))
+	}
+}
+
+func TestRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "missing") {
+			w.Header().Set(ErrorHeader, "NFS.FileNotExistError")
+			http.Error(w, "Not Found", 404)
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	uris := []string{"/a", "/bb", "/missing", "/a"}
+	report := Run(uris, Options{Target: server.URL, Concurrency: 2, Timeout: time.Second})
+	if report.Requests != 4 || report.Failed != 1 {
+		t.Errorf("requests %d, failed %d", report.Requests, report.Failed)
+	}
+	if report.Errors["NFS.FileNotExistError"] != 1 || report.Statuses["200"] != 3 || report.Statuses["404"] != 1 {
+		t.Error("error types and statuses should be counted")
+	}
+	if report.Sizes["/bb"] != 3 || report.BytesOut != 7 {
+		t.Error("sizes should be recorded")
+	}
+	if report.LatencyMs.Max < report.LatencyMs.P50 || report.Throughput <= 0 {
+		t.Error("latency and throughput should be reported")
+	}
+
+	start := time.Now()
+	Run([]string{"/a", "/a", "/a"}, Options{Target: server.URL, Rate: 20})
+	if time.Since(start) < 90*time.Millisecond {
+		t.Error("the rate should be limited")
+	}
+	if report = Run([]string{"/a"}, Options{Target: server.URL, Rate: 1e10}); report.Requests != 1 {
+		t.Error("a rate above MaxRate should be unlimited")
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	latencies := []time.Duration{}
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	p := percentiles(latencies)
+	if p.P50 != 50 || p.P90 != 90 || p.P99 != 99 || p.Max != 100 || p.Mean != 50.5 {
+		t.Errorf("unexpected percentiles %+v", p)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	base := &Report{
+		Errors: map[string]int{"ProcessTimeout": 2},
+		Sizes:  map[string]int{"/a": 100, "/b": 200, "/c": 300, "/gone": 1},
+	}
+	next := &Report{
+		Errors: map[string]int{"ProcessTimeout": 1, "ProcessError": 1},
+		Sizes:  map[string]int{"/a": 100, "/b": 100, "/c": 330, "/new": 1},
+	}
+	c := Compare(base, next, 1)
+	if c.Compared != 3 || c.Changed != 2 || c.BytesBase != 600 || c.BytesNext != 530 {
+		t.Errorf("unexpected comparison %+v", c)
+	}
+	if len(c.Largest) != 1 || c.Largest[0].Uri != "/b" || c.Largest[0].Percent != -50 {
+		t.Error("the largest change should be listed")
+	}
+	if c.ErrorsDelta["ProcessTimeout"] != -1 || c.ErrorsDelta["ProcessError"] != 1 {
+		t.Error("error deltas should be counted")
+	}
+	if c = Compare(base, next, -1); c.Changed != 2 || len(c.Largest) != 0 {
+		t.Error("a negative top should list no changes")
+	}
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/ctripcorp/nephele/imgsvr"
+	"github.com/ctripcorp/nephele/imgsvr/data"
+	"github.com/ctripcorp/nephele/imgsvr/replay"
+	"github.com/ctripcorp/nephele/telemetry"
+	"github.com/ctripcorp/nephele/util"
+	//driver of the mysql config source
+	_ "github.com/go-sql-driver/mysql"
+	"io/ioutil"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string)
+}
+
+var commands = []command{
+	{"serve", "run the daemon and its workers", serve},
+	{"worker", "run a worker, the daemon starts them", worker},
+	{"reload", "reload the config of the workers of a daemon", reload},
+	{"restart", "replace the workers of a daemon one at a time", restart},
+	{"stop", "stop a daemon and its workers", stop},
+	{"nginx", "write the worker ports to the nginx upstream and restart nginx", nginx},
+	{"checkconf", "validate a config file", checkconf},
+	{"process", "process image urls offline and report sizes and timings", process},
+	{"replay", "replay a log of urls against a worker and report latencies", replayLog},
+	{"compare", "compare the reports of two replays", compare},
+}
+
+func main() {
+	runtime.GOMAXPROCS(1)
+	if len(os.Args) > 1 {
+		for _, cmd := range commands {
+			if cmd.name == os.Args[1] {
+				cmd.run(os.Args[2:])
+				return
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "usage: %s COMMAND [flags]\n\ncommands:\n", filepath.Base(os.Args[0]))
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun %s COMMAND -help for the flags of a command\n", filepath.Base(os.Args[0]))
+	os.Exit(2)
+}
+
+//serviceFlags are the flags of serve and worker, a flag left out is taken
+//from its environment variable
+type serviceFlags struct {
+	fs        *flag.FlagSet
+	config    string
+	env       string
+	catDomain string
+	catHost   string
+	//the images of the directory stand in for every storage
+	localStorage string
+	//the log flags override the keys of the config, see initLog
+	log data.LogPolicy
+	//names of the flags above, flags a command adds aren't among them
+	names map[string]bool
+}
+
+func newServiceFlags(name string) *serviceFlags {
+	f := &serviceFlags{fs: flag.NewFlagSet(name, flag.ExitOnError)}
+	f.fs.StringVar(&f.config, "config", os.Getenv("NEPHELE_CONFIG"), "config source: an ini file, mysql:DSN or http://imgws-host (NEPHELE_CONFIG), conf/ENV_conf.ini of the installation by default")
+	f.fs.StringVar(&f.env, "env", os.Getenv("NEPHELE_ENV"), "running environment, e.g. uat, fat or prod (NEPHELE_ENV), uat by default")
+	f.fs.StringVar(&f.catDomain, "catdomain", os.Getenv("NEPHELE_CAT_DOMAIN"), "CAT domain (NEPHELE_CAT_DOMAIN), nephele by default")
+	f.fs.StringVar(&f.catHost, "cathost", os.Getenv("NEPHELE_CAT_HOST"), "CAT server url (NEPHELE_CAT_HOST), the server of the environment by default")
+	f.fs.StringVar(&f.localStorage, "localstorage", "", "directory of sample images served instead of fdfs and nfs, for reproducible replays")
+	logFlags(f.fs, &f.log)
+	f.names = make(map[string]bool)
+	f.fs.VisitAll(func(fl *flag.Flag) { f.names[fl.Name] = true })
+	return f
+}
+
+//logFlags defines the log flags on fs with the values of config as defaults
+func logFlags(fs *flag.FlagSet, config *data.LogPolicy) {
+	fs.StringVar(&config.Level, "loglevel", config.Level, "debug, info, warn or error")
+	fs.StringVar(&config.File, "logfile", config.File, "service log")
+	fs.StringVar(&config.AccessLog, "accesslog", config.AccessLog, "access log, disabled if empty")
+	fs.Float64Var(&config.AccessLogSample, "accesslogsample", config.AccessLogSample, "fraction of requests written to the access log, 0~1")
+	fs.IntVar(&config.MaxSize, "logmaxsize", config.MaxSize, "a log is rotated when it exceeds the size in MB")
+	fs.IntVar(&config.MaxBackups, "logmaxbackups", config.MaxBackups, "rotated files kept of a log")
+}
+
+//setup applies the environment, loads the config and sets up the logs. A
+//config which can't be loaded is logged, the service runs with an empty
+//policy and isn't ready until a reload succeeds.
+func (this *serviceFlags) setup() {
+	if this.env != "" {
+		util.SetRunningEnv(this.env)
+	}
+	if this.config == "" {
+		this.config = defaultConfig()
+	}
+	imgsvr.SetLocalStorage(this.localStorage)
+	err := data.Load(this.config)
+	this.initLog()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"config": this.config,
+			"type":   "Config.LoadError",
+		}).Error(err.Error())
+	}
+}
+
+//initTelemetry selects the backend of the config, service is the
+//service.name of traces
+func (this *serviceFlags) initTelemetry(service string) {
+	config := telemetry.Config{
+		ServiceName: service,
+		Domain:      this.catDomain,
+		Host:        this.catHost,
+	}
+	if err := imgsvr.InitTelemetry(config); err != nil {
+		log.WithFields(log.Fields{
+			"type": "Telemetry.InitError",
+		}).Error(err.Error())
+	}
+}
+
+//defaultConfig is conf/ENV_conf.ini of the installation, the directory
+//above the one of the binary
+func defaultConfig() string {
+	name := util.GetRunningEnv() + "_conf.ini"
+	exe, err := os.Executable()
+	if err != nil {
+		return filepath.Join("..", "conf", name)
+	}
+	return filepath.Join(filepath.Dir(exe), "..", "conf", name)
+}
+
+//initLog sets up the logs by the config and the log flags given
+func (this *serviceFlags) initLog() {
+	config := data.Current().Log
+	lfs := flag.NewFlagSet("log", flag.ContinueOnError)
+	logFlags(lfs, &config)
+	this.fs.Visit(func(f *flag.Flag) {
+		if lfs.Lookup(f.Name) != nil {
+			lfs.Set(f.Name, f.Value.String())
+		}
+	})
+	if err := data.CheckLogLevel(config.Level); err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	if config.AccessLogSample < 0 || config.AccessLogSample > 1 {
+		log.Error("accesslogsample should be in 0~1")
+		os.Exit(1)
+	}
+	if err := imgsvr.InitLog(config); err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+//args returns the service flags given, the daemon passes them on to its
+//workers
+func (this *serviceFlags) args() []string {
+	args := []string{}
+	this.fs.Visit(func(f *flag.Flag) {
+		if this.names[f.Name] {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	return args
+}
+
+func serve(args []string) {
+	f := newServiceFlags("serve")
+	port := f.fs.Int("port", 0, "port of the daemon, workers listen on the ports after it")
+	workers := f.fs.Int("workers", 0, "number of workers, the number of cpus by default")
+	nginxPath := f.fs.String("nginx", "", "nginx installation whose upstream is updated with the ready workers")
+	nginxPort := f.fs.String("nginxport", "80", "port nginx listens on")
+	proxyPort := f.fs.String("proxy", "", "public port served by the built-in proxy instead of nginx")
+	f.fs.Parse(args)
+	requirePort(f.fs, *port)
+	if *proxyPort != "" {
+		if _, err := strconv.Atoi(*proxyPort); err != nil {
+			fmt.Fprintln(os.Stderr, "proxy should be a port")
+			os.Exit(2)
+		}
+	}
+	f.setup()
+	f.initTelemetry("nephele-daemon")
+	hostprocess := &imgsvr.HostProcessor{
+		Port:        *port,
+		ThreadCount: *workers,
+		NginxPath:   *nginxPath,
+		NginxPort:   *nginxPort,
+		ProxyPort:   *proxyPort,
+		Args:        f.args(),
+	}
+	hostprocess.Run()
+}
+
+func worker(args []string) {
+	f := newServiceFlags("worker")
+	port := f.fs.Int("port", 0, "port of the worker")
+	hostPort := f.fs.Int("hostport", 0, "port of the daemon the worker reports to, none if 0")
+	f.fs.Parse(args)
+	requirePort(f.fs, *port)
+	f.setup()
+	f.initTelemetry("nephele")
+	subprocess := &imgsvr.SubProcessor{
+		Port: strconv.Itoa(*port),
+	}
+	if *hostPort != 0 {
+		subprocess.HostPort = strconv.Itoa(*hostPort)
+	}
+	subprocess.Run()
+}
+
+func reload(args []string) {
+	fs := flag.NewFlagSet("reload", flag.ExitOnError)
+	port := fs.Int("port", 0, "port of the daemon")
+	workers := fs.Int("workers", 0, "number of workers of the daemon, the number of cpus by default")
+	fs.Parse(args)
+	requirePort(fs, *port)
+	hostprocess := &imgsvr.HostProcessor{
+		Port:        *port,
+		ThreadCount: *workers,
+	}
+	hostprocess.ReloadConf()
+}
+
+//restart asks the daemon on port to replace its workers one at a time
+func restart(args []string) {
+	fs := flag.NewFlagSet("restart", flag.ExitOnError)
+	port := fs.Int("port", 0, "port of the daemon")
+	fs.Parse(args)
+	requirePort(fs, *port)
+	rsp, err := http.Get("http://127.0.0.1:" + strconv.Itoa(*port) + "/restart/")
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	defer rsp.Body.Close()
+	bts, _ := ioutil.ReadAll(rsp.Body)
+	if !strings.HasPrefix(string(bts), "1") {
+		log.Error("rolling restart failed: " + strings.TrimPrefix(string(bts), "0 "))
+		os.Exit(1)
+	}
+	log.Info("rolling restart done")
+}
+
+func stop(args []string) {
+	fs := flag.NewFlagSet("stop", flag.ExitOnError)
+	port := fs.Int("port", 0, "port of the daemon")
+	fs.Parse(args)
+	requirePort(fs, *port)
+	hostprocess := &imgsvr.HostProcessor{Port: *port}
+	if err := hostprocess.Stop(); err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+func nginx(args []string) {
+	fs := flag.NewFlagSet("nginx", flag.ExitOnError)
+	path := fs.String("path", "", "nginx installation, e.g. /usr/local/nginx/")
+	nginxPort := fs.String("nginxport", "80", "port nginx listens on")
+	port := fs.Int("port", 0, "port of the daemon")
+	workers := fs.Int("workers", 0, "number of workers of the daemon, the number of cpus by default")
+	fs.Parse(args)
+	requirePort(fs, *port)
+	if *path == "" {
+		fmt.Fprintln(os.Stderr, "path is required")
+		fs.Usage()
+		os.Exit(2)
+	}
+	hostprocess := &imgsvr.HostProcessor{
+		Port:        *port,
+		ThreadCount: *workers,
+		NginxPath:   *path,
+		NginxPort:   *nginxPort,
+	}
+	hostprocess.ModifyNginxconf()
+}
+
+func checkconf(args []string) {
+	fs := flag.NewFlagSet("checkconf", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: checkconf FILE")
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if !imgsvr.CheckConf(fs.Arg(0), os.Stdout) {
+		os.Exit(1)
+	}
+}
+
+//process runs image urls through the chain of their channel without a
+//server: a url, a list of urls, or every file of a directory by a url
+func process(args []string) {
+	f := newServiceFlags("process")
+	uri := f.fs.String("url", "", "image url, e.g. /images/tg/a/b_C_100_100.jpg, the template of the files with -dir")
+	src := f.fs.String("src", "", "local file used instead of the storage of -url")
+	out := f.fs.String("out", "", "result of -url, OUTDIR/path of the url by default")
+	list := f.fs.String("list", "", "file of urls, a line is URL or URL SRC, # starts a comment")
+	dir := f.fs.String("dir", "", "directory whose files are processed by -url, results are OUTDIR/NAME.EXT")
+	outDir := f.fs.String("outdir", ".", "directory of the results")
+	report := f.fs.String("report", "", "json report of sizes and timings, stdout by default")
+	parallel := f.fs.Int("parallel", runtime.NumCPU(), "images processed at the same time")
+	f.fs.Parse(args)
+	//logs go to stderr unless -logfile is given
+	if !f.visited("logfile") {
+		f.fs.Set("logfile", "/dev/stderr")
+	}
+
+	var jobs []imgsvr.ProcessJob
+	var err error
+	switch {
+	case *list != "":
+		jobs, err = listJobs(*list, *outDir)
+	case *dir != "":
+		jobs, err = dirJobs(*dir, *uri, *outDir)
+	case *uri != "":
+		if *out == "" {
+			*out = urlOutput(*outDir, *uri)
+		}
+		jobs = []imgsvr.ProcessJob{{Uri: *uri, Src: *src, Out: *out}}
+	default:
+		fmt.Fprintln(os.Stderr, "one of -url, -list or -dir is required")
+		f.fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+
+	f.setup()
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	r := imgsvr.ProcessBatch(jobs, *parallel, telemetry.Noop)
+	writeJson(*report, r)
+	fmt.Fprintf(os.Stderr, "%d images, %d failed, %.0fms\n", r.Jobs, r.Failed, r.TotalMs)
+	if r.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func (this *serviceFlags) visited(name string) bool {
+	visited := false
+	this.fs.Visit(func(f *flag.Flag) {
+		visited = visited || f.Name == name
+	})
+	return visited
+}
+
+//urlOutput is the path of a url under dir, /images/ is left out
+func urlOutput(dir, uri string) string {
+	rel := strings.TrimPrefix(uri, "/images/")
+	//a url can't point out of dir
+	return filepath.Join(dir, filepath.Clean("/"+rel))
+}
+
+func listJobs(list, outDir string) ([]imgsvr.ProcessJob, error) {
+	bts, err := ioutil.ReadFile(list)
+	if err != nil {
+		return nil, err
+	}
+	jobs := []imgsvr.ProcessJob{}
+	for _, line := range strings.Split(string(bts), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		job := imgsvr.ProcessJob{Uri: fields[0], Out: urlOutput(outDir, fields[0])}
+		if len(fields) > 1 {
+			job.Src = fields[1]
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func dirJobs(dir, uri, outDir string) ([]imgsvr.ProcessJob, error) {
+	if uri == "" {
+		return nil, errors.New("-dir needs -url")
+	}
+	if d, err := filepath.Abs(dir); err == nil {
+		if o, err := filepath.Abs(outDir); err == nil && d == o {
+			return nil, errors.New("-outdir would overwrite the files of -dir")
+		}
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(uri)
+	jobs := []imgsvr.ProcessJob{}
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		name := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+		jobs = append(jobs, imgsvr.ProcessJob{
+			Uri: uri,
+			Src: filepath.Join(dir, info.Name()),
+			Out: filepath.Join(outDir, name+ext),
+		})
+	}
+	return jobs, nil
+}
+
+//replayLog requests the urls of a log from a worker
+func replayLog(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	target := fs.String("target", "", "base url of the worker, e.g. http://127.0.0.1:8081")
+	logFile := fs.String("log", "", "access log of imgsvr (json lines), nginx log or list of urls")
+	rate := fs.Float64("rate", 0, "requests started per second, unlimited if 0, at most 1e9")
+	concurrency := fs.Int("concurrency", 8, "requests in flight at most")
+	repeat := fs.Int("repeat", 1, "times the log is replayed")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of a request")
+	report := fs.String("report", "", "json report, compared by nephele compare, stdout by default")
+	fs.Parse(args)
+	if *target == "" || *logFile == "" {
+		fmt.Fprintln(os.Stderr, "target and log are required")
+		fs.Usage()
+		os.Exit(2)
+	}
+	if !(*rate >= 0 && *rate <= replay.MaxRate) {
+		fmt.Fprintln(os.Stderr, "rate should be in 0~1e9")
+		fs.Usage()
+		os.Exit(2)
+	}
+	file, err := os.Open(*logFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	uris, err := replay.ReadLog(file)
+	file.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	all := []string{}
+	for i := 0; i < *repeat; i++ {
+		all = append(all, uris...)
+	}
+	r := replay.Run(all, replay.Options{
+		Target:      *target,
+		Rate:        *rate,
+		Concurrency: *concurrency,
+		Timeout:     *timeout,
+	})
+	writeJson(*report, r)
+	fmt.Fprintf(os.Stderr, "%d requests, %d failed, %.1f/s, latency ms p50 %.1f p90 %.1f p99 %.1f max %.1f\n",
+		r.Requests, r.Failed, r.Throughput, r.LatencyMs.P50, r.LatencyMs.P90, r.LatencyMs.P99, r.LatencyMs.Max)
+}
+
+//compare reports the latency, error and output size deltas of two replays
+func compare(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	top := fs.Int("top", 20, "largest size changes listed")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: compare [-top N] BASE_REPORT NEXT_REPORT")
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *top < 0 {
+		fmt.Fprintln(os.Stderr, "top should be >= 0")
+		fs.Usage()
+		os.Exit(2)
+	}
+	reports := make([]*replay.Report, 2)
+	for i := range reports {
+		bts, err := ioutil.ReadFile(fs.Arg(i))
+		if err == nil {
+			err = json.Unmarshal(bts, &reports[i])
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fs.Arg(i)+": "+err.Error())
+			os.Exit(1)
+		}
+	}
+	writeJson("", replay.Compare(reports[0], reports[1], *top))
+}
+
+//writeJson writes v as indented json to path, to stdout if path is empty
+func writeJson(path string, v interface{}) {
+	bts, _ := json.MarshalIndent(v, "", "  ")
+	bts = append(bts, '\n')
+	if path == "" {
+		os.Stdout.Write(bts)
+		return
+	}
+	if err := ioutil.WriteFile(path, bts, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func requirePort(fs *flag.FlagSet, port int) {
+	if port <= 0 || port > 65535 {
+		fmt.Fprintln(os.Stderr, "port is required")
+		fs.Usage()
+		os.Exit(2)
+	}
+}
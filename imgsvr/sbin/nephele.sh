@@ -4,12 +4,9 @@ gm_ldflags=`GraphicsMagickWand-config --ldflags`
 gm_libs=`GraphicsMagickWand-config --libs`
 sed -i /'#cgo LDFLAGS'/c"#cgo LDFLAGS: $gm_ldflags $gm_libs -lpixels -ldigimark"  ../img4g/image.go
 sed -i /'#cgo CPPFLAGS'/c"#cgo CPPFLAGS: $gm_cppflags" ../img4g/image.go
-sed -i s///g ../img4g/image.go
-t=$1
-port=$2
-nginxpath=$3
-nginxport=$4
-threadcount=$5
-
-setsid go run imgsvrd.go $t $port $nginxpath $nginxport $threadcount
+sed -i s/
+//g ../img4g/image.go
+#the config is ../conf/ENV_conf.ini next to the binary unless -config or NEPHELE_CONFIG is given
+go build -o nephele nephele.go || exit 1
+setsid ./nephele "$@"
 exit
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"github.com/ctripcorp/nephele/imgsvr"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUrlOutput(t *testing.T) {
+	cases := map[string]string{
+		"/images/tg/a/b_C_100_100.jpg": "out/tg/a/b_C_100_100.jpg",
+		"/images/../../etc/passwd":     "out/etc/passwd",
+		"/images/tg/../../../x.jpg":    "out/x.jpg",
+		"../x.jpg":                     "out/x.jpg",
+	}
+	for uri, expected := range cases {
+		if out := urlOutput("out", uri); out != expected {
+			t.Errorf("%s: expect %s, got %s", uri, expected, out)
+		}
+	}
+}
+
+func TestListJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "process")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	list := filepath.Join(dir, "list")
+	ioutil.WriteFile(list, []byte(`# comment
+/images/tg/a_C_100_100.jpg
+
+  /images/tg/b_C_100_100.jpg   /tmp/b.jpg
+#/images/tg/c_C_100_100.jpg
+`), 0644)
+	jobs, err := listJobs(list, "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []imgsvr.ProcessJob{
+		{Uri: "/images/tg/a_C_100_100.jpg", Out: "out/tg/a_C_100_100.jpg"},
+		{Uri: "/images/tg/b_C_100_100.jpg", Src: "/tmp/b.jpg", Out: "out/tg/b_C_100_100.jpg"},
+	}
+	if !reflect.DeepEqual(jobs, expected) {
+		t.Errorf("unexpected jobs %+v", jobs)
+	}
+	if _, err = listJobs(filepath.Join(dir, "missing"), "out"); err == nil {
+		t.Error("a missing list should fail")
+	}
+}
+
+func TestDirJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "process")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"b.png", "a.jpg", ".hidden.jpg"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte{}, 0644)
+	}
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+
+	uri := "/images/tg/x_C_100_100.png"
+	jobs, err := dirJobs(dir, uri, "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//sorted by name, hidden files and directories are left out
+	expected := []imgsvr.ProcessJob{
+		{Uri: uri, Src: filepath.Join(dir, "a.jpg"), Out: "out/a.png"},
+		{Uri: uri, Src: filepath.Join(dir, "b.png"), Out: "out/b.png"},
+	}
+	if !reflect.DeepEqual(jobs, expected) {
+		t.Errorf("unexpected jobs %+v", jobs)
+	}
+	if _, err = dirJobs(dir, "", "out"); err == nil {
+		t.Error("-dir without -url should fail")
+	}
+	if _, err = dirJobs(dir, uri, dir+"/."); err == nil {
+		t.Error("-outdir of -dir should fail")
+	}
+}
@@ -0,0 +1,328 @@
+package imgsvr
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/ctripcorp/nephele/imgsvr/data"
+	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/imgsvr/proc"
+	"github.com/ctripcorp/nephele/telemetry"
+	"github.com/ctripcorp/nephele/util"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//the sprite sheet of the images of the query, its tile map with ext json:
+///sprite/NAME.EXT?tile=WxH&columns=N&spacing=N&background=RRGGBB&mode=c&path=/images/...&path=...
+var spriteUrl = util.RegexpExt{regexp.MustCompile("^/sprite/[a-zA-Z0-9_-]+.(?P<ext>jpg|jpeg|gif|png|json)$")}
+
+//a sprite composites every tile on the image goroutine, so it may take
+//longer than an image
+var spriteTimeout = 15 * time.Second
+
+type spriteSpec struct {
+	Paths      []string
+	TileWidth  int64
+	TileHeight int64
+	//resize type of the tiles, c by default
+	Mode    string
+	Columns int
+	Spacing int64
+	//RRGGBB, white by default
+	Background string
+}
+
+//spriteMap is the layout of a sprite sheet, the tiles are in the order of
+//the paths. Tiles smaller than the cell (resize types r and w) are centered
+//in it.
+type spriteMap struct {
+	Width      int64         `json:"width"`
+	Height     int64         `json:"height"`
+	TileWidth  int64         `json:"tileWidth"`
+	TileHeight int64         `json:"tileHeight"`
+	Tiles      []*spriteTile `json:"tiles"`
+}
+
+type spriteTile struct {
+	Path   string `json:"path"`
+	X      int64  `json:"x"`
+	Y      int64  `json:"y"`
+	Width  int64  `json:"width"`
+	Height int64  `json:"height"`
+}
+
+//SpriteHandler serves /sprite/, every tile is resized like the url
+///images/PATH_MODE_W_H.EXT of its path would be, so the whitelists of the
+//channel of each tile apply
+type SpriteHandler struct{}
+
+func (handler *SpriteHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	atomic.AddInt64(&servedRequests, 1)
+	atomic.AddInt64(&inFlightRequests, 1)
+	defer atomic.AddInt64(&inFlightRequests, -1)
+	Telemetry := telemetry.Instance()
+	uri := request.URL.String()
+	tran := Telemetry.NewTransaction("URL", "Sprite")
+	status, errType := "200", ""
+	defer func() {
+		if errType != "" {
+			atomic.AddInt64(&failedRequests, 1)
+			status = "404"
+			tran.SetStatus(errors.New(errType))
+			writer.Header().Set(ErrorHeader, errType)
+			http.Error(writer, http.StatusText(404), 404)
+		} else {
+			tran.SetStatus("0")
+		}
+		tran.Complete()
+		requestCounter.Inc("sprite", status, errType)
+	}()
+	fail := func(t string, err error) {
+		errType = t
+		logErrWithUri(uri, err.Error(), "warnLevel")
+		LogErrorEvent(Telemetry, t, err.Error())
+	}
+
+	params, ok := spriteUrl.FindStringSubmatchMap(request.URL.Path)
+	if !ok {
+		fail("URI.ParseError", errors.New("URI.ParseError"))
+		return
+	}
+	config := data.Current()
+	spec, err := parseSpriteSpec(request.URL.Query(), config.SpriteMaxTiles, config.SpriteMaxPixels)
+	if err != nil {
+		fail("Sprite.ParseError", err)
+		return
+	}
+	layout := spec.layout()
+	builder := &ProcChainBuilder{Telemetry: Telemetry}
+	tiles := make([]*proc.Tile, len(spec.Paths))
+	sources := make([]map[string]string, len(spec.Paths))
+	for i, path := range spec.Paths {
+		cell := layout.Tiles[i]
+		tile := &proc.Tile{X: cell.X, Y: cell.Y, Width: cell.Width, Height: cell.Height}
+		if sources[i], tile.Chain, errType, err = spec.tileChain(builder, config, path); errType != "" {
+			fail(errType, errors.New(JoinString(path, ": ", err.Error())))
+			return
+		}
+		tiles[i] = tile
+	}
+
+	if params["ext"] == "json" {
+		bts, err := json.Marshal(layout)
+		if err != nil {
+			fail("Sprite.MarshalError", err)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Content-Length", strconv.Itoa(len(bts)))
+		writer.Write(bts)
+		return
+	}
+
+	if errType, err = fetchTiles(tiles, sources, Telemetry); errType != "" {
+		fail(errType, err)
+		return
+	}
+	canvas, err := spriteBackground(spec.Background, Telemetry)
+	if err != nil {
+		fail("ProcessError", err)
+		return
+	}
+	chain := &proc.ProcessorChain{Chain: []proc.ImageProcessor{
+		&proc.CompositeProcessor{layout.Width, layout.Height, tiles, Telemetry},
+		&proc.FormatProcessor{params["ext"], Telemetry},
+	}}
+	rspChan := make(chan bool, 1)
+	task := &nepheleTask{inImg: canvas, chain: chain, rspChan: rspChan, Telemetry: Telemetry, canceled: false}
+	taskChan <- task
+	select {
+	case ok := <-rspChan:
+		if !ok {
+			errType = "ProcessError"
+			logErrWithUri(uri, errType, "errorLevel")
+			return
+		}
+	case <-time.After(spriteTimeout):
+		task.SetCanceled()
+		fail("ProcessTimeout", errors.New("ProcessTimeout"))
+		return
+	}
+	writer.Header().Set("Content-Type", "image/"+params["ext"])
+	writer.Header().Set("Content-Length", strconv.Itoa(len(canvas.Blob)))
+	writer.Header().Set("Last-Modified", "2015/1/1 01:01:01")
+	if _, err = writer.Write(canvas.Blob); err != nil {
+		logErrWithUri(uri, err.Error(), "errorLevel")
+	}
+}
+
+//parseSpriteSpec reads the spec of the query, tile and at least one path
+//are required. The spacing is at most the tile size and the sheet at most
+//maxPixels, so that a query can't make a worker allocate a huge canvas.
+func parseSpriteSpec(query url.Values, maxTiles, maxPixels int) (*spriteSpec, error) {
+	spec := &spriteSpec{Paths: query["path"], Mode: "c", Background: "ffffff"}
+	if len(spec.Paths) == 0 {
+		return nil, errors.New("no path")
+	}
+	if len(spec.Paths) > maxTiles {
+		return nil, errors.New(JoinString(strconv.Itoa(len(spec.Paths)), " tiles exceed spritemaxtiles ", strconv.Itoa(maxTiles)))
+	}
+	size := strings.Split(query.Get("tile"), "x")
+	if len(size) != 2 {
+		return nil, errors.New("tile should be WxH")
+	}
+	var err1, err2 error
+	spec.TileWidth, err1 = strconv.ParseInt(size[0], 10, 64)
+	spec.TileHeight, err2 = strconv.ParseInt(size[1], 10, 64)
+	if err1 != nil || err2 != nil || spec.TileWidth <= 0 || spec.TileHeight <= 0 {
+		return nil, errors.New("tile should be WxH")
+	}
+	if spec.TileWidth > int64(maxPixels) || spec.TileHeight > int64(maxPixels) {
+		return nil, errors.New(JoinString("tile exceeds spritemaxpixels ", strconv.Itoa(maxPixels)))
+	}
+	if v := query.Get("mode"); v != "" {
+		spec.Mode = strings.ToLower(v)
+	}
+	spec.Columns = len(spec.Paths)
+	if v := query.Get("columns"); v != "" {
+		columns, err := strconv.Atoi(v)
+		if err != nil || columns <= 0 {
+			return nil, errors.New("columns should be > 0")
+		}
+		if columns < spec.Columns {
+			spec.Columns = columns
+		}
+	}
+	if v := query.Get("spacing"); v != "" {
+		spacing, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || spacing < 0 || spacing > spec.TileWidth || spacing > spec.TileHeight {
+			return nil, errors.New("spacing should be in 0~tile size")
+		}
+		spec.Spacing = spacing
+	}
+	if v := query.Get("background"); v != "" {
+		if bts, err := hex.DecodeString(v); err != nil || len(bts) != 3 {
+			return nil, errors.New("background should be RRGGBB")
+		}
+		spec.Background = v
+	}
+	//the sizes are bounded above, the product may still overflow
+	if m := spec.layout(); float64(m.Width)*float64(m.Height) > float64(maxPixels) {
+		return nil, errors.New(JoinString("sprite of ", strconv.FormatInt(m.Width, 10), "x", strconv.FormatInt(m.Height, 10),
+			" exceeds spritemaxpixels ", strconv.Itoa(maxPixels)))
+	}
+	return spec, nil
+}
+
+//layout places the tiles row by row, spacing is between the tiles
+func (spec *spriteSpec) layout() *spriteMap {
+	columns := int64(spec.Columns)
+	rows := (int64(len(spec.Paths)) + columns - 1) / columns
+	m := &spriteMap{
+		Width:      columns*spec.TileWidth + (columns-1)*spec.Spacing,
+		Height:     rows*spec.TileHeight + (rows-1)*spec.Spacing,
+		TileWidth:  spec.TileWidth,
+		TileHeight: spec.TileHeight,
+		Tiles:      make([]*spriteTile, 0, len(spec.Paths)),
+	}
+	for i, path := range spec.Paths {
+		column, row := int64(i)%columns, int64(i)/columns
+		m.Tiles = append(m.Tiles, &spriteTile{
+			Path:   path,
+			X:      column * (spec.TileWidth + spec.Spacing),
+			Y:      row * (spec.TileHeight + spec.Spacing),
+			Width:  spec.TileWidth,
+			Height: spec.TileHeight,
+		})
+	}
+	return m
+}
+
+//tileChain resolves the path of a tile as the image url of the tile size
+//and builds its resize processor, the error types are those of Handler
+func (spec *spriteSpec) tileChain(builder *ProcChainBuilder, config *data.Policy, path string) (map[string]string, *proc.ProcessorChain, string, error) {
+	i := strings.LastIndex(path, ".")
+	if i < 0 {
+		return nil, nil, "URI.ParseError", errors.New("no ext")
+	}
+	uri := JoinString(path[:i], "_", strings.ToUpper(spec.Mode), "_", strconv.FormatInt(spec.TileWidth, 10), "_", strconv.FormatInt(spec.TileHeight, 10), path[i:])
+	params, ok := legalUrl.FindStringSubmatchMap(uri)
+	if !ok {
+		return nil, nil, "URI.ParseError", errors.New("not an image url")
+	}
+	_, channel, _ := ParseUri(params[":1"])
+	p, err := builder.getResizeProcessor(channel, config.Channel(channel), params)
+	if err == nil && p == nil {
+		err = errors.New(JoinString("channel: ", channel, ", reason: no resize"))
+	}
+	if err != nil {
+		return nil, nil, operations[CmdResize].errType, err
+	}
+	return params, &proc.ProcessorChain{Chain: []proc.ImageProcessor{p}}, "", nil
+}
+
+//fetchTiles fetches the images of the tiles in parallel, the error is the
+//one of the first tile which failed
+func fetchTiles(tiles []*proc.Tile, sources []map[string]string, Telemetry telemetry.Telemetry) (string, error) {
+	errTypes := make([]string, len(tiles))
+	errs := make([]error, len(tiles))
+	var wg sync.WaitGroup
+	for i := range tiles {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			store, storageType, err := FindStorage(sources[i], Telemetry)
+			if err != nil {
+				errTypes[i], errs[i] = "Storage.ParseError", err
+				return
+			}
+			bts, err := store.GetImage()
+			if err != nil {
+				t := storageErrorType(err)
+				storageErrorCounter.Inc(storageType, t)
+				errTypes[i], errs[i] = JoinString(storageType, ".", t), err
+				return
+			}
+			if len(bts) == 0 {
+				storageErrorCounter.Inc(storageType, "ImgLenZero")
+				errTypes[i], errs[i] = JoinString(storageType, ".ImgLenZero"), errors.New("recv image length is 0")
+				return
+			}
+			tiles[i].Image = &img4g.Image{Blob: bts, Format: sources[i]["ext"], Telemetry: Telemetry}
+		}(i)
+	}
+	wg.Wait()
+	for i := range tiles {
+		if errTypes[i] != "" {
+			return errTypes[i], errs[i]
+		}
+	}
+	return "", nil
+}
+
+//spriteBackground is a pixel of the background color, CompositeProcessor
+//scales it to the size of the sheet
+func spriteBackground(background string, Telemetry telemetry.Telemetry) (*img4g.Image, error) {
+	bts, err := hex.DecodeString(background)
+	if err != nil {
+		return nil, err
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	rgba.Set(0, 0, color.RGBA{bts[0], bts[1], bts[2], 255})
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, rgba); err != nil {
+		return nil, err
+	}
+	return &img4g.Image{Blob: buf.Bytes(), Format: "png", Telemetry: Telemetry}, nil
+}
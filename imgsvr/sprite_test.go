@@ -0,0 +1,94 @@
+package imgsvr
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseSpriteSpec(t *testing.T) {
+	query, _ := url.ParseQuery("tile=100x50&path=/images/tg/a.jpg&path=/images/tg/b.jpg&path=/images/tg/c.jpg&mode=R&columns=2&spacing=10&background=00ff00")
+	spec, err := parseSpriteSpec(query, 50, 4096*4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &spriteSpec{
+		Paths:      []string{"/images/tg/a.jpg", "/images/tg/b.jpg", "/images/tg/c.jpg"},
+		TileWidth:  100,
+		TileHeight: 50,
+		Mode:       "r",
+		Columns:    2,
+		Spacing:    10,
+		Background: "00ff00",
+	}
+	if !reflect.DeepEqual(spec, expected) {
+		t.Errorf("unexpected spec %+v", spec)
+	}
+
+	query, _ = url.ParseQuery("tile=100x50&path=/images/tg/a.jpg&path=/images/tg/b.jpg&columns=5")
+	if spec, err = parseSpriteSpec(query, 50, 4096*4096); err != nil {
+		t.Fatal(err)
+	}
+	if spec.Mode != "c" || spec.Columns != 2 || spec.Spacing != 0 || spec.Background != "ffffff" {
+		t.Errorf("unexpected defaults %+v", spec)
+	}
+
+	paths := "&path=/images/tg/a.jpg&path=/images/tg/b.jpg&path=/images/tg/c.jpg&path=/images/tg/d.jpg"
+	for _, q := range []string{
+		"tile=100x100",
+		"tile=100" + paths,
+		"tile=0x100" + paths,
+		"tile=axb" + paths,
+		"tile=100x100&columns=0" + paths,
+		"tile=100x100&spacing=-1" + paths,
+		//spacing is at most the tile size
+		"tile=100x100&columns=2&spacing=100000" + paths,
+		"tile=100x50&spacing=51" + paths,
+		"tile=100x100&background=fff" + paths,
+		"tile=100x100&background=gggggg" + paths,
+		//4 tiles of 2048x2048 exceed 4096x4096 with spacing
+		"tile=2048x2048&columns=2&spacing=1" + paths,
+		"tile=9223372036854775807x1" + paths,
+	} {
+		query, _ = url.ParseQuery(q)
+		if _, err = parseSpriteSpec(query, 50, 4096*4096); err == nil {
+			t.Error("expect an error of " + q)
+		}
+	}
+	query, _ = url.ParseQuery("tile=2048x2048&columns=2" + paths)
+	if _, err = parseSpriteSpec(query, 50, 4096*4096); err != nil {
+		t.Error("a sheet of spritemaxpixels should be accepted: " + err.Error())
+	}
+	if _, err = parseSpriteSpec(query, 3, 4096*4096); err == nil {
+		t.Error("expect an error of tiles beyond spritemaxtiles")
+	}
+}
+
+func TestSpriteLayout(t *testing.T) {
+	spec := &spriteSpec{
+		Paths:      []string{"a", "b", "c"},
+		TileWidth:  100,
+		TileHeight: 50,
+		Columns:    2,
+		Spacing:    10,
+	}
+	m := spec.layout()
+	if m.Width != 210 || m.Height != 110 || m.TileWidth != 100 || m.TileHeight != 50 {
+		t.Errorf("unexpected size %dx%d", m.Width, m.Height)
+	}
+	expected := []spriteTile{
+		{"a", 0, 0, 100, 50},
+		{"b", 110, 0, 100, 50},
+		{"c", 0, 60, 100, 50},
+	}
+	for i, tile := range m.Tiles {
+		if *tile != expected[i] {
+			t.Errorf("unexpected tile %+v", tile)
+		}
+	}
+
+	spec.Columns, spec.Spacing = 3, 0
+	if m = spec.layout(); m.Width != 300 || m.Height != 50 || m.Tiles[2].X != 200 {
+		t.Errorf("unexpected layout of one row %+v", m)
+	}
+}
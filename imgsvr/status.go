@@ -0,0 +1,184 @@
+package imgsvr
+
+import (
+	"encoding/json"
+	"github.com/ctripcorp/nephele/util"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+//workerStats is what the daemon knows of a worker from its heartbeats
+type workerStats struct {
+	values    url.Values
+	heartbeat time.Time
+	qps       float64
+	errorRate float64
+}
+
+type hostStatus struct {
+	Pid       int            `json:"pid"`
+	HostPort  string         `json:"hostPort"`
+	ProxyPort string         `json:"proxyPort,omitempty"`
+	Rolling   bool           `json:"rolling"`
+	QPS       float64        `json:"qps"`
+	Workers   []workerStatus `json:"workers"`
+}
+
+type workerStatus struct {
+	Port string `json:"port"`
+	//0 if the worker isn't running
+	Pid           int     `json:"pid"`
+	Health        string  `json:"health"`
+	UptimeSeconds float64 `json:"uptimeSeconds"`
+	Restarts      int     `json:"restarts"`
+	CrashLoop     bool    `json:"crashLoop"`
+	Restarting    bool    `json:"restarting"`
+	RSSMB         int64   `json:"rssMB"`
+	Queue         int64   `json:"queue"`
+	InFlight      int64   `json:"inFlight"`
+	Requests      int64   `json:"requests"`
+	QPS           float64 `json:"qps"`
+	ErrorRate     float64 `json:"errorRate"`
+	HeapAllocMB   int64   `json:"heapAllocMB"`
+	HeapSysMB     int64   `json:"heapSysMB"`
+	HeapInuseMB   int64   `json:"heapInuseMB"`
+	NumGC         int64   `json:"numGC"`
+	//empty if no heartbeat since the worker started
+	LastHeartbeat string `json:"lastHeartbeat,omitempty"`
+}
+
+//recordHeartbeat keeps the stats a worker posted, QPS and error rate are
+//computed against its previous heartbeat
+func recordHeartbeat(port string, values url.Values) {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	w := workers[port]
+	if w == nil {
+		return
+	}
+	now := time.Now()
+	requests := formInt(values, "Requests")
+	failed := formInt(values, "Failed")
+	if prev := w.stats; prev.values != nil {
+		prevRequests, prevFailed := formInt(prev.values, "Requests"), formInt(prev.values, "Failed")
+		//counters start over with a new process
+		if requests < prevRequests {
+			prevRequests, prevFailed = 0, 0
+		}
+		if seconds := now.Sub(prev.heartbeat).Seconds(); seconds > 0 {
+			w.stats.qps = float64(requests-prevRequests) / seconds
+		}
+		w.stats.errorRate = 0
+		if requests > prevRequests {
+			w.stats.errorRate = float64(failed-prevFailed) / float64(requests-prevRequests)
+		}
+	}
+	w.stats.values, w.stats.heartbeat = values, now
+	w.requests = requests
+}
+
+func formInt(values url.Values, key string) int64 {
+	i, _ := strconv.ParseInt(values.Get(key), 10, 64)
+	return i
+}
+
+//setWorkerHealth records the last verdict of the monitor on a worker:
+//ready, unready or unreachable
+func setWorkerHealth(port, health string) {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	if w := workers[port]; w != nil {
+		w.health = health
+	}
+}
+
+func (this *HostProcessor) status() *hostStatus {
+	s := &hostStatus{
+		Pid:       os.Getpid(),
+		HostPort:  hostPort,
+		ProxyPort: this.ProxyPort,
+		Rolling:   atomic.LoadInt32(&rolling) == 1,
+		Workers:   []workerStatus{},
+	}
+	workersMutex.Lock()
+	for port, w := range workers {
+		ws := workerStatus{
+			Port:       port,
+			Health:     w.health,
+			Restarts:   w.restarts,
+			CrashLoop:  w.crashLoop,
+			Restarting: w.restarting,
+		}
+		if w.process != nil {
+			ws.Pid = w.process.Pid
+			ws.UptimeSeconds = time.Since(w.started).Seconds()
+		} else {
+			ws.Health = "stopped"
+		}
+		if v := w.stats.values; v != nil {
+			ws.Queue, ws.InFlight, ws.Requests = formInt(v, "Queue"), formInt(v, "InFlight"), formInt(v, "Requests")
+			ws.QPS, ws.ErrorRate = w.stats.qps, w.stats.errorRate
+			ws.HeapAllocMB, ws.HeapSysMB = formInt(v, "HeapAlloc"), formInt(v, "HeapSys")
+			ws.HeapInuseMB, ws.NumGC = formInt(v, "HeapInuse"), formInt(v, "NumGC")
+			ws.LastHeartbeat = w.stats.heartbeat.Format(time.RFC3339)
+		}
+		s.Workers = append(s.Workers, ws)
+	}
+	workersMutex.Unlock()
+	for i, ws := range s.Workers {
+		if ws.Pid != 0 {
+			if rss, err := util.ProcessRSS(ws.Pid); err == nil {
+				s.Workers[i].RSSMB = rss / 1024 / 1024
+			}
+		}
+		s.QPS += ws.QPS
+	}
+	sort.Sort(byPort(s.Workers))
+	return s
+}
+
+type byPort []workerStatus
+
+func (a byPort) Len() int           { return len(a) }
+func (a byPort) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byPort) Less(i, j int) bool { return a[i].Port < a[j].Port }
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"percent": func(f float64) string { return strconv.FormatFloat(f*100, 'f', 2, 64) + "%" },
+	"fixed":   func(f float64) string { return strconv.FormatFloat(f, 'f', 1, 64) },
+	"uptime":  func(f float64) string { return (time.Duration(f) * time.Second).String() },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta http-equiv="refresh" content="10"><title>nephele {{.HostPort}}</title>
+<style>body{font-family:sans-serif}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:2px 8px;text-align:right}
+.ready{color:green}.unready,.unreachable,.stopped{color:red}</style></head>
+<body><h3>nephele daemon :{{.HostPort}}{{if .ProxyPort}}, proxy :{{.ProxyPort}}{{end}}{{if .Rolling}}, rolling restart running{{end}}, {{fixed .QPS}} qps</h3>
+<table><tr><th>port</th><th>pid</th><th>health</th><th>uptime</th><th>restarts</th><th>rss MB</th><th>queue</th><th>in flight</th>
+<th>requests</th><th>qps</th><th>errors</th><th>heap alloc MB</th><th>heap sys MB</th><th>heap inuse MB</th><th>gc</th><th>last heartbeat</th></tr>
+{{range .Workers}}<tr><td>{{.Port}}</td><td>{{.Pid}}</td>
+<td class="{{.Health}}">{{.Health}}{{if .Restarting}}, restarting{{end}}{{if .CrashLoop}}, crash loop{{end}}</td>
+<td>{{uptime .UptimeSeconds}}</td><td>{{.Restarts}}</td><td>{{.RSSMB}}</td><td>{{.Queue}}</td><td>{{.InFlight}}</td>
+<td>{{.Requests}}</td><td>{{fixed .QPS}}</td><td>{{percent .ErrorRate}}</td><td>{{.HeapAllocMB}}</td><td>{{.HeapSysMB}}</td>
+<td>{{.HeapInuseMB}}</td><td>{{.NumGC}}</td><td>{{.LastHeartbeat}}</td></tr>
+{{end}}</table>
+<p>json: <a href="?format=json">?format=json</a></p></body></html>
+`))
+
+//statusHandler shows every worker, as json with format=json
+func (this *HostProcessor) statusHandler(w http.ResponseWriter, request *http.Request) {
+	s := this.status()
+	if request.FormValue("format") == "json" {
+		bts, _ := json.Marshal(s)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(bts)))
+		w.Write(bts)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	statusTemplate.Execute(w, s)
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"github.com/ctripcorp/nephele/imgsvr/storage/nfs"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+)
+
+//Local stands in for fdfs and nfs with the images of a directory, so that a
+//replay gives the same results offline. Path is served by the file of the
+//same relative path in Dir if there is one, otherwise by a sample of Dir
+//picked by the hash of Path.
+type Local struct {
+	Dir  string
+	Path string
+}
+
+//dir: sample files, listed once
+var (
+	samples      = make(map[string][]string)
+	samplesMutex sync.Mutex
+)
+
+func (this *Local) GetImage() ([]byte, error) {
+	if bts, err := ioutil.ReadFile(filepath.Join(this.Dir, filepath.Clean("/"+this.Path))); err == nil {
+		return bts, nil
+	}
+	files, err := localSamples(this.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nfs.FileNotExistError(this.Path)
+	}
+	return ioutil.ReadFile(files[crc32.ChecksumIEEE([]byte(this.Path))%uint32(len(files))])
+}
+
+//localSamples lists the regular files directly in dir, ReadDir sorts them
+//by name
+func localSamples(dir string) ([]string, error) {
+	samplesMutex.Lock()
+	defer samplesMutex.Unlock()
+	if files, ok := samples[dir]; ok {
+		return files, nil
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, info := range infos {
+		if info.Mode().IsRegular() && info.Name()[0] != '.' {
+			files = append(files, filepath.Join(dir, info.Name()))
+		}
+	}
+	samples[dir] = files
+	return files, nil
+}
@@ -1,9 +1,9 @@
 package storage
 
 import (
-	cat "github.com/ctripcorp/cat.go"
 	"github.com/ctripcorp/nephele/fdfs"
 	"github.com/ctripcorp/nephele/imgsvr/storage/nfs"
+	"github.com/ctripcorp/nephele/telemetry"
 	"strconv"
 )
 
@@ -15,29 +15,44 @@ type Fdfs struct {
 	Path          string
 	TrackerDomain string
 	Port          int
-	Cat           cat.Cat
+	Telemetry     telemetry.Telemetry
 }
 
 var client fdfs.FdfsClient = nil
 var lock chan int = make(chan int, 1)
 var initialized bool = false
 
-func (this *Fdfs) GetImage() ([]byte, error) {
-	if client == nil {
-		lock <- 0
-		if !initialized {
-			if client == nil {
-				var e error
-				client, e = fdfs.NewFdfsClient([]string{this.TrackerDomain}, strconv.Itoa(this.Port))
-				if e != nil {
-					return nil, e
-				}
-			}
-			initialized = true
+//getClient returns the fdfs client of the process, it is created by the
+//first call
+func getClient(trackerDomain string, port int) (fdfs.FdfsClient, error) {
+	lock <- 0
+	defer func() { <-lock }()
+	if !initialized {
+		c, e := fdfs.NewFdfsClient([]string{trackerDomain}, strconv.Itoa(port))
+		if e != nil {
+			return nil, e
 		}
-		<-lock
+		client = c
+		initialized = true
+	}
+	return client, nil
+}
+
+//FdfsActiveTest tests the tracker connections of the fdfs client
+func FdfsActiveTest(trackerDomain string, port int) error {
+	c, err := getClient(trackerDomain, port)
+	if err != nil {
+		return err
+	}
+	return c.ActiveTest()
+}
+
+func (this *Fdfs) GetImage() ([]byte, error) {
+	client, err := getClient(this.TrackerDomain, this.Port)
+	if err != nil {
+		return nil, err
 	}
-	bts, err := client.DownloadToBuffer(this.Path, this.Cat)
+	bts, err := client.DownloadToBuffer(this.Path, this.Telemetry)
 	if err != nil {
 		return nil, err
 	} else {
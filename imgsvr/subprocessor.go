@@ -1,21 +1,35 @@
 package imgsvr
 
 import (
+	"context"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/ctripcorp/nephele/imgsvr/data"
+	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/imgsvr/metrics"
 	"github.com/ctripcorp/nephele/util"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+//image requests served by the worker, posted to the daemon which recycles
+//the worker after recyclerequests and shows them on its status page
+var (
+	servedRequests   int64
+	failedRequests   int64
+	inFlightRequests int64
+)
+
 type SubProcessor struct {
 	Port     string
 	HostPort string
+	server   *http.Server
 }
 
 func (this *SubProcessor) Run() {
@@ -26,38 +40,110 @@ func (this *SubProcessor) Run() {
 				"workerPort": this.Port,
 				"type":       "Worker.RunPanic",
 			}).Error(fmt.Sprintf("%v", p))
-			LogErrorEvent(CatInstance, "Worker.RunPanic", fmt.Sprintf("%v", p))
+			LogErrorEvent(TelemetryInstance, "Worker.RunPanic", fmt.Sprintf("%v", p))
 		}
 	}()
 	WorkerPort = this.Port
-	LogEvent(CatInstance, Reboot, JoinString(GetIP(), ":", this.Port), nil)
+	LogEvent(TelemetryInstance, Reboot, JoinString(GetIP(), ":", this.Port), nil)
+	for _, e := range data.LoadErrors() {
+		log.WithFields(log.Fields{
+			"workerPort": this.Port,
+			"type":       "Config.KeyError",
+		}).Warn(e.Error())
+		LogErrorEvent(TelemetryInstance, "Config.KeyError", e.Error())
+	}
+	this.setMagickLimits()
+	if source := data.CurrentSource(); source != nil {
+		LogEvent(TelemetryInstance, "Config.Version", data.Current().Version, map[string]string{"source": source.String()})
+	}
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go reloadOnSignal(hup, nil)
+	go watchConfig()
 
+	this.server = &http.Server{Addr: ":" + this.Port}
 	go CycleHandleImage()
 	go this.listenHttp()
 	if this.HostPort != "" {
 		go this.sendStatus()
 	}
 	<-c
+	this.drain()
 	os.Exit(0)
 }
+
+//setMagickLimits applies the GraphicsMagick limits of the config
+func (this *SubProcessor) setMagickLimits() {
+	config := data.Current().Worker
+	limits := []struct {
+		resource string
+		limit    int64
+	}{
+		{"memory", int64(config.MagickMemory) * 1024 * 1024},
+		{"map", int64(config.MagickMap) * 1024 * 1024},
+		{"disk", int64(config.MagickDisk) * 1024 * 1024},
+		{"threads", int64(config.MagickThreads)},
+	}
+	for _, l := range limits {
+		if l.limit == 0 {
+			continue
+		}
+		if err := img4g.SetResourceLimit(l.resource, l.limit); err != nil {
+			log.WithFields(log.Fields{
+				"workerPort": this.Port,
+				"type":       "Worker.MagickLimitError",
+			}).Error(err.Error())
+			LogErrorEvent(TelemetryInstance, "Worker.MagickLimitError", err.Error())
+		}
+	}
+}
+
+//drain stops accepting connections and waits for the requests in flight,
+//and so for the tasks they queued, up to draintimeout
+func (this *SubProcessor) drain() {
+	timeout := time.Duration(data.Current().DrainTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	if err := this.server.Shutdown(ctx); err != nil {
+		msg := JoinString(err.Error(), ", queued tasks: ", strconv.Itoa(len(taskChan)))
+		log.WithFields(log.Fields{
+			"workerPort": this.Port,
+			"type":       "Worker.DrainTimeout",
+		}).Error(msg)
+		LogErrorEvent(TelemetryInstance, "Worker.DrainTimeout", msg)
+		return
+	}
+	log.WithFields(log.Fields{
+		"workerPort": this.Port,
+		"duration":   time.Since(start).String(),
+	}).Info("worker drained")
+}
 func (this *SubProcessor) listenHttp() {
 	handler := &Handler{}
 	http.Handle("/images/", handler)
+	http.Handle("/explain/images/", &ExplainHandler{})
+	http.Handle("/info/images/", &InfoHandler{})
+	http.Handle("/sprite/", &SpriteHandler{})
+	metrics.RegisterRuntime(metrics.Default)
+	http.Handle("/metrics", metrics.Handler(metrics.Default))
 	http.HandleFunc("/heartbeat/", this.handleHeartbeart)
+	http.Handle("/healthz", &HealthzHandler{})
+	http.Handle("/readyz", &ReadyzHandler{})
 	http.HandleFunc("/reload/", this.reload)
 	log.WithFields(log.Fields{
 		"port": this.Port,
 	}).Debug("http start port")
-	err := http.ListenAndServe(":"+this.Port, nil)
-	if err != nil {
+	err := this.server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		panic(err)
 	}
 }
 
 func (this *SubProcessor) reload(w http.ResponseWriter, request *http.Request) {
-	err := data.Reload()
+	err := reloadConfig("http")
 	var value string = "1"
 	w.Header().Set("Connection", "keep-alive")
 	if err != nil {
@@ -82,7 +168,7 @@ func (this *SubProcessor) sendStatus() {
 				"port": this.Port,
 				"type": "Worker.SendStatusPanic",
 			}).Error(fmt.Sprintf("%v", p))
-			LogErrorEvent(CatInstance, "Worker.SendStatusPanic", fmt.Sprintf("%v", p))
+			LogErrorEvent(TelemetryInstance, "Worker.SendStatusPanic", fmt.Sprintf("%v", p))
 			this.sendStatus()
 
 		}
@@ -97,6 +183,10 @@ func (this *SubProcessor) sendStatus() {
 		status := util.GetStatus()
 		data := url.Values{}
 		data.Add("port", this.Port)
+		data.Add("Requests", strconv.FormatInt(atomic.LoadInt64(&servedRequests), 10))
+		data.Add("Failed", strconv.FormatInt(atomic.LoadInt64(&failedRequests), 10))
+		data.Add("InFlight", strconv.FormatInt(atomic.LoadInt64(&inFlightRequests), 10))
+		data.Add("Queue", strconv.Itoa(len(taskChan)))
 		for k, v := range status {
 			data.Add(k, v)
 		}
@@ -107,7 +197,7 @@ func (this *SubProcessor) sendStatus() {
 				"port": this.Port,
 				"type": "Worker.SendStatusError",
 			}).Error(err.Error())
-			LogErrorEvent(CatInstance, "Worker.SendStatusError", err.Error())
+			LogErrorEvent(TelemetryInstance, "Worker.SendStatusError", err.Error())
 		}
 	}
 }
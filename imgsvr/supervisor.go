@@ -0,0 +1,402 @@
+package imgsvr
+
+import (
+	"bufio"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"github.com/ctripcorp/nephele/imgsvr/data"
+	"github.com/ctripcorp/nephele/util"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	//a worker is restarted after a backoff doubling from minBackoff to
+	//maxBackoff, the backoff is reset once the worker has run for stableRun
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+	stableRun  = time.Minute
+	//a worker exiting crashLoopExits times within crashLoopWindow is in a
+	//crash loop, it is restarted after maxBackoff
+	crashLoopExits  = 5
+	crashLoopWindow = 5 * time.Minute
+	//a stopping worker is killed if it is still running stopMargin after
+	//its draintimeout
+	stopMargin = 5 * time.Second
+	//how long a rolling restart waits for a new worker to be ready
+	readyWait = time.Minute
+)
+
+type workerProcess struct {
+	port string
+	//nil if the worker isn't running
+	process   *os.Process
+	started   time.Time
+	backoff   time.Duration
+	exits     []time.Time
+	crashLoop bool
+	//replaced by a rolling restart, the monitor leaves it alone
+	restarting bool
+	//requests served by the running process, from its heartbeat
+	requests int64
+	restarts int
+	//ready, unready or unreachable by the monitor
+	health string
+	stats  workerStats
+}
+
+var (
+	//worker port: the worker process
+	workers      = make(map[string]*workerProcess)
+	workersMutex sync.Mutex
+	//workers aren't restarted once the daemon is stopping
+	stopping bool
+	//1 while a rolling restart runs
+	rolling int32
+)
+
+//startWorkerProcess runs the daemon's own binary as the worker of port, the
+//worker is reaped and restarted when it exits
+func (this *HostProcessor) startWorkerProcess(port string) {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	if stopping {
+		return
+	}
+	w := workers[port]
+	if w == nil {
+		w = &workerProcess{port: port, backoff: minBackoff}
+		workers[port] = w
+	}
+	if w.process != nil {
+		return
+	}
+	args := append([]string{"worker", "-port=" + port, "-hostport=" + hostPort}, this.Args...)
+	cmd := exec.Command(os.Args[0], args...)
+	stdout, err := cmd.StdoutPipe()
+	var stderr io.ReadCloser
+	if err == nil {
+		stderr, err = cmd.StderrPipe()
+	}
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"port":     port,
+			"hostPort": hostPort,
+			"type":     "DaemonProcess.StartWorkerError",
+		}).Error(err.Error())
+		LogErrorEvent(TelemetryInstance, "DaemonProcess.StartWorkerError", err.Error())
+		this.scheduleRestart(w)
+		return
+	}
+	if !w.started.IsZero() {
+		w.restarts++
+	}
+	w.process, w.started, w.requests = cmd.Process, time.Now(), 0
+	w.health, w.stats = "starting", workerStats{}
+	log.WithFields(log.Fields{
+		"port": port,
+		"pid":  cmd.Process.Pid,
+	}).Info("worker started")
+	go this.reapWorker(w, cmd, stdout, stderr)
+}
+
+//reapWorker logs the output of a worker, waits for it to exit and restarts it
+func (this *HostProcessor) reapWorker(w *workerProcess, cmd *exec.Cmd, stdout, stderr io.Reader) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		logWorkerOutput(w.port, "stdout", stdout)
+		wg.Done()
+	}()
+	go func() {
+		logWorkerOutput(w.port, "stderr", stderr)
+		wg.Done()
+	}()
+	//the pipes must be read before Wait closes them
+	wg.Wait()
+	status := "exit status 0"
+	if err := cmd.Wait(); err != nil {
+		status = err.Error()
+	}
+
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	w.process = nil
+	if stopping || w.restarting {
+		return
+	}
+	log.WithFields(log.Fields{
+		"port": w.port,
+		"pid":  cmd.Process.Pid,
+		"type": "DaemonProcess.WorkerExited",
+	}).Error(status)
+	LogErrorEvent(TelemetryInstance, "DaemonProcess.WorkerExited", JoinString(w.port, ": ", status))
+	this.scheduleRestart(w)
+}
+
+//scheduleRestart starts the worker again after its backoff, workersMutex
+//must be held
+func (this *HostProcessor) scheduleRestart(w *workerProcess) {
+	now := time.Now()
+	if !w.started.IsZero() && now.Sub(w.started) >= stableRun {
+		w.backoff = minBackoff
+	}
+	exits := w.exits[:0]
+	for _, t := range w.exits {
+		if now.Sub(t) < crashLoopWindow {
+			exits = append(exits, t)
+		}
+	}
+	w.exits = append(exits, now)
+	if len(w.exits) >= crashLoopExits {
+		if !w.crashLoop {
+			msg := JoinString(w.port, ": ", strconv.Itoa(len(w.exits)), " exits in ", crashLoopWindow.String())
+			log.WithFields(log.Fields{
+				"port": w.port,
+				"type": "DaemonProcess.WorkerCrashLoop",
+			}).Error(msg)
+			LogErrorEvent(TelemetryInstance, "DaemonProcess.WorkerCrashLoop", msg)
+		}
+		w.crashLoop = true
+		w.backoff = maxBackoff
+	} else {
+		w.crashLoop = false
+	}
+	delay := w.backoff
+	if w.backoff *= 2; w.backoff > maxBackoff {
+		w.backoff = maxBackoff
+	}
+	log.WithFields(log.Fields{
+		"port":  w.port,
+		"delay": delay.String(),
+	}).Info("worker restart scheduled")
+	time.AfterFunc(delay, func() { this.startWorkerProcess(w.port) })
+}
+
+//killWorker kills a worker which doesn't respond or isn't ready, it is
+//restarted once reaped
+func killWorker(port string) error {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	w := workers[port]
+	if w == nil || w.process == nil {
+		return nil
+	}
+	return w.process.Kill()
+}
+
+//signalWorkers forwards sig to the running workers
+func signalWorkers(sig os.Signal) {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	for _, w := range workers {
+		if w.process != nil {
+			w.process.Signal(sig)
+		}
+	}
+}
+
+//stopWorkers forwards sig to the workers and waits for them to exit
+func stopWorkers(sig os.Signal) {
+	workersMutex.Lock()
+	stopping = true
+	workersMutex.Unlock()
+	signalWorkers(sig)
+	if !waitWorkers(func() bool { return runningWorkers() == 0 }, drainTimeout()+stopMargin) {
+		log.WithFields(log.Fields{
+			"type": "DaemonProcess.StopTimeout",
+		}).Error(JoinString(strconv.Itoa(runningWorkers()), " workers still running"))
+	}
+}
+
+func runningWorkers() int {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	n := 0
+	for _, w := range workers {
+		if w.process != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func drainTimeout() time.Duration {
+	return time.Duration(data.Current().DrainTimeout) * time.Second
+}
+
+//waitWorkers polls done until it is true or timeout
+func waitWorkers(done func() bool, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if done() {
+			return true
+		}
+	}
+	return done()
+}
+
+//RollingRestart replaces the workers one at a time so that a new binary or
+//config is picked up without failing requests. The next worker is only
+//replaced once the previous one is ready.
+func (this *HostProcessor) RollingRestart() error {
+	if !atomic.CompareAndSwapInt32(&rolling, 0, 1) {
+		return errors.New("a rolling restart is running")
+	}
+	defer atomic.StoreInt32(&rolling, 0)
+	for _, port := range workerPorts {
+		if err := this.restartWorker(port); err != nil {
+			return errors.New(JoinString(port, ": ", err.Error()))
+		}
+		log.WithFields(log.Fields{
+			"port": port,
+		}).Info("worker replaced")
+	}
+	return nil
+}
+
+//restartWorker takes the worker out of rotation, drains it, starts it again
+//and waits until it is ready and back in rotation
+func (this *HostProcessor) restartWorker(port string) error {
+	setRestarting(port, true)
+	//the monitor updates the rotation once per cycle
+	waitMonitorCycle()
+	if err := drainWorker(port); err != nil {
+		setRestarting(port, false)
+		return err
+	}
+	this.startWorkerProcess(port)
+	var err error
+	waitWorkers(func() bool {
+		_, err = getReadiness(port)
+		return err == nil
+	}, readyWait)
+	setRestarting(port, false)
+	if err != nil {
+		return err
+	}
+	waitMonitorCycle()
+	return nil
+}
+
+//recycleReason tells why a running worker should be recycled, it is empty
+//if the worker is within recyclerss and recyclerequests
+func recycleReason(port string) string {
+	config := data.Current().Worker
+	workersMutex.Lock()
+	w := workers[port]
+	if w == nil || w.process == nil || w.restarting {
+		workersMutex.Unlock()
+		return ""
+	}
+	pid, requests := w.process.Pid, w.requests
+	workersMutex.Unlock()
+	if config.RecycleRequests > 0 && requests >= int64(config.RecycleRequests) {
+		return JoinString("served ", strconv.FormatInt(requests, 10), " requests")
+	}
+	if config.RecycleRSS > 0 {
+		rss, err := util.ProcessRSS(pid)
+		if err == nil && rss > int64(config.RecycleRSS)*1024*1024 {
+			return JoinString("rss ", strconv.FormatInt(rss/1024/1024, 10), "MB")
+		}
+	}
+	return ""
+}
+
+//recycleWorker replaces a worker like a rolling restart does, one worker
+//at a time and not while a rolling restart runs
+func (this *HostProcessor) recycleWorker(port, reason string) {
+	if !atomic.CompareAndSwapInt32(&rolling, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&rolling, 0)
+	log.WithFields(log.Fields{
+		"port":   port,
+		"reason": reason,
+	}).Info("recycle worker")
+	LogEvent(TelemetryInstance, "DaemonProcess.RecycleWorker", JoinString(port, ": ", reason), nil)
+	if err := this.restartWorker(port); err != nil {
+		log.WithFields(log.Fields{
+			"port": port,
+			"type": "DaemonProcess.RecycleWorkerError",
+		}).Error(err.Error())
+		LogErrorEvent(TelemetryInstance, "DaemonProcess.RecycleWorkerError", err.Error())
+	}
+}
+
+func setRestarting(port string, restarting bool) {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	if w := workers[port]; w != nil {
+		w.restarting = restarting
+	}
+}
+
+func isRestarting(port string) bool {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	w := workers[port]
+	return w != nil && w.restarting
+}
+
+//drainWorker stops a worker by SIGTERM and waits until it is reaped, it is
+//killed if it doesn't exit after draintimeout
+func drainWorker(port string) error {
+	workersMutex.Lock()
+	w := workers[port]
+	if w == nil || w.process == nil {
+		workersMutex.Unlock()
+		return nil
+	}
+	err := w.process.Signal(syscall.SIGTERM)
+	workersMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	reaped := func() bool {
+		workersMutex.Lock()
+		defer workersMutex.Unlock()
+		return w.process == nil
+	}
+	if waitWorkers(reaped, drainTimeout()+stopMargin) {
+		return nil
+	}
+	if err = killWorker(port); err != nil {
+		return err
+	}
+	if !waitWorkers(reaped, stopMargin) {
+		return errors.New("worker doesn't exit")
+	}
+	return nil
+}
+
+//logWorkerOutput writes each line of a worker's output to the log with the
+//worker port as prefix
+func logWorkerOutput(port, stream string, r io.Reader) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			if line[len(line)-1] == '\n' {
+				line = line[:len(line)-1]
+			}
+			entry := log.WithFields(log.Fields{"stream": stream})
+			if stream == "stderr" {
+				entry.Warn(JoinString("[", port, "] ", line))
+			} else {
+				entry.Info(JoinString("[", port, "] ", line))
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
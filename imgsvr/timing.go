@@ -0,0 +1,179 @@
+package imgsvr
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ctripcorp/nephele/imgsvr/data"
+	"github.com/ctripcorp/nephele/imgsvr/img4g"
+	"github.com/ctripcorp/nephele/imgsvr/proc"
+	"github.com/ctripcorp/nephele/util"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	//request header carrying a token made by util.SignToken with debugsecret
+	DebugHeader = "X-Nephele-Debug"
+	//request header, "json" adds the timings as json in TimingHeader
+	DebugFormatHeader = "X-Nephele-Debug-Format"
+	TimingHeader      = "X-Nephele-Timing"
+)
+
+//requestTiming collects the timings of a request for metrics, and for the
+//response headers of a debug request. The processing fields are written by
+//the image goroutine before it responds on rspChan.
+type requestTiming struct {
+	//the request carries a valid debug token
+	debug bool
+	//the access log if the request is sampled, nil otherwise
+	access     *accessLogger
+	fetched    bool
+	encoded    bool
+	enqueued   time.Time
+	storage    time.Duration
+	queue      time.Duration
+	decode     time.Duration
+	processors []processorTiming
+	encode     time.Duration
+	inBytes    int
+	outBytes   int
+	inWidth    int64
+	inHeight   int64
+	outWidth   int64
+	outHeight  int64
+}
+
+type processorTiming struct {
+	name     string
+	duration time.Duration
+}
+
+func newRequestTiming(request *http.Request) *requestTiming {
+	token := request.Header.Get(DebugHeader)
+	debug := token != "" && util.VerifyToken(data.Current().DebugSecret, token, time.Now())
+	t := &requestTiming{debug: debug}
+	if accessLog.sampled() {
+		t.access = accessLog
+	}
+	return t
+}
+
+//dims reports whether the dimensions of images are read, which costs a
+//call of GraphicsMagick each
+func (t *requestTiming) dims() bool {
+	return t.debug || t.access != nil
+}
+
+//the methods are no-op on nil, so tasks without timing record unconditionally
+
+func (t *requestTiming) setStorage(d time.Duration, bytes int) {
+	if t != nil {
+		t.storage, t.inBytes, t.fetched = d, bytes, true
+	}
+}
+
+func (t *requestTiming) setEnqueued() {
+	if t != nil {
+		t.enqueued = time.Now()
+	}
+}
+
+func (t *requestTiming) setDequeued() {
+	if t != nil {
+		t.queue = time.Since(t.enqueued)
+	}
+}
+
+func (t *requestTiming) setDecode(d time.Duration, img *img4g.Image) {
+	if t != nil {
+		t.decode = d
+		if t.dims() {
+			t.inWidth, t.inHeight = imageSize(img)
+		}
+	}
+}
+
+//observe is passed to ProcessorChain.ProcessWith, it is nil if t is nil
+func (t *requestTiming) observe() func(proc.ImageProcessor, time.Duration) {
+	if t == nil {
+		return nil
+	}
+	return func(p proc.ImageProcessor, d time.Duration) {
+		name := strings.TrimPrefix(fmt.Sprintf("%T", p), "*proc.")
+		t.processors = append(t.processors, processorTiming{name, d})
+	}
+}
+
+func (t *requestTiming) setEncode(d time.Duration, img *img4g.Image) {
+	if t != nil {
+		t.encode, t.encoded = d, true
+		if t.dims() {
+			t.outWidth, t.outHeight = imageSize(img)
+		}
+		t.outBytes = len(img.Blob)
+	}
+}
+
+func imageSize(img *img4g.Image) (int64, int64) {
+	w, _ := img.GetWidth()
+	h, _ := img.GetHeight()
+	return w, h
+}
+
+//writeHeader sets Server-Timing, and TimingHeader if json is requested. The
+//processing stages are only read if processed.
+func (t *requestTiming) writeHeader(header http.Header, request *http.Request, processed bool) {
+	if t == nil || !t.debug {
+		return
+	}
+	arr := []string{serverTiming("storage", strconv.Itoa(t.inBytes)+"B", t.storage)}
+	if processed {
+		arr = append(arr, serverTiming("queue", "", t.queue))
+		arr = append(arr, serverTiming("decode", JoinString(strconv.FormatInt(t.inWidth, 10), "x", strconv.FormatInt(t.inHeight, 10)), t.decode))
+		for i, p := range t.processors {
+			arr = append(arr, serverTiming("proc"+strconv.Itoa(i), p.name, p.duration))
+		}
+		arr = append(arr, serverTiming("encode", JoinString(strconv.FormatInt(t.outWidth, 10), "x", strconv.FormatInt(t.outHeight, 10), " ", strconv.Itoa(t.outBytes), "B"), t.encode))
+	}
+	header.Set("Server-Timing", strings.Join(arr, ", "))
+
+	if strings.ToLower(request.Header.Get(DebugFormatHeader)) == "json" {
+		m := map[string]interface{}{
+			"storageMs": milliseconds(t.storage),
+			"in":        map[string]interface{}{"bytes": t.inBytes},
+		}
+		if processed {
+			t.addProcessing(m)
+		}
+		if bts, err := json.Marshal(m); err == nil {
+			header.Set(TimingHeader, string(bts))
+		}
+	}
+}
+
+func serverTiming(name, desc string, d time.Duration) string {
+	s := name
+	if desc != "" {
+		s = JoinString(s, `;desc="`, desc, `"`)
+	}
+	return JoinString(s, ";dur=", strconv.FormatFloat(milliseconds(d), 'f', 3, 64))
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (t *requestTiming) addProcessing(m map[string]interface{}) {
+	processors := []map[string]interface{}{}
+	for _, p := range t.processors {
+		processors = append(processors, map[string]interface{}{"name": p.name, "ms": milliseconds(p.duration)})
+	}
+	m["queueMs"] = milliseconds(t.queue)
+	m["decodeMs"] = milliseconds(t.decode)
+	m["processors"] = processors
+	m["encodeMs"] = milliseconds(t.encode)
+	m["in"] = map[string]interface{}{"bytes": t.inBytes, "width": t.inWidth, "height": t.inHeight}
+	m["out"] = map[string]interface{}{"bytes": t.outBytes, "width": t.outWidth, "height": t.outHeight}
+}
@@ -4,18 +4,16 @@ import (
 	"bytes"
 	"errors"
 	log "github.com/Sirupsen/logrus"
-	cat "github.com/ctripcorp/cat.go"
 	"github.com/ctripcorp/nephele/imgsvr/data"
 	"github.com/ctripcorp/nephele/imgsvr/img4g"
 	"github.com/ctripcorp/nephele/imgsvr/proc"
 	"github.com/ctripcorp/nephele/imgsvr/storage"
+	"github.com/ctripcorp/nephele/telemetry"
 	"github.com/ctripcorp/nephele/util"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
-	"os"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
@@ -29,7 +27,6 @@ var (
 	Globalhotel = "globalhotel"
 	TG          = "tg"
 	Reboot      = "Reboot"
-	CatInstance cat.Cat
 	fdfsUrl     = util.RegexpExt{regexp.MustCompile("fd/([a-zA-Z]+)/(.*)")}
 	nfs1Url     = util.RegexpExt{regexp.MustCompile("t1/([a-zA-Z]+)/(.*)")}
 	nfs2Url     = util.RegexpExt{regexp.MustCompile("([a-zA-Z]+)/(.*)")}
@@ -41,10 +38,9 @@ var (
 	WorkerPort string
 )
 
-func init() {
-	util.InitCat()
-	CatInstance = cat.Instance()
-}
+//TelemetryInstance logs events of the process, a request uses an instance
+//of its own. It is no-op until InitTelemetry.
+var TelemetryInstance telemetry.Telemetry = telemetry.Noop
 
 //var StartPort int
 type nepheleTask struct {
@@ -53,13 +49,16 @@ type nepheleTask struct {
 	//response chan
 	rspChan chan bool
 
-	CatInstance cat.Cat
+	Telemetry telemetry.Telemetry
 
 	//if true, the task will be canceled
 	canceled bool
 
 	//use to read or set canceled
 	mutex sync.Mutex
+
+	//timings of a debug request, nil otherwise
+	timing *requestTiming
 }
 
 func (nt *nepheleTask) SetCanceled() {
@@ -107,8 +106,7 @@ func ParseUri(path string) (string, string, string) {
 }
 
 func getTargetDir(channel, storagetype string) string {
-	dir, _ := data.GetDirPath(channel, storagetype)
-	return dir
+	return data.Current().Channel(channel).DirPath(storagetype)
 }
 
 func JoinString(args ...string) string {
@@ -119,20 +117,28 @@ func JoinString(args ...string) string {
 	return buf.String()
 }
 
-func GetStorage(storageType string, path string, Cat cat.Cat) (storage.Storage, error) {
+//directory of the images standing in for every storage, see SetLocalStorage
+var localStorage string
+
+//SetLocalStorage makes GetStorage serve every storage type from the images
+//of dir, so that replays are reproducible offline. Empty disables it.
+func SetLocalStorage(dir string) {
+	localStorage = dir
+}
+
+func GetStorage(storageType string, path string, t telemetry.Telemetry) (storage.Storage, error) {
 	var srg storage.Storage
+	if localStorage != "" && (storageType == "FastDFS" || storageType == "NFS") {
+		return &storage.Local{Dir: localStorage, Path: path}, nil
+	}
 	switch storageType {
 	case "FastDFS":
-		domain, err := data.GetFdfsDomain()
-		if err != nil {
-			return nil, err
-		}
-		port := data.GetFdfsPort()
+		policy := data.Current()
 		srg = &storage.Fdfs{
 			Path:          path,
-			TrackerDomain: domain,
-			Port:          port,
-			Cat:           Cat,
+			TrackerDomain: policy.FdfsDomain,
+			Port:          policy.FdfsPort,
+			Telemetry:     t,
 		}
 	case "NFS":
 		srg = &storage.Nfs{path}
@@ -142,8 +148,8 @@ func GetStorage(storageType string, path string, Cat cat.Cat) (storage.Storage,
 	}
 	return srg, nil
 }
-func GetImage(storageType string, path string, Cat cat.Cat) ([]byte, error) {
-	srg, err := GetStorage(storageType, path, Cat)
+func GetImage(storageType string, path string, t telemetry.Telemetry) ([]byte, error) {
+	srg, err := GetStorage(storageType, path, t)
 	if err != nil {
 		return nil, err
 	}
@@ -208,28 +214,6 @@ func PostHttp(uri string, data url.Values) ([]byte, error) {
 	return bts, nil
 }
 
-func KillProcessByPort(port string) error {
-	cmd := exec.Command("sh", "-c", JoinString("lsof -i:", port, "|grep LISTEN|awk '{print $2}'"))
-	//	cmd.Env = append(cmd.Env, os.Getenv("PATH"))
-	bts, err := cmd.Output()
-	if err != nil {
-		return err
-	}
-	pid := strings.TrimSpace(string(bts))
-	if pid == "" {
-		return nil
-	}
-	id, err := strconv.Atoi(pid)
-	if err != nil {
-		return err
-	}
-	p, err := os.FindProcess(id)
-	if err != nil {
-		return err
-	}
-	return p.Kill()
-}
-
 func GetImageSizeDistribution(size int) string {
 	switch {
 	case size < 0:
@@ -257,22 +241,27 @@ func GetImageSizeDistribution(size int) string {
 	}
 }
 
-func LogErrorEvent(cat cat.Cat, name string, err string) {
-	event := cat.NewEvent("Error", name)
-	event.AddData("detail", err)
-	event.SetStatus("ERROR")
-	event.Complete()
+func LogErrorEvent(t telemetry.Telemetry, name string, err string) {
+	telemetry.LogErrorEvent(t, name, err)
 }
 
-func LogEvent(cat cat.Cat, title string, name string, data map[string]string) {
-	event := cat.NewEvent(title, name)
-	if data != nil {
-		for k, v := range data {
-			event.AddData(k, v)
-		}
+func LogEvent(t telemetry.Telemetry, title string, name string, data map[string]string) {
+	telemetry.LogEvent(t, title, name, data)
+}
+
+//InitTelemetry selects the telemetry backend configured by key telemetry,
+//config carries the service.name of traces and the CAT settings given on the
+//command line
+func InitTelemetry(config telemetry.Config) error {
+	policy := data.Current()
+	config.Backend, config.Endpoint = policy.Telemetry, policy.OtlpEndpoint
+	config.Env = util.GetRunningEnv()
+	err := telemetry.Init(config)
+	if err != nil {
+		return err
 	}
-	event.SetStatus("0")
-	event.Complete()
+	TelemetryInstance = telemetry.Instance()
+	return nil
 }
 
 //log error with logging fields uri
@@ -12,6 +12,7 @@ import (
 	_ "github.com/ctripcorp/nephele/image/webp"
 	"github.com/ctripcorp/nephele/imgws/models"
 	"github.com/ctripcorp/nephele/util"
+	"github.com/ctripcorp/nephele/util/placeholder"
 	"github.com/ctripcorp/nephele/util/soapparse"
 	"github.com/ctripcorp/nephele/util/soapparse/request"
 	"github.com/ctripcorp/nephele/util/soapparse/response"
@@ -47,7 +48,8 @@ func (this ImageRequest) Save(r *request.SaveRequest) (response.SaveResponse, ut
 	if err := this.checkSaveRequest(r); err.Err != nil {
 		return response.SaveResponse{}, err
 	}
-	if err := this.checkSaveCheckItem(r); err.Err != nil {
+	img, err := this.checkSaveCheckItem(r)
+	if err.Err != nil {
 		return response.SaveResponse{}, err
 	}
 	storage, storageType := NewStorage(this.Cat)
@@ -58,6 +60,10 @@ func (this ImageRequest) Save(r *request.SaveRequest) (response.SaveResponse, ut
 	tableZone := sharding()
 	channel := models.Channel{Cat: this.Cat}
 	imgIndex := models.ImageIndex{ChannelCode: channel.GetChannelCode(r.Channel), StoragePath: path, StorageType: storageType, TableZone: tableZone, Cat: this.Cat}
+	if img != nil {
+		p := placeholder.Make(img)
+		imgIndex.BlurHash, imgIndex.DominantColor, imgIndex.AverageColor = p.BlurHash, p.DominantColor, p.AverageColor
+	}
 	plan := ""
 	if r.Process.AnyTypes != nil && len(r.Process.AnyTypes) > 0 {
 		bts, err := r.Process.MarshalJSON()
@@ -123,37 +129,40 @@ func (this ImageRequest) checkSaveRequest(r *request.SaveRequest) util.Error {
 	return util.Error{}
 }
 
-func (this ImageRequest) checkSaveCheckItem(r *request.SaveRequest) util.Error {
+//checkSaveCheckItem returns the decoded image, which is nil for svg
+func (this ImageRequest) checkSaveCheckItem(r *request.SaveRequest) (image.Image, util.Error) {
 	t := "CheckFail"
+	var img image.Image
 	//if r.CheckItem == nil {
 	//	return util.Error{}
 	//}
 	if r.CheckItem.IsOtherImage {
 		if !isSvg(r.FileBytes) {
 			util.LogEvent(this.Cat, t, "FormatInvalid", map[string]string{"detail": "image isn't svg!"})
-			return util.Error{IsNormal: true, Err: errors.New("image isn't svg!"), Type: t}
+			return nil, util.Error{IsNormal: true, Err: errors.New("image isn't svg!"), Type: t}
 		}
 	} else {
-		img, _, err := image.Decode(bytes.NewReader(r.FileBytes))
+		var err error
+		img, _, err = image.Decode(bytes.NewReader(r.FileBytes))
 		if err != nil {
 			util.LogEvent(this.Cat, t, "FormatInvalid", map[string]string{"detail": err.Error()})
-			return util.Error{IsNormal: true, Err: err, Type: t}
+			return nil, util.Error{IsNormal: true, Err: err, Type: t}
 		}
 		//todo check img format
 		if r.CheckItem.MinWidth > 0 && r.CheckItem.MinWidth > img.Bounds().Dx() {
 			util.LogEvent(this.Cat, t, "LessMinWidth", map[string]string{"detail": util.JoinString("MinWidth:"+strconv.Itoa(r.CheckItem.MinWidth), " ImageWidth:", strconv.Itoa(img.Bounds().Dx()))})
-			return util.Error{IsNormal: true, Err: errors.New("image width is less minwidth!"), Type: t}
+			return nil, util.Error{IsNormal: true, Err: errors.New("image width is less minwidth!"), Type: t}
 		}
 		if r.CheckItem.MinHeight > 0 && r.CheckItem.MinHeight > img.Bounds().Dy() {
 			util.LogEvent(this.Cat, t, "LessMinHeight", map[string]string{"detail": util.JoinString("MinHeight:"+strconv.Itoa(r.CheckItem.MinHeight), " ImageHeight:", strconv.Itoa(img.Bounds().Dy()))})
-			return util.Error{IsNormal: true, Err: errors.New("image heigth is less minheight!"), Type: t}
+			return nil, util.Error{IsNormal: true, Err: errors.New("image heigth is less minheight!"), Type: t}
 		}
 	}
 	if r.CheckItem.MaxBytes > 0 && int(r.CheckItem.MaxBytes) < len(r.FileBytes) {
 		util.LogEvent(this.Cat, t, "BeyondMaxSize", map[string]string{"detail": util.JoinString("MaxSize:"+strconv.Itoa(int(r.CheckItem.MaxBytes)), " ImageSize:", strconv.Itoa(len(r.FileBytes)))})
-		return util.Error{IsNormal: true, Err: errors.New("image size beyond max size"), Type: t}
+		return nil, util.Error{IsNormal: true, Err: errors.New("image size beyond max size"), Type: t}
 	}
-	return util.Error{Err: nil, IsNormal: true}
+	return img, util.Error{Err: nil, IsNormal: true}
 }
 
 func isSvg(bts []byte) bool {
@@ -42,13 +42,13 @@ func TestCheckSaveCheckItem(t *testing.T) {
 	//check issvg
 	r.SaveRequest.CheckItem.IsOtherImage = true
 	imgRequest := ImageRequest{}
-	e := imgRequest.checkSaveCheckItem(&r.SaveRequest)
+	_, e := imgRequest.checkSaveCheckItem(&r.SaveRequest)
 	if e.Err == nil {
 		t.Error("issvg check fail")
 	}
 
 	r.SaveRequest.CheckItem.IsOtherImage = false
-	e = imgRequest.checkSaveCheckItem(&r.SaveRequest)
+	_, e = imgRequest.checkSaveCheckItem(&r.SaveRequest)
 	if e.Err != nil {
 		t.Error(e.Err)
 	}
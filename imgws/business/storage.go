@@ -4,6 +4,7 @@ import (
 	cat "github.com/ctripcorp/cat.go"
 	"github.com/ctripcorp/nephele/fdfs"
 	"github.com/ctripcorp/nephele/imgws/models"
+	"github.com/ctripcorp/nephele/telemetry"
 	"github.com/ctripcorp/nephele/util"
 	"io/ioutil"
 	"os/exec"
@@ -148,7 +149,7 @@ func (this *FdfsStorage) Download() ([]byte, util.Error) {
 			tran.Complete()
 		}()
 	}
-	bts, err = fdfsClient.DownloadToBuffer(this.Path, this.Cat)
+	bts, err = fdfsClient.DownloadToBuffer(this.Path, telemetry.FromCat(this.Cat))
 	if err != nil {
 		util.LogErrorEvent(this.Cat, ERRORTYPE_FDFSDOWNLOADERR, err.Error())
 		result = util.Error{IsNormal: false, Err: err, Type: ERRORTYPE_FDFSDOWNLOADERR}
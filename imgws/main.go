@@ -8,12 +8,13 @@ import (
 
 	cat "github.com/ctripcorp/cat.go"
 	_ "github.com/ctripcorp/nephele/imgws/routers"
+	"github.com/ctripcorp/nephele/telemetry"
 	"github.com/ctripcorp/nephele/util"
 )
 
 func ConfigCat() {
-	cat.CAT_HOST = cat.UAT
-	cat.DOMAIN = "900408"
+	//sets cat.CAT_HOST and cat.DOMAIN, fdfs logs by telemetry
+	telemetry.Init(telemetry.Config{Backend: "cat", Env: "uat", Domain: "900408"})
 	cat.TEMPFILE = ".cat"
 }
 
@@ -32,7 +32,12 @@ type ImageIndex struct {
 	PartitionKey int16
 	TableZone    int
 	Version      string
-	Cat          cat.Cat
+	//placeholder of the image, see util/placeholder, empty if the image
+	//isn't decodable
+	BlurHash      string
+	DominantColor string
+	AverageColor  string
+	Cat           cat.Cat
 }
 
 func getDBString(tableZone int) string {
@@ -65,7 +70,7 @@ func (this *ImageIndex) SaveToDB(plan string) util.Error {
 	o.Using(getDBString(this.TableZone))
 	o.Begin()
 	partitionKey := util.GetPartitionKey(time.Now())
-	res, err = o.Raw("INSERT INTO `imageindex_"+strconv.Itoa(this.TableZone)+"` (`channelCode`,`storagePath`,`storageType`,`profile`,`createtime`,`partitionKey`)VALUES(?,?,?,?,NOW(),?)", this.ChannelCode, this.StoragePath, this.StorageType, this.Profile, partitionKey).Exec()
+	res, err = o.Raw("INSERT INTO `imageindex_"+strconv.Itoa(this.TableZone)+"` (`channelCode`,`storagePath`,`storageType`,`profile`,`blurHash`,`dominantColor`,`averageColor`,`createtime`,`partitionKey`)VALUES(?,?,?,?,?,?,?,NOW(),?)", this.ChannelCode, this.StoragePath, this.StorageType, this.Profile, this.BlurHash, this.DominantColor, this.AverageColor, partitionKey).Exec()
 
 	if err != nil {
 		o.Rollback()
@@ -20,6 +20,9 @@ CREATE TABLE `imageindex_1` (
   `storagePath` varchar(256) NOT NULL,
   `storageType` varchar(45) NOT NULL,
   `profile` varchar(2048) NOT NULL,
+  `blurHash` varchar(64) NOT NULL DEFAULT '',
+  `dominantColor` char(7) NOT NULL DEFAULT '',
+  `averageColor` char(7) NOT NULL DEFAULT '',
   `createTime` datetime NOT NULL,
   `partitionKey` smallint(6) NOT NULL,
   PRIMARY KEY (`id`,`partitionKey`)
@@ -43,6 +46,9 @@ CREATE TABLE `imageindex_2` (
   `storagePath` varchar(256) NOT NULL,
   `storageType` varchar(45) NOT NULL,
   `profile` varchar(2048) NOT NULL,
+  `blurHash` varchar(64) NOT NULL DEFAULT '',
+  `dominantColor` char(7) NOT NULL DEFAULT '',
+  `averageColor` char(7) NOT NULL DEFAULT '',
   `createTime` datetime NOT NULL,
   `partitionKey` smallint(6) NOT NULL,
   PRIMARY KEY (`id`,`partitionKey`)
@@ -66,6 +72,9 @@ CREATE TABLE `imageindex_3` (
   `storagePath` varchar(256) NOT NULL,
   `storageType` varchar(45) NOT NULL,
   `profile` varchar(2048) NOT NULL,
+  `blurHash` varchar(64) NOT NULL DEFAULT '',
+  `dominantColor` char(7) NOT NULL DEFAULT '',
+  `averageColor` char(7) NOT NULL DEFAULT '',
   `createTime` datetime NOT NULL,
   `partitionKey` smallint(6) NOT NULL,
   PRIMARY KEY (`id`,`partitionKey`)
@@ -89,6 +98,9 @@ CREATE TABLE `imageindex_4` (
   `storagePath` varchar(256) NOT NULL,
   `storageType` varchar(45) NOT NULL,
   `profile` varchar(2048) NOT NULL,
+  `blurHash` varchar(64) NOT NULL DEFAULT '',
+  `dominantColor` char(7) NOT NULL DEFAULT '',
+  `averageColor` char(7) NOT NULL DEFAULT '',
   `createTime` datetime NOT NULL,
   `partitionKey` smallint(6) NOT NULL,
   PRIMARY KEY (`id`,`partitionKey`)
@@ -112,6 +124,9 @@ CREATE TABLE `imageindex_5` (
   `storagePath` varchar(256) NOT NULL,
   `storageType` varchar(45) NOT NULL,
   `profile` varchar(2048) NOT NULL,
+  `blurHash` varchar(64) NOT NULL DEFAULT '',
+  `dominantColor` char(7) NOT NULL DEFAULT '',
+  `averageColor` char(7) NOT NULL DEFAULT '',
   `createTime` datetime NOT NULL,
   `partitionKey` smallint(6) NOT NULL,
   PRIMARY KEY (`id`,`partitionKey`)
@@ -135,6 +150,9 @@ CREATE TABLE `imageindex_6` (
   `storagePath` varchar(256) NOT NULL,
   `storageType` varchar(45) NOT NULL,
   `profile` varchar(2048) NOT NULL,
+  `blurHash` varchar(64) NOT NULL DEFAULT '',
+  `dominantColor` char(7) NOT NULL DEFAULT '',
+  `averageColor` char(7) NOT NULL DEFAULT '',
   `createTime` datetime NOT NULL,
   `partitionKey` smallint(6) NOT NULL,
   PRIMARY KEY (`id`,`partitionKey`)
@@ -0,0 +1,7 @@
+-- placeholder columns of the image index, for databases created before them
+ALTER TABLE `imageindex_1` ADD COLUMN `blurHash` varchar(64) NOT NULL DEFAULT '' AFTER `profile`, ADD COLUMN `dominantColor` char(7) NOT NULL DEFAULT '' AFTER `blurHash`, ADD COLUMN `averageColor` char(7) NOT NULL DEFAULT '' AFTER `dominantColor`;
+ALTER TABLE `imageindex_2` ADD COLUMN `blurHash` varchar(64) NOT NULL DEFAULT '' AFTER `profile`, ADD COLUMN `dominantColor` char(7) NOT NULL DEFAULT '' AFTER `blurHash`, ADD COLUMN `averageColor` char(7) NOT NULL DEFAULT '' AFTER `dominantColor`;
+ALTER TABLE `imageindex_3` ADD COLUMN `blurHash` varchar(64) NOT NULL DEFAULT '' AFTER `profile`, ADD COLUMN `dominantColor` char(7) NOT NULL DEFAULT '' AFTER `blurHash`, ADD COLUMN `averageColor` char(7) NOT NULL DEFAULT '' AFTER `dominantColor`;
+ALTER TABLE `imageindex_4` ADD COLUMN `blurHash` varchar(64) NOT NULL DEFAULT '' AFTER `profile`, ADD COLUMN `dominantColor` char(7) NOT NULL DEFAULT '' AFTER `blurHash`, ADD COLUMN `averageColor` char(7) NOT NULL DEFAULT '' AFTER `dominantColor`;
+ALTER TABLE `imageindex_5` ADD COLUMN `blurHash` varchar(64) NOT NULL DEFAULT '' AFTER `profile`, ADD COLUMN `dominantColor` char(7) NOT NULL DEFAULT '' AFTER `blurHash`, ADD COLUMN `averageColor` char(7) NOT NULL DEFAULT '' AFTER `dominantColor`;
+ALTER TABLE `imageindex_6` ADD COLUMN `blurHash` varchar(64) NOT NULL DEFAULT '' AFTER `profile`, ADD COLUMN `dominantColor` char(7) NOT NULL DEFAULT '' AFTER `blurHash`, ADD COLUMN `averageColor` char(7) NOT NULL DEFAULT '' AFTER `dominantColor`;
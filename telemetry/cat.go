@@ -0,0 +1,86 @@
+package telemetry
+
+import (
+	cat "github.com/ctripcorp/cat.go"
+)
+
+func newCatFactory(config Config) (func() Telemetry, error) {
+	switch {
+	case config.Host != "":
+		cat.CAT_HOST = config.Host
+	case config.Env == "prod":
+		cat.CAT_HOST = cat.PROD
+	case config.Env == "fat":
+		cat.CAT_HOST = cat.FAT
+	default:
+		cat.CAT_HOST = cat.UAT
+	}
+	cat.DOMAIN = "nephele"
+	if config.Domain != "" {
+		cat.DOMAIN = config.Domain
+	}
+	return func() Telemetry { return FromCat(cat.Instance()) }, nil
+}
+
+//FromCat adapts a cat instance, a nil instance is no-op
+func FromCat(c cat.Cat) Telemetry {
+	if c == nil {
+		return Noop
+	}
+	return &catTelemetry{c}
+}
+
+type catTelemetry struct {
+	c cat.Cat
+}
+
+func (this *catTelemetry) NewTransaction(typ, name string) Transaction {
+	return catMessage{this.c.NewTransaction(typ, name)}
+}
+
+func (this *catTelemetry) NewEvent(typ, name string) Event {
+	return catMessage{this.c.NewEvent(typ, name)}
+}
+
+func (this *catTelemetry) NewHeartbeat(typ, name string) Heartbeat {
+	h := this.c.NewHeartbeat(typ, name)
+	return catHeartbeat{catMessage{h}, h}
+}
+
+func (this *catTelemetry) LogEvent(typ, name string) {
+	this.c.LogEvent(typ, name)
+}
+
+func (this *catTelemetry) LogPanic(p interface{}) {
+	this.c.LogPanic(p)
+}
+
+//catMessage converts the status to cat.Panic
+type catMessage struct {
+	m interface {
+		AddData(string, string)
+		SetStatus(cat.Panic)
+		Complete()
+	}
+}
+
+func (this catMessage) AddData(key, value string) {
+	this.m.AddData(key, value)
+}
+
+func (this catMessage) SetStatus(status interface{}) {
+	this.m.SetStatus(status)
+}
+
+func (this catMessage) Complete() {
+	this.m.Complete()
+}
+
+type catHeartbeat struct {
+	catMessage
+	h cat.Heartbeat
+}
+
+func (this catHeartbeat) Set(group, key, value string) {
+	this.h.Set(group, key, value)
+}
@@ -0,0 +1,107 @@
+package telemetry
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+//the log backend writes messages to logrus: failed transactions and error
+//events as warnings and errors, the others as debug
+func newLogFactory(config Config) (func() Telemetry, error) {
+	return func() Telemetry { return &logTelemetry{} }, nil
+}
+
+type logTelemetry struct {
+	mutex sync.Mutex
+	stack []*logMessage
+}
+
+type logMessage struct {
+	t      *logTelemetry
+	kind   string
+	fields log.Fields
+	status string
+	start  time.Time
+	parent string
+}
+
+func (this *logTelemetry) newMessage(kind, typ, name string) *logMessage {
+	m := &logMessage{t: this, kind: kind, fields: log.Fields{"type": typ, "name": name}, status: "unset", start: time.Now()}
+	this.mutex.Lock()
+	if l := len(this.stack); l > 0 {
+		top := this.stack[l-1]
+		m.parent = top.fields["type"].(string) + "/" + top.fields["name"].(string)
+	}
+	if kind == "transaction" {
+		this.stack = append(this.stack, m)
+	}
+	this.mutex.Unlock()
+	return m
+}
+
+func (this *logTelemetry) NewTransaction(typ, name string) Transaction {
+	return this.newMessage("transaction", typ, name)
+}
+
+func (this *logTelemetry) NewEvent(typ, name string) Event {
+	return this.newMessage("event", typ, name)
+}
+
+func (this *logTelemetry) NewHeartbeat(typ, name string) Heartbeat {
+	return this.newMessage("heartbeat", typ, name)
+}
+
+func (this *logTelemetry) LogEvent(typ, name string) {
+	e := this.NewEvent(typ, name)
+	e.SetStatus("0")
+	e.Complete()
+}
+
+func (this *logTelemetry) LogPanic(p interface{}) {
+	e := this.NewEvent("Error", statusString(p))
+	e.SetStatus("ERROR")
+	e.Complete()
+}
+
+func (this *logMessage) AddData(key, value string) {
+	this.fields["data."+key] = value
+}
+
+func (this *logMessage) Set(group, key, value string) {
+	this.fields[group+"."+key] = value
+}
+
+func (this *logMessage) SetStatus(status interface{}) {
+	this.status = statusString(status)
+}
+
+func (this *logMessage) Complete() {
+	this.t.mutex.Lock()
+	for i := len(this.t.stack) - 1; i >= 0; i-- {
+		if this.t.stack[i] == this {
+			this.t.stack = append(this.t.stack[:i], this.t.stack[i+1:]...)
+			break
+		}
+	}
+	this.t.mutex.Unlock()
+
+	entry := log.WithFields(this.fields).WithField("status", this.status)
+	if this.parent != "" {
+		entry = entry.WithField("parent", this.parent)
+	}
+	switch this.kind {
+	case "transaction":
+		entry = entry.WithField("duration", time.Since(this.start).String())
+	}
+	switch {
+	case this.kind == "heartbeat":
+		entry.Info(this.kind)
+	case this.status == "0" || this.status == "unset":
+		entry.Debug(this.kind)
+	case this.kind == "event":
+		entry.Error(this.kind)
+	default:
+		entry.Warn(this.kind)
+	}
+}
@@ -0,0 +1,305 @@
+package telemetry
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//the otlp backend exports transactions as spans in the OTLP/HTTP json
+//encoding, events become span events of the open transaction. Heartbeats
+//aren't traces and are dropped.
+func newOtlpFactory(config Config) (func() Telemetry, error) {
+	if config.Endpoint == "" {
+		return nil, errors.New("telemetry: otlp endpoint is empty")
+	}
+	name := config.ServiceName
+	if name == "" {
+		name = "nephele"
+	}
+	e := newOtlpExporter(config.Endpoint, name)
+	go e.run()
+	return func() Telemetry { return &otlpTelemetry{exporter: e} }, nil
+}
+
+type otlpTelemetry struct {
+	exporter *otlpExporter
+	mutex    sync.Mutex
+	traceId  string
+	stack    []*otlpSpan
+}
+
+type otlpSpan struct {
+	t          *otlpTelemetry
+	traceId    string
+	spanId     string
+	parentId   string
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	events     []otlpEvent
+	status     string
+}
+
+type otlpEvent struct {
+	time       time.Time
+	name       string
+	attributes map[string]string
+	status     string
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (this *otlpTelemetry) NewTransaction(typ, name string) Transaction {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.traceId == "" || len(this.stack) == 0 {
+		this.traceId = randomHex(16)
+	}
+	s := &otlpSpan{
+		t:          this,
+		traceId:    this.traceId,
+		spanId:     randomHex(8),
+		name:       typ + " " + name,
+		start:      time.Now(),
+		attributes: map[string]string{"type": typ, "name": name},
+		status:     "unset",
+	}
+	if l := len(this.stack); l > 0 {
+		s.parentId = this.stack[l-1].spanId
+	}
+	this.stack = append(this.stack, s)
+	return s
+}
+
+func (this *otlpTelemetry) NewEvent(typ, name string) Event {
+	return &otlpEventMessage{t: this, event: otlpEvent{time: time.Now(), name: typ + " " + name, attributes: map[string]string{}, status: "unset"}}
+}
+
+func (this *otlpTelemetry) NewHeartbeat(typ, name string) Heartbeat {
+	return noopMessage{}
+}
+
+func (this *otlpTelemetry) LogEvent(typ, name string) {
+	e := this.NewEvent(typ, name)
+	e.SetStatus("0")
+	e.Complete()
+}
+
+func (this *otlpTelemetry) LogPanic(p interface{}) {
+	e := this.NewEvent("Error", statusString(p))
+	e.SetStatus("ERROR")
+	e.Complete()
+}
+
+func (this *otlpSpan) AddData(key, value string) {
+	this.t.mutex.Lock()
+	this.attributes["data."+key] = value
+	this.t.mutex.Unlock()
+}
+
+func (this *otlpSpan) SetStatus(status interface{}) {
+	this.t.mutex.Lock()
+	this.status = statusString(status)
+	this.t.mutex.Unlock()
+}
+
+func (this *otlpSpan) Complete() {
+	this.t.mutex.Lock()
+	this.end = time.Now()
+	for i := len(this.t.stack) - 1; i >= 0; i-- {
+		if this.t.stack[i] == this {
+			this.t.stack = append(this.t.stack[:i], this.t.stack[i+1:]...)
+			break
+		}
+	}
+	this.t.mutex.Unlock()
+	this.t.exporter.add(this)
+}
+
+//otlpEventMessage is added to the open span on Complete, it is dropped if
+//no span is open
+type otlpEventMessage struct {
+	t     *otlpTelemetry
+	event otlpEvent
+}
+
+func (this *otlpEventMessage) AddData(key, value string) {
+	this.event.attributes[key] = value
+}
+
+func (this *otlpEventMessage) SetStatus(status interface{}) {
+	this.event.status = statusString(status)
+}
+
+func (this *otlpEventMessage) Complete() {
+	this.t.mutex.Lock()
+	defer this.t.mutex.Unlock()
+	if l := len(this.t.stack); l > 0 {
+		s := this.t.stack[l-1]
+		s.events = append(s.events, this.event)
+	}
+}
+
+//otlpExporter posts completed spans in batches
+type otlpExporter struct {
+	endpoint    string
+	serviceName string
+	spans       chan *otlpSpan
+	client      *http.Client
+	interval    time.Duration
+	batchSize   int
+}
+
+func newOtlpExporter(endpoint, serviceName string) *otlpExporter {
+	return &otlpExporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		spans:       make(chan *otlpSpan, 4096),
+		client:      &http.Client{Timeout: 5 * time.Second},
+		interval:    5 * time.Second,
+		batchSize:   512,
+	}
+}
+
+//add drops the span if the exporter falls behind
+func (this *otlpExporter) add(s *otlpSpan) {
+	select {
+	case this.spans <- s:
+	default:
+	}
+}
+
+func (this *otlpExporter) run() {
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+	batch := make([]*otlpSpan, 0, this.batchSize)
+	for {
+		select {
+		case s := <-this.spans:
+			batch = append(batch, s)
+			if len(batch) < this.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := this.export(batch); err != nil {
+			log.WithFields(log.Fields{
+				"endpoint": this.endpoint,
+				"type":     "Telemetry.ExportError",
+			}).Warn(err.Error())
+		}
+		batch = make([]*otlpSpan, 0, this.batchSize)
+	}
+}
+
+func (this *otlpExporter) export(batch []*otlpSpan) error {
+	bts, err := json.Marshal(this.encode(batch))
+	if err != nil {
+		return err
+	}
+	rsp, err := this.client.Post(this.endpoint, "application/json", bytes.NewReader(bts))
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode/100 != 2 {
+		return errors.New("otlp endpoint returns " + strconv.Itoa(rsp.StatusCode))
+	}
+	return nil
+}
+
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+func otlpAttributes(m map[string]string) []otlpKeyValue {
+	arr := make([]otlpKeyValue, 0, len(m))
+	for k, v := range m {
+		kv := otlpKeyValue{Key: k}
+		kv.Value.StringValue = v
+		arr = append(arr, kv)
+	}
+	return arr
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+//encode builds an ExportTraceServiceRequest
+func (this *otlpExporter) encode(batch []*otlpSpan) map[string]interface{} {
+	spans := make([]map[string]interface{}, 0, len(batch))
+	for _, s := range batch {
+		events := make([]map[string]interface{}, 0, len(s.events))
+		for _, e := range s.events {
+			attributes := e.attributes
+			if e.status != "0" && e.status != "unset" {
+				attributes["status"] = e.status
+			}
+			events = append(events, map[string]interface{}{
+				"timeUnixNano": unixNano(e.time),
+				"name":         e.name,
+				"attributes":   otlpAttributes(attributes),
+			})
+		}
+		//STATUS_CODE_UNSET 0, OK 1, ERROR 2
+		status := map[string]interface{}{"code": 1}
+		switch s.status {
+		case "0":
+		case "unset":
+			status["code"] = 0
+		default:
+			status["code"] = 2
+			status["message"] = s.status
+		}
+		span := map[string]interface{}{
+			"traceId":           s.traceId,
+			"spanId":            s.spanId,
+			"name":              s.name,
+			"kind":              1, //SPAN_KIND_INTERNAL
+			"startTimeUnixNano": unixNano(s.start),
+			"endTimeUnixNano":   unixNano(s.end),
+			"attributes":        otlpAttributes(s.attributes),
+			"events":            events,
+			"status":            status,
+		}
+		if s.parentId != "" {
+			span["parentSpanId"] = s.parentId
+		}
+		spans = append(spans, span)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]string{"service.name": this.serviceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "github.com/ctripcorp/nephele/telemetry"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,150 @@
+//Package telemetry hides the monitoring backend (CAT, logs, OpenTelemetry
+//tracing) behind the transaction/event/heartbeat model of CAT.
+//
+//An instance is a tree like cat.Cat: transactions created while another one
+//is open become its children, so one instance should be used per request.
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+//Message is a transaction, an event or a heartbeat. Status "0" means
+//success, anything else is taken as the error.
+type Message interface {
+	AddData(key, value string)
+	SetStatus(status interface{})
+	Complete()
+}
+
+type Transaction interface {
+	Message
+}
+
+type Event interface {
+	Message
+}
+
+type Heartbeat interface {
+	Message
+	Set(group, key, value string)
+}
+
+type Telemetry interface {
+	NewTransaction(typ, name string) Transaction
+	NewEvent(typ, name string) Event
+	NewHeartbeat(typ, name string) Heartbeat
+	//LogEvent logs a successful event without data
+	LogEvent(typ, name string)
+	//LogPanic logs a recovered panic as an error event
+	LogPanic(p interface{})
+}
+
+type Config struct {
+	//cat, log, otlp or noop
+	Backend string
+	//cat: the environment selects the CAT server if Host is empty, prod,
+	//fat or uat (default)
+	Env string
+	//cat: url of the CAT server, e.g. http://cat.example.com
+	Host string
+	//cat: domain of the application, nephele by default
+	Domain string
+	//otlp: url of the OTLP/HTTP traces endpoint, e.g. http://collector:4318/v1/traces
+	Endpoint string
+	//otlp: service.name of the resource
+	ServiceName string
+}
+
+var backends = map[string]func(Config) (func() Telemetry, error){
+	"cat":  newCatFactory,
+	"log":  newLogFactory,
+	"otlp": newOtlpFactory,
+	"noop": func(Config) (func() Telemetry, error) { return func() Telemetry { return Noop }, nil },
+}
+
+//IsBackend reports whether name is a supported backend
+func IsBackend(name string) bool {
+	_, ok := backends[name]
+	return ok
+}
+
+var (
+	mutex   sync.RWMutex
+	factory = func() Telemetry { return Noop }
+)
+
+//Init selects the backend of Instance, instances are no-op until Init
+func Init(config Config) error {
+	newFactory, ok := backends[config.Backend]
+	if !ok {
+		return errors.New("telemetry: unknown backend " + config.Backend)
+	}
+	f, err := newFactory(config)
+	if err != nil {
+		return err
+	}
+	mutex.Lock()
+	factory = f
+	mutex.Unlock()
+	return nil
+}
+
+//Instance returns a new instance of the backend selected by Init
+func Instance() Telemetry {
+	mutex.RLock()
+	f := factory
+	mutex.RUnlock()
+	return f()
+}
+
+//LogErrorEvent logs an error event with detail, t may be nil
+func LogErrorEvent(t Telemetry, name string, detail string) {
+	if t == nil {
+		return
+	}
+	event := t.NewEvent("Error", name)
+	event.AddData("detail", detail)
+	event.SetStatus("ERROR")
+	event.Complete()
+}
+
+//LogEvent logs a successful event with data, t may be nil
+func LogEvent(t Telemetry, typ string, name string, data map[string]string) {
+	if t == nil {
+		return
+	}
+	event := t.NewEvent(typ, name)
+	for k, v := range data {
+		event.AddData(k, v)
+	}
+	event.SetStatus("0")
+	event.Complete()
+}
+
+func statusString(status interface{}) string {
+	if status == nil {
+		return "0"
+	}
+	return fmt.Sprintf("%v", status)
+}
+
+//Noop discards everything
+var Noop Telemetry = noop{}
+
+type noop struct{}
+
+func (noop) NewTransaction(typ, name string) Transaction { return noopMessage{} }
+func (noop) NewEvent(typ, name string) Event             { return noopMessage{} }
+func (noop) NewHeartbeat(typ, name string) Heartbeat     { return noopMessage{} }
+func (noop) LogEvent(typ, name string)                   {}
+func (noop) LogPanic(p interface{})                      {}
+
+type noopMessage struct{}
+
+func (noopMessage) AddData(key, value string)    {}
+func (noopMessage) SetStatus(status interface{}) {}
+func (noopMessage) Complete()                    {}
+func (noopMessage) Set(group, key, value string) {}
@@ -0,0 +1,92 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInit(t *testing.T) {
+	if Instance() != Noop {
+		t.Error("instance should be no-op before Init")
+	}
+	if err := Init(Config{Backend: "x"}); err == nil {
+		t.Error("unknown backend should fail")
+	}
+	if err := Init(Config{Backend: "otlp"}); err == nil {
+		t.Error("otlp without endpoint should fail")
+	}
+	if err := Init(Config{Backend: "log"}); err != nil {
+		t.Fatal(err)
+	}
+	tel := Instance()
+	tran := tel.NewTransaction("URL", "/images/tg")
+	LogEvent(tel, "URL", "URL.Method", map[string]string{"Http": "GET"})
+	LogErrorEvent(tel, "ProcessError", "detail")
+	tran.SetStatus("0")
+	tran.Complete()
+	Init(Config{Backend: "noop"})
+}
+
+func TestOtlpSpans(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	e := newOtlpExporter(server.URL, "test")
+	tel := &otlpTelemetry{exporter: e}
+	url := tel.NewTransaction("URL", "/images/tg")
+	storage := tel.NewTransaction("Storage", "FastDFS")
+	storage.SetStatus("0")
+	storage.Complete()
+	resize := tel.NewTransaction("Command", "Resize")
+	LogErrorEvent(tel, "ProcessError", "bad image")
+	resize.SetStatus("ProcessError")
+	resize.Complete()
+	url.SetStatus("0")
+	url.Complete()
+
+	batch := []*otlpSpan{}
+	for len(e.spans) > 0 {
+		batch = append(batch, <-e.spans)
+	}
+	if len(batch) != 3 {
+		t.Fatalf("expect 3 spans, got %d", len(batch))
+	}
+	root := batch[2]
+	if root.parentId != "" || batch[0].parentId != root.spanId || batch[1].parentId != root.spanId {
+		t.Error("storage and processor spans should be children of url")
+	}
+	if batch[0].traceId != root.traceId || len(root.traceId) != 32 || len(root.spanId) != 16 {
+		t.Error("invalid trace ids")
+	}
+	if len(batch[1].events) != 1 {
+		t.Error("error event should be added to the open span")
+	}
+	if err := e.export(batch); err != nil {
+		t.Fatal(err)
+	}
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					Name   string `json:"name"`
+					Status struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 3 || spans[1].Name != "Command Resize" || spans[1].Status.Code != 2 || spans[2].Status.Code != 1 {
+		t.Errorf("unexpected request %s", body)
+	}
+}
@@ -1,27 +1,24 @@
 package util
 
 import "os"
-import cat "github.com/ctripcorp/cat.go"
 
-//get running environment from environment variable 'NEPHELE_ENV'
-//only support env 'uat' and 'prod'
-func GetRunningEnv() string {
-	var env string
-	if os.Getenv("NEPHELE_ENV") == "prod" {
-		env = "prod"
-	} else {
-		env = "uat"
-	}
-	return env
+//environment set by SetRunningEnv, NEPHELE_ENV if empty
+var runningEnv string
+
+//SetRunningEnv overrides environment variable 'NEPHELE_ENV'
+func SetRunningEnv(env string) {
+	runningEnv = env
 }
 
-//initial cat based on env
-func InitCat() {
-	switch GetRunningEnv() {
-	case "uat":
-		cat.CAT_HOST = cat.UAT
-	case "prod":
-		cat.CAT_HOST = cat.PROD
+//get running environment set by SetRunningEnv or from environment variable
+//'NEPHELE_ENV', 'uat' if neither is set. Any name is allowed, it selects
+//the config file ENV_conf.ini and the CAT server of the environment.
+func GetRunningEnv() string {
+	if runningEnv != "" {
+		return runningEnv
+	}
+	if env := os.Getenv("NEPHELE_ENV"); env != "" {
+		return env
 	}
-	cat.DOMAIN = "nephele"
+	return "uat"
 }
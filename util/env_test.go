@@ -0,0 +1,25 @@
+package util
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGetRunningEnv(t *testing.T) {
+	saved := os.Getenv("NEPHELE_ENV")
+	defer os.Setenv("NEPHELE_ENV", saved)
+	defer SetRunningEnv("")
+
+	os.Setenv("NEPHELE_ENV", "")
+	if GetRunningEnv() != "uat" {
+		t.Error("the default env should be uat")
+	}
+	os.Setenv("NEPHELE_ENV", "fat")
+	if GetRunningEnv() != "fat" {
+		t.Error("env should come from NEPHELE_ENV")
+	}
+	SetRunningEnv("prod")
+	if GetRunningEnv() != "prod" {
+		t.Error("SetRunningEnv should override NEPHELE_ENV")
+	}
+}
@@ -0,0 +1,243 @@
+//Package placeholder makes the blur-up placeholder of an image, a BlurHash
+//string, and its dominant and average colors, which front-ends show while
+//the image loads.
+package placeholder
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+)
+
+type Placeholder struct {
+	//BlurHash of 4x3 components, 3x4 for portrait images, see
+	//https://github.com/woltapp/blurhash
+	BlurHash string `json:"blurhash"`
+	//colors like #rrggbb, transparent pixels are left out
+	DominantColor string `json:"dominantColor"`
+	AverageColor  string `json:"averageColor"`
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+}
+
+const (
+	//longest side of the grid sampled from the image
+	gridSize = 64
+	//samples averaged into a grid pixel in each dimension
+	subSamples = 4
+)
+
+//pixel is premultiplied sRGB of 0~1
+type pixel struct {
+	r, g, b, a float64
+}
+
+//Decode decodes a jpeg, png or gif and makes its placeholder
+func Decode(bts []byte) (*Placeholder, error) {
+	img, _, err := image.Decode(bytes.NewReader(bts))
+	if err != nil {
+		return nil, err
+	}
+	return Make(img), nil
+}
+
+//Make samples img on a grid of at most 64 pixels a side, so that the cost
+//doesn't grow with the size of the image
+func Make(img image.Image) *Placeholder {
+	size := img.Bounds().Size()
+	p := &Placeholder{Width: size.X, Height: size.Y}
+	pixels, w, h := sample(img)
+	p.AverageColor, p.DominantColor = colors(pixels)
+	if w >= h {
+		p.BlurHash = blurHash(pixels, w, h, 4, 3)
+	} else {
+		p.BlurHash = blurHash(pixels, w, h, 3, 4)
+	}
+	return p
+}
+
+func sample(img image.Image) ([]pixel, int, int) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, 0, 0
+	}
+	w, h := width, height
+	if w > gridSize || h > gridSize {
+		if w >= h {
+			w, h = gridSize, maxInt(1, height*gridSize/width)
+		} else {
+			w, h = maxInt(1, width*gridSize/height), gridSize
+		}
+	}
+	pixels := make([]pixel, 0, w*h)
+	for gy := 0; gy < h; gy++ {
+		for gx := 0; gx < w; gx++ {
+			var p pixel
+			for sy := 0; sy < subSamples; sy++ {
+				y := bounds.Min.Y + ((2*gy+1)*subSamples+2*sy+1-subSamples)*height/(2*h*subSamples)
+				for sx := 0; sx < subSamples; sx++ {
+					x := bounds.Min.X + ((2*gx+1)*subSamples+2*sx+1-subSamples)*width/(2*w*subSamples)
+					r, g, b, a := img.At(x, y).RGBA()
+					p.r += float64(r)
+					p.g += float64(g)
+					p.b += float64(b)
+					p.a += float64(a)
+				}
+			}
+			n := float64(subSamples * subSamples * 0xffff)
+			pixels = append(pixels, pixel{p.r / n, p.g / n, p.b / n, p.a / n})
+		}
+	}
+	return pixels, w, h
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+//colors returns the average color weighted by alpha, and the mean of the
+//most weighted bucket of 4 bits per channel among the pixels which are at
+//least half opaque. Transparent images are white.
+func colors(pixels []pixel) (string, string) {
+	type bucket struct {
+		r, g, b, weight float64
+	}
+	var sum pixel
+	buckets := make(map[int]*bucket)
+	var dominant *bucket
+	dominantKey := 0
+	for _, p := range pixels {
+		sum.r, sum.g, sum.b, sum.a = sum.r+p.r, sum.g+p.g, sum.b+p.b, sum.a+p.a
+		if p.a < 0.5 {
+			continue
+		}
+		r, g, b := p.r/p.a, p.g/p.a, p.b/p.a
+		key := channel8(r)>>4<<8 | channel8(g)>>4<<4 | channel8(b)>>4
+		bk, ok := buckets[key]
+		if !ok {
+			bk = &bucket{}
+			buckets[key] = bk
+		}
+		bk.r, bk.g, bk.b, bk.weight = bk.r+p.r, bk.g+p.g, bk.b+p.b, bk.weight+p.a
+		//ties go to the lower key, so that the result doesn't depend on the
+		//order of the map
+		if dominant == nil || bk.weight > dominant.weight || (bk.weight == dominant.weight && key < dominantKey) {
+			dominant, dominantKey = bk, key
+		}
+	}
+	if sum.a == 0 {
+		return "#ffffff", "#ffffff"
+	}
+	average := hexColor(sum.r/sum.a, sum.g/sum.a, sum.b/sum.a)
+	if dominant == nil {
+		return average, average
+	}
+	return average, hexColor(dominant.r/dominant.weight, dominant.g/dominant.weight, dominant.b/dominant.weight)
+}
+
+func channel8(v float64) int {
+	return int(math.Max(0, math.Min(255, math.Floor(v*255+0.5))))
+}
+
+func hexColor(r, g, b float64) string {
+	const digits = "0123456789abcdef"
+	bts := []byte{'#', 0, 0, 0, 0, 0, 0}
+	for i, v := range []float64{r, g, b} {
+		c := channel8(v)
+		bts[1+2*i], bts[2+2*i] = digits[c>>4], digits[c&0x0f]
+	}
+	return string(bts)
+}
+
+//blurHash encodes the pixels flattened on white by the cosine components
+//of the reference implementation
+func blurHash(pixels []pixel, w, h, componentsX, componentsY int) string {
+	if len(pixels) == 0 {
+		return ""
+	}
+	linear := make([][3]float64, len(pixels))
+	for i, p := range pixels {
+		linear[i] = [3]float64{srgbToLinear(p.r + 1 - p.a), srgbToLinear(p.g + 1 - p.a), srgbToLinear(p.b + 1 - p.a)}
+	}
+	factors := make([][3]float64, 0, componentsX*componentsY)
+	for j := 0; j < componentsY; j++ {
+		for i := 0; i < componentsX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := normalisation * math.Cos(math.Pi*float64(i*x)/float64(w)) * math.Cos(math.Pi*float64(j*y)/float64(h))
+					c := linear[y*w+x]
+					f[0], f[1], f[2] = f[0]+basis*c[0], f[1]+basis*c[1], f[2]+basis*c[2]
+				}
+			}
+			scale := 1 / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	hash := base83((componentsX-1)+(componentsY-1)*9, 1)
+	maximumValue := 1.0
+	ac := factors[1:]
+	if len(ac) > 0 {
+		actualMaximum := 0.0
+		for _, f := range ac {
+			actualMaximum = math.Max(actualMaximum, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+		maximumValue = float64(quantisedMaximum+1) / 166
+		hash += base83(quantisedMaximum, 1)
+	} else {
+		hash += base83(0, 1)
+	}
+	dc := factors[0]
+	hash += base83(linearToSrgb(dc[0])<<16|linearToSrgb(dc[1])<<8|linearToSrgb(dc[2]), 4)
+	for _, f := range ac {
+		quant := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximumValue, 0.5)*9+9.5))))
+		}
+		hash += base83(quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2)
+	}
+	return hash
+}
+
+func srgbToLinear(v float64) float64 {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+const base83Digits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func base83(value, length int) string {
+	bts := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		bts[i] = base83Digits[value%83]
+		value /= 83
+	}
+	return string(bts)
+}
@@ -0,0 +1,53 @@
+package placeholder
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"testing"
+)
+
+func TestSolid(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{255, 0, 0, 255}}, image.ZP, draw.Src)
+	p := Make(img)
+	if p.Width != 300 || p.Height != 200 || p.AverageColor != "#ff0000" || p.DominantColor != "#ff0000" {
+		t.Errorf("unexpected placeholder %+v", p)
+	}
+	//size flag of 4x3, maximum of the ac components, dc and 11 ac
+	if len(p.BlurHash) != 28 || p.BlurHash[0] != 'L' || p.BlurHash[2:6] != base83(0xff0000, 4) {
+		t.Errorf("unexpected blurhash %s", p.BlurHash)
+	}
+}
+
+func TestColors(t *testing.T) {
+	//3/4 blue and 1/4 white, portrait
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 400))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.NRGBA{0, 0, 255, 255}}, image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(0, 300, 100, 400), &image.Uniform{color.White}, image.ZP, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.DominantColor != "#0000ff" || p.AverageColor != "#4040ff" {
+		t.Errorf("unexpected colors %+v", p)
+	}
+	if len(p.BlurHash) != 28 || p.BlurHash[0] != base83Digits[2+3*9] {
+		t.Errorf("blurhash %s should have 3x4 components", p.BlurHash)
+	}
+
+	//transparent pixels are left out of the colors
+	draw.Draw(img, image.Rect(0, 0, 100, 300), &image.Uniform{color.Transparent}, image.ZP, draw.Src)
+	if p = Make(img); p.DominantColor != "#ffffff" || p.AverageColor != "#ffffff" {
+		t.Errorf("unexpected colors %+v of a transparent image", p)
+	}
+	if p = Make(image.NewNRGBA(image.Rect(0, 0, 10, 10))); p.AverageColor != "#ffffff" {
+		t.Errorf("unexpected colors %+v of a blank image", p)
+	}
+}
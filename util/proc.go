@@ -0,0 +1,26 @@
+package util
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//ProcessRSS reads the resident set size in bytes of a process from /proc
+func ProcessRSS(pid int) (int64, error) {
+	bts, err := ioutil.ReadFile(JoinString("/proc/", strconv.Itoa(pid), "/statm"))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(bts))
+	if len(fields) < 2 {
+		return 0, errors.New("unexpected statm: " + string(bts))
+	}
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * int64(os.Getpagesize()), nil
+}
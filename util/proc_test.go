@@ -0,0 +1,22 @@
+package util
+
+import (
+	"os"
+	"testing"
+)
+
+func TestProcessRSS(t *testing.T) {
+	if _, err := os.Stat("/proc/self/statm"); err != nil {
+		t.Skip("no /proc")
+	}
+	rss, err := ProcessRSS(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rss <= 0 {
+		t.Error("rss should be positive")
+	}
+	if _, err = ProcessRSS(-1); err == nil {
+		t.Error("rss of a missing process should fail")
+	}
+}
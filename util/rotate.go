@@ -0,0 +1,88 @@
+package util
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//RotateWriter appends to path and renames it to path.1 (path.1 to path.2,
+//and so on) once it exceeds maxSize bytes, keeping maxBackups old files.
+//Several processes may share path: the size is read from the file, and a
+//writer reopens path after another process has rotated it.
+type RotateWriter struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	//how often the file is checked
+	interval time.Duration
+	checked  time.Time
+	file     *os.File
+	mutex    sync.Mutex
+}
+
+//NewRotateWriter creates the writer, maxSize 0 means never rotate
+func NewRotateWriter(path string, maxSize int64, maxBackups int) *RotateWriter {
+	return &RotateWriter{path: path, maxSize: maxSize, maxBackups: maxBackups, interval: time.Second}
+}
+
+func (this *RotateWriter) Write(p []byte) (int, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if err := this.check(); err != nil {
+		return 0, err
+	}
+	return this.file.Write(p)
+}
+
+func (this *RotateWriter) Close() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.file == nil {
+		return nil
+	}
+	err := this.file.Close()
+	this.file = nil
+	return err
+}
+
+//check rotates the file if it is too large and reopens it if it has been
+//rotated, at most once per interval
+func (this *RotateWriter) check() error {
+	if this.file != nil && time.Since(this.checked) < this.interval {
+		return nil
+	}
+	this.checked = time.Now()
+	info, err := os.Stat(this.path)
+	if err == nil && this.maxSize > 0 && info.Size() >= this.maxSize {
+		this.rotate()
+		info = nil
+	}
+	if this.file != nil {
+		if info != nil {
+			if current, err := this.file.Stat(); err == nil && os.SameFile(current, info) {
+				return nil
+			}
+		}
+		this.file.Close()
+		this.file = nil
+	}
+	this.file, err = os.OpenFile(this.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	return err
+}
+
+func (this *RotateWriter) rotate() {
+	if this.maxBackups < 1 {
+		os.Remove(this.path)
+		return
+	}
+	for i := this.maxBackups - 1; i > 0; i-- {
+		os.Rename(this.backup(i), this.backup(i+1))
+	}
+	os.Rename(this.path, this.backup(1))
+}
+
+func (this *RotateWriter) backup(i int) string {
+	return JoinString(this.path, ".", strconv.Itoa(i))
+}
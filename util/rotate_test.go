@@ -0,0 +1,50 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	w := NewRotateWriter(path, 15, 2)
+	w.interval = 0
+	other := NewRotateWriter(path, 15, 2)
+	other.interval = 0
+	write := func(w *RotateWriter, s string) {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(other, "aaaaaaaaaa")
+	write(w, "bbbbbbbbbb")
+	//other rotates, w should reopen the new file
+	write(other, "cc")
+	write(w, "dd")
+	write(w, "eeeeeeeeeee")
+	write(w, "f")
+	write(w, "ggggggggggggggg")
+	write(w, "h")
+	w.Close()
+	other.Close()
+	for name, expect := range map[string]string{
+		"access.log":   "h",
+		"access.log.1": "fggggggggggggggg",
+		"access.log.2": "ccddeeeeeeeeeee",
+	} {
+		bts, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		if string(bts) != expect {
+			t.Error(name + " should be " + expect + ", got " + string(bts))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("only 2 backups should be kept")
+	}
+}
@@ -0,0 +1,40 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//SignToken returns a token valid until expires: "EXPIRES:HMAC", EXPIRES is
+//a unix timestamp and HMAC is the hex HMAC-SHA256 of EXPIRES with secret
+func SignToken(secret string, expires time.Time) string {
+	ts := strconv.FormatInt(expires.Unix(), 10)
+	return ts + ":" + signTimestamp(secret, ts)
+}
+
+//VerifyToken checks a token made by SignToken, an empty secret accepts
+//nothing
+func VerifyToken(secret, token string, now time.Time) bool {
+	if secret == "" {
+		return false
+	}
+	arr := strings.Split(token, ":")
+	if len(arr) != 2 {
+		return false
+	}
+	expires, err := strconv.ParseInt(arr[0], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(arr[1]), []byte(signTimestamp(secret, arr[0])))
+}
+
+func signTimestamp(secret, ts string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	return hex.EncodeToString(mac.Sum(nil))
+}
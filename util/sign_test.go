@@ -0,0 +1,28 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyToken(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	token := SignToken("secret", now.Add(time.Minute))
+	if !VerifyToken("secret", token, now) {
+		t.Error("token should be valid")
+	}
+	if VerifyToken("other", token, now) {
+		t.Error("token of another secret should be invalid")
+	}
+	if VerifyToken("secret", token, now.Add(2*time.Minute)) {
+		t.Error("expired token should be invalid")
+	}
+	if VerifyToken("", SignToken("", now.Add(time.Minute)), now) {
+		t.Error("empty secret should accept nothing")
+	}
+	for _, s := range []string{"", "1500000060", "a:b", "1500000060:00"} {
+		if VerifyToken("secret", s, now) {
+			t.Error("malformed token should be invalid: " + s)
+		}
+	}
+}